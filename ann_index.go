@@ -0,0 +1,596 @@
+// Copyright (c) 2025 Daniel Serrano Armenta. dani.eus79@gmail.com Todos los derechos reservados.
+
+package main
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// --- Índice ANN (HNSW) para el Historial Semántico ---
+//
+// Implementación en proceso de "Hierarchical Navigable Small World" (Malkov & Yashunin).
+// Cada nodo se identifica por su posición en semanticHistory. Los vectores se normalizan
+// al insertarlos, así que la distancia es simplemente 1 - producto escalar.
+//
+// El grafo se persiste junto al historial (.terminal_ia_embeddings.hnsw) al salir. Si al
+// arrancar el archivo tiene menos nodos que el historial (p.ej. la sesión anterior no
+// terminó limpiamente) se insertan los que faltan; si el modelo o la dimensión no
+// coinciden, se reconstruye desde cero.
+
+const (
+	hnswDefaultM              = 16
+	hnswDefaultEfConstruction = 64
+	hnswDefaultEfSearch       = 64
+	hnswFileVersion           = 1
+)
+
+var hnswFileMagic = [8]byte{'T', 'I', 'A', 'H', 'N', 'S', 'W', hnswFileVersion}
+
+type hnswNode struct {
+	vector    []float32
+	neighbors [][]int32 // Un slice de vecinos por nivel (0..level)
+}
+
+type hnswIndex struct {
+	mu sync.RWMutex
+
+	model          string
+	dim            int
+	m              int
+	mMax0          int
+	efConstruction int
+	efSearch       int
+	levelMult      float64
+
+	nodes    []hnswNode
+	entry    int32
+	maxLevel int
+	rng      *rand.Rand
+}
+
+// hnswCandidate es un par (nodo, distancia) usado en las colas de prioridad.
+type hnswCandidate struct {
+	id   int32
+	dist float32
+}
+
+// minCandidateHeap ordena por distancia ascendente (candidatos a explorar).
+type minCandidateHeap []hnswCandidate
+
+func (h minCandidateHeap) Len() int           { return len(h) }
+func (h minCandidateHeap) Less(i, j int) bool { return h[i].dist < h[j].dist }
+func (h minCandidateHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *minCandidateHeap) Push(x any)        { *h = append(*h, x.(hnswCandidate)) }
+func (h *minCandidateHeap) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// maxCandidateHeap ordena por distancia descendente (resultados, el peor arriba).
+type maxCandidateHeap []hnswCandidate
+
+func (h maxCandidateHeap) Len() int           { return len(h) }
+func (h maxCandidateHeap) Less(i, j int) bool { return h[i].dist > h[j].dist }
+func (h maxCandidateHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *maxCandidateHeap) Push(x any)        { *h = append(*h, x.(hnswCandidate)) }
+func (h *maxCandidateHeap) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// newHNSWIndex crea un índice vacío para un modelo de embeddings y una dimensión.
+func newHNSWIndex(model string, dim int) *hnswIndex {
+	return &hnswIndex{
+		model:          model,
+		dim:            dim,
+		m:              hnswDefaultM,
+		mMax0:          2 * hnswDefaultM,
+		efConstruction: hnswDefaultEfConstruction,
+		efSearch:       hnswDefaultEfSearch,
+		levelMult:      1 / math.Log(float64(hnswDefaultM)),
+		entry:          -1,
+		rng:            rand.New(rand.NewSource(42)),
+	}
+}
+
+// normalizeVector normaliza v in situ (la similitud de coseno no depende de la escala).
+func normalizeVector(v []float32) {
+	mag := magnitude(v)
+	if mag == 0 {
+		return
+	}
+	inv := float32(1 / mag)
+	for i := range v {
+		v[i] *= inv
+	}
+}
+
+// normalizedCopy devuelve una copia normalizada de v.
+func normalizedCopy(v []float32) []float32 {
+	c := make([]float32, len(v))
+	copy(c, v)
+	normalizeVector(c)
+	return c
+}
+
+func (idx *hnswIndex) distance(a, b []float32) float32 {
+	// Bucle desenrollado: es la operación más caliente tanto al insertar como al buscar.
+	var s0, s1, s2, s3 float32
+	i := 0
+	b = b[:len(a)]
+	for ; i+4 <= len(a); i += 4 {
+		s0 += a[i] * b[i]
+		s1 += a[i+1] * b[i+1]
+		s2 += a[i+2] * b[i+2]
+		s3 += a[i+3] * b[i+3]
+	}
+	for ; i < len(a); i++ {
+		s0 += a[i] * b[i]
+	}
+	return 1 - (s0 + s1 + s2 + s3)
+}
+
+// Len devuelve el número de nodos del índice.
+func (idx *hnswIndex) Len() int {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return len(idx.nodes)
+}
+
+// Add inserta el vector con el identificador id, que debe ser igual a Len(). El índice
+// guarda una copia normalizada; el slice del llamador no se modifica.
+func (idx *hnswIndex) Add(id int, vector []float32) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if id != len(idx.nodes) {
+		return fmt.Errorf("id %d fuera de secuencia (esperado %d)", id, len(idx.nodes))
+	}
+	if idx.dim == 0 {
+		idx.dim = len(vector) // Índice vacío: la dimensión la fija el primer vector
+	}
+	if len(vector) == 0 || len(vector) != idx.dim {
+		// Vector ausente o de otro modelo: se reserva el hueco para mantener los ids
+		// alineados, pero no se enlaza en el grafo.
+		idx.nodes = append(idx.nodes, hnswNode{})
+		return fmt.Errorf("dimensión %d distinta de la del índice (%d)", len(vector), idx.dim)
+	}
+	vector = normalizedCopy(vector)
+
+	level := int(math.Floor(-math.Log(1-idx.rng.Float64()) * idx.levelMult))
+	node := hnswNode{vector: vector, neighbors: make([][]int32, level+1)}
+	idx.nodes = append(idx.nodes, node)
+	q := int32(id)
+
+	if idx.entry < 0 {
+		idx.entry = q
+		idx.maxLevel = level
+		return nil
+	}
+
+	ep := idx.entry
+	epDist := idx.distance(vector, idx.nodes[ep].vector)
+	for lc := idx.maxLevel; lc > level; lc-- {
+		ep, epDist = idx.greedyClosest(vector, ep, epDist, lc)
+	}
+
+	entryPoints := []hnswCandidate{{id: ep, dist: epDist}}
+	for lc := min(level, idx.maxLevel); lc >= 0; lc-- {
+		candidates := idx.searchLayer(vector, entryPoints, idx.efConstruction, lc)
+		maxConn := idx.m
+		if lc == 0 {
+			maxConn = idx.mMax0
+		}
+		selected := idx.selectNeighbors(candidates, idx.m)
+		idx.nodes[q].neighbors[lc] = selected
+
+		for _, nb := range selected {
+			nbNode := &idx.nodes[nb]
+			nbNode.neighbors[lc] = append(nbNode.neighbors[lc], q)
+			if len(nbNode.neighbors[lc]) > maxConn {
+				idx.shrinkNeighbors(nb, lc, maxConn)
+			}
+		}
+		entryPoints = candidates
+	}
+
+	if level > idx.maxLevel {
+		idx.maxLevel = level
+		idx.entry = q
+	}
+	return nil
+}
+
+// greedyClosest baja por un nivel superior quedándose siempre con el vecino más cercano.
+func (idx *hnswIndex) greedyClosest(q []float32, ep int32, epDist float32, level int) (int32, float32) {
+	for changed := true; changed; {
+		changed = false
+		for _, nb := range idx.nodes[ep].neighbors[level] {
+			if d := idx.distance(q, idx.nodes[nb].vector); d < epDist {
+				ep, epDist = nb, d
+				changed = true
+			}
+		}
+	}
+	return ep, epDist
+}
+
+// searchLayer devuelve hasta ef candidatos del nivel indicado, ordenados por distancia.
+func (idx *hnswIndex) searchLayer(q []float32, entryPoints []hnswCandidate, ef int, level int) []hnswCandidate {
+	visited := make(map[int32]struct{}, ef*4)
+	candidates := &minCandidateHeap{}
+	results := &maxCandidateHeap{}
+	for _, ep := range entryPoints {
+		visited[ep.id] = struct{}{}
+		heap.Push(candidates, ep)
+		heap.Push(results, ep)
+		if results.Len() > ef {
+			heap.Pop(results)
+		}
+	}
+
+	for candidates.Len() > 0 {
+		c := heap.Pop(candidates).(hnswCandidate)
+		if results.Len() >= ef && c.dist > (*results)[0].dist {
+			break
+		}
+		for _, nb := range idx.nodes[c.id].neighbors[level] {
+			if _, seen := visited[nb]; seen {
+				continue
+			}
+			visited[nb] = struct{}{}
+			d := idx.distance(q, idx.nodes[nb].vector)
+			if results.Len() < ef || d < (*results)[0].dist {
+				heap.Push(candidates, hnswCandidate{id: nb, dist: d})
+				heap.Push(results, hnswCandidate{id: nb, dist: d})
+				if results.Len() > ef {
+					heap.Pop(results)
+				}
+			}
+		}
+	}
+
+	out := make([]hnswCandidate, results.Len())
+	for i := len(out) - 1; i >= 0; i-- {
+		out[i] = heap.Pop(results).(hnswCandidate)
+	}
+	return out
+}
+
+// selectNeighbors aplica la heurística de diversidad de HNSW: un candidato sólo se
+// conecta si está más cerca de q que de cualquier vecino ya elegido. Si no se llenan
+// los m huecos, se completan con los descartados más cercanos.
+func (idx *hnswIndex) selectNeighbors(candidates []hnswCandidate, m int) []int32 {
+	selected := make([]int32, 0, m)
+	var discarded []int32
+	for _, c := range candidates {
+		if len(selected) >= m {
+			break
+		}
+		good := true
+		for _, s := range selected {
+			if idx.distance(idx.nodes[c.id].vector, idx.nodes[s].vector) < c.dist {
+				good = false
+				break
+			}
+		}
+		if good {
+			selected = append(selected, c.id)
+		} else {
+			discarded = append(discarded, c.id)
+		}
+	}
+	for _, d := range discarded {
+		if len(selected) >= m {
+			break
+		}
+		selected = append(selected, d)
+	}
+	return selected
+}
+
+// shrinkNeighbors recorta la lista de vecinos de un nodo que ha superado maxConn,
+// quedándose con los más cercanos. (Aplicar aquí también la heurística de diversidad
+// multiplica el coste de inserción sin mejorar apenas el recall.)
+func (idx *hnswIndex) shrinkNeighbors(id int32, level int, maxConn int) {
+	node := &idx.nodes[id]
+	candidates := make([]hnswCandidate, len(node.neighbors[level]))
+	for i, nb := range node.neighbors[level] {
+		candidates[i] = hnswCandidate{id: nb, dist: idx.distance(node.vector, idx.nodes[nb].vector)}
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].dist < candidates[j].dist })
+	kept := node.neighbors[level][:0]
+	for _, c := range candidates[:maxConn] {
+		kept = append(kept, c.id)
+	}
+	node.neighbors[level] = kept
+}
+
+// Search devuelve los k vecinos aproximados más cercanos a query, con su similitud de coseno.
+func (idx *hnswIndex) Search(query []float32, k int) []hnswCandidate {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	if idx.entry < 0 || len(query) != idx.dim {
+		return nil
+	}
+	q := normalizedCopy(query)
+
+	ep := idx.entry
+	epDist := idx.distance(q, idx.nodes[ep].vector)
+	for lc := idx.maxLevel; lc > 0; lc-- {
+		ep, epDist = idx.greedyClosest(q, ep, epDist, lc)
+	}
+	results := idx.searchLayer(q, []hnswCandidate{{id: ep, dist: epDist}}, max(idx.efSearch, k), 0)
+	if len(results) > k {
+		results = results[:k]
+	}
+	for i := range results {
+		results[i].dist = 1 - results[i].dist // Devolver similitud en lugar de distancia
+	}
+	return results
+}
+
+// --- Persistencia del Índice ---
+
+// Save escribe el grafo (sin los vectores, que ya están en el historial) de forma atómica.
+func (idx *hnswIndex) Save(path string) error {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".terminal_ia_embeddings-*.hnsw.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	crc := crc32.NewIEEE()
+	w := bufio.NewWriter(io.MultiWriter(tmp, crc))
+	le := binary.LittleEndian
+	w.Write(hnswFileMagic[:])
+	binary.Write(w, le, uint32(len(idx.model)))
+	w.WriteString(idx.model)
+	binary.Write(w, le, uint32(idx.dim))
+	binary.Write(w, le, uint32(len(idx.nodes)))
+	binary.Write(w, le, idx.entry)
+	binary.Write(w, le, uint32(idx.maxLevel))
+	for _, node := range idx.nodes {
+		binary.Write(w, le, uint32(len(node.neighbors)))
+		for _, level := range node.neighbors {
+			binary.Write(w, le, uint32(len(level)))
+			binary.Write(w, le, level)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	binary.Write(tmp, le, crc.Sum32())
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// loadHNSWIndex lee el grafo persistido y lo enlaza con los vectores del historial.
+// Devuelve error si el archivo no corresponde al modelo/dimensión o al historial dados.
+func loadHNSWIndex(path string, model string, dim int, entries []SemanticHistoryEntry) (*hnswIndex, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < len(hnswFileMagic)+4 {
+		return nil, errors.New("archivo de índice truncado")
+	}
+	payload, sum := data[:len(data)-4], binary.LittleEndian.Uint32(data[len(data)-4:])
+	if crc32.ChecksumIEEE(payload) != sum {
+		return nil, errors.New("CRC del índice inválido")
+	}
+
+	r := &hnswReader{data: payload}
+	var magic [8]byte
+	copy(magic[:], r.bytes(8))
+	if magic != hnswFileMagic {
+		return nil, errors.New("el archivo no es un índice HNSW válido")
+	}
+	fileModel := string(r.bytes(int(r.u32())))
+	fileDim := int(r.u32())
+	if fileModel != model || fileDim != dim {
+		return nil, fmt.Errorf("índice creado con %s/%d, se esperaba %s/%d", fileModel, fileDim, model, dim)
+	}
+
+	count := int(r.u32())
+	if count > len(entries) {
+		return nil, fmt.Errorf("el índice tiene %d nodos pero el historial sólo %d", count, len(entries))
+	}
+	idx := newHNSWIndex(model, dim)
+	idx.entry = int32(r.u32())
+	idx.maxLevel = int(r.u32())
+	idx.nodes = make([]hnswNode, count)
+	for i := range idx.nodes {
+		levels := int(r.u32())
+		if r.err != nil || levels > 64 {
+			return nil, errors.New("índice corrupto")
+		}
+		node := hnswNode{neighbors: make([][]int32, levels)}
+		for l := range node.neighbors {
+			n := int(r.u32())
+			if r.err != nil || n > 4*idx.mMax0 {
+				return nil, errors.New("índice corrupto")
+			}
+			node.neighbors[l] = make([]int32, n)
+			for j := range node.neighbors[l] {
+				nb := int32(r.u32())
+				if nb < 0 || int(nb) >= count {
+					return nil, errors.New("índice corrupto")
+				}
+				node.neighbors[l][j] = nb
+			}
+		}
+		if levels > 0 {
+			if len(entries[i].Embedding) != dim || entries[i].Model != model {
+				return nil, errors.New("el índice no corresponde al historial")
+			}
+			node.vector = normalizedCopy(entries[i].Embedding)
+		}
+		idx.nodes[i] = node
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	if count > 0 && (idx.entry < 0 || int(idx.entry) >= count) {
+		return nil, errors.New("punto de entrada del índice inválido")
+	}
+	return idx, nil
+}
+
+// hnswReader es un lector mínimo sobre un buffer que acumula el primer error.
+type hnswReader struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+func (r *hnswReader) bytes(n int) []byte {
+	if r.err != nil || n < 0 || r.pos+n > len(r.data) {
+		r.err = errors.New("índice truncado")
+		return make([]byte, max(n, 0))
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b
+}
+
+func (r *hnswReader) u32() uint32 {
+	return binary.LittleEndian.Uint32(r.bytes(4))
+}
+
+// --- Integración con el Historial Semántico ---
+
+// semanticIndexDimension devuelve la dimensión de los vectores del modelo de embeddings
+// en uso, o 0 si todavía no hay ninguno.
+func semanticIndexDimension(entries []SemanticHistoryEntry) int {
+	for i := len(entries) - 1; i >= 0; i-- {
+		if v := indexableVector(entries[i]); len(v) > 0 {
+			return len(v)
+		}
+	}
+	return 0
+}
+
+// loadSemanticIndex carga el índice persistido o lo reconstruye, y añade los comandos
+// del historial que todavía no estén indexados. Debe llamarse con semanticHistoryLock.
+func loadSemanticIndex() {
+	dim := semanticIndexDimension(semanticHistory)
+	idx, err := loadHNSWIndex(semanticIndexPath, embeddingModelName, dim, semanticHistory)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			fmt.Fprintln(os.Stderr, cSystem(fmt.Sprintf("Índice semántico descartado (%v). Se reconstruirá.", err)))
+		}
+		idx = newHNSWIndex(embeddingModelName, dim)
+	}
+
+	pending := len(semanticHistory) - idx.Len()
+	if pending > 1000 {
+		fmt.Println(cSystem(fmt.Sprintf("Indexando %d comandos del historial semántico...", pending)))
+	}
+	for i := idx.Len(); i < len(semanticHistory); i++ {
+		idx.Add(i, indexableVector(semanticHistory[i])) // Los vectores de otro modelo se ignoran
+	}
+	semanticIndex = idx
+}
+
+// saveSemanticIndex persiste el grafo del índice para no reconstruirlo en el próximo arranque.
+func saveSemanticIndex() {
+	if semanticIndex == nil || semanticIndexPath == "" || !ownsSemanticHistory() {
+		return
+	}
+	if err := semanticIndex.Save(semanticIndexPath); err != nil {
+		fmt.Fprintln(os.Stderr, cError(fmt.Sprintf("Error al guardar índice semántico: %v", err)))
+	}
+}
+
+// currentSemanticIndex devuelve el índice en uso. loadSemanticHistory, clearSemanticHistory
+// y /reindexar lo sustituyen bajo semanticHistoryLock, así que se lee con el mismo mutex.
+func currentSemanticIndex() *hnswIndex {
+	semanticHistoryLock.Lock()
+	defer semanticHistoryLock.Unlock()
+	return semanticIndex
+}
+
+// semanticSearchResult es un comando del historial con su similitud a la consulta.
+type semanticSearchResult struct {
+	Command string
+	Project string
+	Request string
+	Score   float64 // Similitud de coseno (sin la ventaja por proyecto)
+}
+
+// searchSemanticHistory devuelve los k comandos más parecidos a queryEmbedding. Si se
+// indica un proyecto, sus comandos reciben una pequeña ventaja al ordenar, de modo que
+// ante similitudes parecidas ganan los del proyecto actual. Un mismo comando guardado
+// en varios proyectos aparece una sola vez.
+// La búsqueda en el grafo no bloquea semanticHistoryLock; sólo se toma para leer el
+// índice actual y, al final, para traducir los ids a comandos.
+func searchSemanticHistory(queryEmbedding []float32, k int, project string) []semanticSearchResult {
+	index := currentSemanticIndex()
+	if index == nil {
+		return nil
+	}
+	candidates := index.Search(queryEmbedding, k+projectSearchFanout)
+
+	semanticHistoryLock.Lock()
+	results := make([]semanticSearchResult, 0, len(candidates))
+	for _, c := range candidates {
+		if int(c.id) < len(semanticHistory) {
+			entry := semanticHistory[c.id]
+			results = append(results, semanticSearchResult{
+				Command: entry.Command,
+				Project: entry.Project,
+				Request: entry.Request,
+				Score:   float64(c.dist),
+			})
+		}
+	}
+	semanticHistoryLock.Unlock()
+
+	rank := func(r semanticSearchResult) float64 {
+		if project != "" && r.Project == project {
+			return r.Score + projectScoreBoost
+		}
+		return r.Score
+	}
+	sort.SliceStable(results, func(i, j int) bool { return rank(results[i]) > rank(results[j]) })
+
+	seen := make(map[string]bool, len(results))
+	unique := results[:0]
+	for _, r := range results {
+		if !seen[r.Command] {
+			seen[r.Command] = true
+			unique = append(unique, r)
+		}
+	}
+	if len(unique) > k {
+		unique = unique[:k]
+	}
+	return unique
+}
@@ -0,0 +1,176 @@
+// Copyright (c) 2025 Daniel Serrano Armenta. dani.eus79@gmail.com Todos los derechos reservados.
+
+package main
+
+import (
+	"math/rand"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+)
+
+// clusteredGenerator devuelve un generador de vectores agrupados alrededor de centros,
+// como los embeddings reales de comandos (git, docker, ficheros...), en lugar de
+// repartidos uniformemente. Los datos y las consultas salen del mismo generador.
+func clusteredGenerator(rng *rand.Rand, dim int, clusters int) func(n int) [][]float32 {
+	randomVector := func() []float32 {
+		v := make([]float32, dim)
+		for i := range v {
+			v[i] = float32(rng.NormFloat64())
+		}
+		return v
+	}
+	centers := make([][]float32, max(clusters, 1))
+	for i := range centers {
+		centers[i] = randomVector()
+	}
+	return func(n int) [][]float32 {
+		vectors := make([][]float32, n)
+		for i := range vectors {
+			v := randomVector()
+			c := centers[rng.Intn(len(centers))]
+			for j := range v {
+				v[j] = c[j] + 0.6*v[j]
+			}
+			vectors[i] = v
+		}
+		return vectors
+	}
+}
+
+func buildTestIndex(tb testing.TB, vectors [][]float32) *hnswIndex {
+	tb.Helper()
+	idx := newHNSWIndex("test", len(vectors[0]))
+	for i, v := range vectors {
+		if err := idx.Add(i, v); err != nil {
+			tb.Fatalf("Add(%d): %v", i, err)
+		}
+	}
+	return idx
+}
+
+// exactNeighbors devuelve los k ids más parecidos a query por escaneo lineal.
+func exactNeighbors(vectors [][]float32, query []float32, k int) map[int32]bool {
+	exact := make([]hnswCandidate, len(vectors))
+	for i, v := range vectors {
+		exact[i] = hnswCandidate{id: int32(i), dist: float32(cosineSimilarity(query, v))}
+	}
+	sort.Slice(exact, func(i, j int) bool { return exact[i].dist > exact[j].dist })
+	truth := make(map[int32]bool, k)
+	for _, c := range exact[:k] {
+		truth[c.id] = true
+	}
+	return truth
+}
+
+func TestHNSWRecall(t *testing.T) {
+	const n, dim, k, queries = 5000, 64, 3, 100
+	rng := rand.New(rand.NewSource(1))
+	generate := clusteredGenerator(rng, dim, n/100)
+	vectors := generate(n)
+	idx := buildTestIndex(t, vectors)
+
+	hits := 0
+	for _, query := range generate(queries) {
+		truth := exactNeighbors(vectors, query, k)
+		for _, c := range idx.Search(query, k) {
+			if truth[c.id] {
+				hits++
+			}
+		}
+	}
+	if recall := float64(hits) / float64(queries*k); recall < 0.9 {
+		t.Errorf("recall@%d = %.2f, se esperaba al menos 0.90", k, recall)
+	}
+}
+
+func TestHNSWAddDoesNotModifyVector(t *testing.T) {
+	idx := newHNSWIndex("test", 3)
+	v := []float32{3, 4, 0}
+	if err := idx.Add(0, v); err != nil {
+		t.Fatal(err)
+	}
+	if v[0] != 3 || v[1] != 4 {
+		t.Errorf("Add modificó el vector del llamador: %v", v)
+	}
+	if got := idx.Search([]float32{6, 8, 0}, 1); len(got) != 1 || got[0].id != 0 || got[0].dist < 0.999 {
+		t.Errorf("Search = %v, se esperaba el nodo 0 con similitud 1", got)
+	}
+}
+
+func TestHNSWSaveLoad(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	vectors := clusteredGenerator(rng, 16, 5)(500)
+	idx := buildTestIndex(t, vectors)
+
+	entries := make([]SemanticHistoryEntry, len(vectors))
+	for i, v := range vectors {
+		entries[i] = SemanticHistoryEntry{Command: "cmd", Embedding: v, Model: "test"}
+	}
+	path := filepath.Join(t.TempDir(), "indice.hnsw")
+	if err := idx.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := loadHNSWIndex(path, "test", 16, entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Len() != idx.Len() {
+		t.Fatalf("Len = %d, se esperaba %d", loaded.Len(), idx.Len())
+	}
+	query := vectors[42]
+	want, got := idx.Search(query, 5), loaded.Search(query, 5)
+	for i := range want {
+		if want[i].id != got[i].id {
+			t.Fatalf("resultados distintos tras cargar: %v != %v", got, want)
+		}
+	}
+
+	if _, err := loadHNSWIndex(path, "otro-modelo", 16, entries); err == nil {
+		t.Error("se esperaba error al cargar con otro modelo")
+	}
+	if _, err := loadHNSWIndex(path, "test", 16, entries[:10]); err == nil {
+		t.Error("se esperaba error con un historial más corto que el índice")
+	}
+}
+
+// benchmarkFixture son los 100.000 vectores de dimensión 768 (la de nomic-embed-text)
+// de los benchmarks. Se construyen una sola vez: testing repite cada benchmark con
+// distintos b.N y reconstruir el índice cada vez dominaría el tiempo total.
+var benchmarkFixture = sync.OnceValues(func() ([][]float32, [][]float32) {
+	const n, dim = 100000, 768
+	generate := clusteredGenerator(rand.New(rand.NewSource(1)), dim, n/100)
+	return generate(n), generate(200)
+})
+
+var benchmarkIndex = sync.OnceValue(func() *hnswIndex {
+	vectors, _ := benchmarkFixture()
+	idx := newHNSWIndex("benchmark", len(vectors[0]))
+	for i, v := range vectors {
+		idx.Add(i, v)
+	}
+	return idx
+})
+
+// BenchmarkHNSWSearch mide la latencia de una consulta sobre 100.000 comandos.
+// Ejecutar con: go test -bench HNSW -run '^$'
+func BenchmarkHNSWSearch(b *testing.B) {
+	_, queries := benchmarkFixture()
+	idx := benchmarkIndex()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		idx.Search(queries[i%len(queries)], 3)
+	}
+}
+
+// BenchmarkLinearSearch es la referencia: el escaneo completo que sustituye el índice.
+func BenchmarkLinearSearch(b *testing.B) {
+	vectors, queries := benchmarkFixture()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		exactNeighbors(vectors, queries[i%len(queries)], 3)
+	}
+}
@@ -0,0 +1,401 @@
+// Copyright (c) 2025 Daniel Serrano Armenta. dani.eus79@gmail.com Todos los derechos reservados.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// --- Registro de Auditoría (/auditoria) ---
+//
+// Cada comando que ejecuta la IA (confirmado, en modo auto, autorizado por una política
+// o elegido en /buscar) se añade a ~/.local/share/terminal-ia/auditoria.jsonl. El archivo
+// sólo se abre en modo append y cada entrada incluye el hash de la anterior, de modo que
+// borrar o modificar una línea rompe la cadena y se detecta con "/auditoria verificar".
+
+const (
+	auditFileName      = "auditoria.jsonl"
+	auditDefaultListed = 20
+	auditTailWindow    = 64 * 1024 // Bytes leídos cada vez desde el final para encontrar la última entrada
+)
+
+// Modos de ejecución registrados.
+const (
+	auditModeConfirmed = "confirmado"
+	auditModeAuto      = "auto"
+	auditModePolicy    = "politica"
+	auditModeSearch    = "buscar"
+	auditModeMCP       = "mcp"         // Llamadas a herramientas MCP desde /chat
+	auditModeTool      = "herramienta" // Herramientas internas de /chat
+	auditModeAPI       = "api"         // Comandos aprobados en /v1/approve (terminal-ia serve)
+)
+
+// auditEntry es una línea del registro. El orden de los campos es parte del formato:
+// el hash se calcula sobre el JSON de la entrada con Hash vacío.
+type auditEntry struct {
+	Seq          int64  `json:"seq"`
+	Timestamp    string `json:"ts"`
+	User         string `json:"user"`
+	Host         string `json:"host"`
+	CWD          string `json:"cwd"`
+	Model        string `json:"model,omitempty"`
+	Request      string `json:"request,omitempty"`
+	Command      string `json:"command"`
+	Mode         string `json:"mode"`
+	ExitCode     int    `json:"exit_code"`
+	DurationMs   int64  `json:"duration_ms"`
+	OutputSHA256 string `json:"output_sha256"`
+	Prev         string `json:"prev"`
+	Hash         string `json:"hash"`
+}
+
+// lockedWriter serializa las escrituras de stdout y stderr sobre el mismo hash.
+type lockedWriter struct {
+	mu sync.Mutex
+	w  hash.Hash
+}
+
+func (l *lockedWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Write(p)
+}
+
+// auditFilePath devuelve la ruta del registro de auditoría.
+func auditFilePath() (string, error) {
+	dataHome := os.Getenv("XDG_DATA_HOME")
+	if dataHome == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dataHome = filepath.Join(home, ".local", "share")
+	}
+	return filepath.Join(dataHome, configDirName, auditFileName), nil
+}
+
+// computeAuditHash calcula el hash encadenado de una entrada.
+func computeAuditHash(entry auditEntry) string {
+	entry.Hash = ""
+	data, _ := json.Marshal(entry)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// runAuditedCommand ejecuta un comando de la IA con bash, mostrando su salida, y lo
+// añade al registro de auditoría. Devuelve el error de ejecución del comando.
+func runAuditedCommand(command string, request string, model string, mode string) error {
+	outputHash := &lockedWriter{w: sha256.New()}
+
+	start := time.Now()
+	runErr := runShellCommand(command, io.MultiWriter(os.Stdout, outputHash), io.MultiWriter(os.Stderr, outputHash))
+	duration := time.Since(start)
+
+	exitCode := 0
+	if runErr != nil {
+		exitCode = -1
+		var exitErr interface{ ExitCode() int }
+		if errors.As(runErr, &exitErr) {
+			exitCode = exitErr.ExitCode()
+		} else if errors.Is(runErr, errJobStopped) {
+			exitCode = 128 + int(syscall.SIGTSTP) // Como bash: el comando sigue detenido en "jobs"
+		} else if errors.Is(runErr, errTerminated) {
+			exitCode = 128 + int(syscall.SIGTERM)
+		}
+	}
+
+	writeAuditEntry(start, command, request, model, mode, exitCode, duration, hex.EncodeToString(outputHash.w.Sum(nil)))
+	return runErr
+}
+
+// recordAuditEntry registra una acción que no es un comando de shell (por ejemplo una
+// llamada a una herramienta MCP) con el hash de su salida.
+func recordAuditEntry(command string, request string, model string, mode string, exitCode int, duration time.Duration, output []byte) {
+	sum := sha256.Sum256(output)
+	writeAuditEntry(time.Now().Add(-duration), command, request, model, mode, exitCode, duration, hex.EncodeToString(sum[:]))
+}
+
+// writeAuditEntry completa los datos del entorno y añade la entrada al registro.
+func writeAuditEntry(start time.Time, command string, request string, model string, mode string, exitCode int, duration time.Duration, outputSHA256 string) {
+	cwd, _ := os.Getwd()
+	entry := auditEntry{
+		Timestamp:    start.Format(time.RFC3339Nano),
+		CWD:          cwd,
+		Model:        model,
+		Request:      request,
+		Command:      command,
+		Mode:         mode,
+		ExitCode:     exitCode,
+		DurationMs:   duration.Milliseconds(),
+		OutputSHA256: outputSHA256,
+	}
+	if u, err := user.Current(); err == nil {
+		entry.User = u.Username
+	}
+	entry.Host, _ = os.Hostname()
+	if err := appendAuditEntry(entry); err != nil {
+		fmt.Fprintln(os.Stderr, cError(fmt.Sprintf("Error al escribir el registro de auditoría: %v", err)))
+	}
+}
+
+// appendAuditEntry encadena la entrada con la última del archivo y la añade. Un bloqueo
+// flock evita que dos instancias de terminal-ia bifurquen la cadena.
+func appendAuditEntry(entry auditEntry) error {
+	path, err := auditFilePath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		return err
+	}
+	defer syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+
+	last, ok, err := readLastAuditEntry(f)
+	if err != nil {
+		return err
+	}
+	if ok {
+		entry.Seq = last.Seq + 1
+		entry.Prev = last.Hash
+	} else {
+		entry.Seq = 1
+	}
+	entry.Hash = computeAuditHash(entry)
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(data, '\n'))
+	return err
+}
+
+// readLastAuditEntry lee la última entrada del registro sin cargar el archivo entero:
+// lee bloques de auditTailWindow desde el final hasta encontrar el comienzo de la última
+// línea. Si esa línea no es una entrada válida devuelve un error, porque encadenar tras
+// ella (o empezar otra cadena) haría que la verificación lo tomara por una manipulación.
+func readLastAuditEntry(f *os.File) (auditEntry, bool, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return auditEntry{}, false, err
+	}
+	var tail []byte
+	for offset := info.Size(); offset > 0; {
+		start := max(offset-auditTailWindow, 0)
+		chunk := make([]byte, offset-start)
+		if _, err := f.ReadAt(chunk, start); err != nil && err != io.EOF {
+			return auditEntry{}, false, err
+		}
+		tail = append(chunk, tail...)
+		offset = start
+
+		trimmed := bytes.TrimRight(tail, "\n")
+		i := bytes.LastIndexByte(trimmed, '\n')
+		if i < 0 && offset > 0 {
+			continue // La última línea empieza antes de este bloque
+		}
+		line := trimmed[i+1:]
+		if len(line) == 0 {
+			return auditEntry{}, false, nil
+		}
+		var entry auditEntry
+		if err := json.Unmarshal(line, &entry); err != nil || entry.Hash == "" {
+			return auditEntry{}, false, fmt.Errorf("la última línea del registro de auditoría (%d bytes) no es una entrada válida; no se añaden más entradas para no romper la cadena de hashes (revísalo con /auditoria verificar)", len(line))
+		}
+		return entry, true, nil
+	}
+	return auditEntry{}, false, nil
+}
+
+// readAuditLog lee todas las entradas. Las líneas ilegibles se devuelven como error de
+// verificación en verifyAuditLog.
+func readAuditLog() ([]auditEntry, []int, error) {
+	path, err := auditFilePath()
+	if err != nil {
+		return nil, nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	var entries []auditEntry
+	var badLines []int
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		var entry auditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			badLines = append(badLines, n)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, badLines, scanner.Err()
+}
+
+// verifyAuditLog comprueba la cadena de hashes y devuelve los problemas encontrados.
+func verifyAuditLog(entries []auditEntry, badLines []int) []string {
+	var problems []string
+	for _, n := range badLines {
+		problems = append(problems, fmt.Sprintf("línea %d: no es una entrada válida", n))
+	}
+	prev := ""
+	for i, e := range entries {
+		if e.Hash != computeAuditHash(e) {
+			problems = append(problems, fmt.Sprintf("entrada %d: el contenido no coincide con su hash (modificada)", e.Seq))
+		}
+		if e.Prev != prev {
+			problems = append(problems, fmt.Sprintf("entrada %d: no enlaza con la anterior (falta o se ha alterado alguna entrada)", e.Seq))
+		}
+		if i > 0 && e.Seq != entries[i-1].Seq+1 {
+			problems = append(problems, fmt.Sprintf("entrada %d: secuencia discontinua tras la %d", e.Seq, entries[i-1].Seq))
+		}
+		prev = e.Hash
+	}
+	return problems
+}
+
+// handleAuditCommand implementa "/auditoria [filtros]" y "/auditoria verificar".
+// Filtros: --modo <modo>, --fallidos, --desde AAAA-MM-DD, --n <número> y un texto que
+// se busca en el comando y en la petición.
+func handleAuditCommand(args string) {
+	entries, badLines, err := readAuditLog()
+	if err != nil {
+		fmt.Println(cError(fmt.Sprintf("Error al leer el registro de auditoría: %v", err)))
+		fmt.Println()
+		return
+	}
+
+	if args == "verificar" {
+		problems := verifyAuditLog(entries, badLines)
+		if len(problems) == 0 {
+			fmt.Println(cIA(fmt.Sprintf("IA> Registro íntegro: %d entradas, cadena de hashes correcta.", len(entries))))
+		} else {
+			fmt.Println(cError(fmt.Sprintf("IA> El registro de auditoría tiene %d problemas:", len(problems))))
+			for _, p := range problems {
+				fmt.Println(cError("  - " + p))
+			}
+		}
+		fmt.Println()
+		return
+	}
+
+	// Filtros
+	limit := auditDefaultListed
+	var mode, text string
+	var since time.Time
+	failedOnly := false
+	fields := strings.Fields(args)
+	usage := "Uso: /auditoria [verificar] [--modo confirmado|auto|politica|buscar|mcp|herramienta|api] [--fallidos] [--desde AAAA-MM-DD] [--n N] [texto]"
+	for i := 0; i < len(fields); i++ {
+		switch fields[i] {
+		case "--modo", "--desde", "--n":
+			if i+1 >= len(fields) {
+				fmt.Println(cError(usage))
+				fmt.Println()
+				return
+			}
+			value := fields[i+1]
+			switch fields[i] {
+			case "--modo":
+				mode = value
+			case "--desde":
+				if since, err = time.ParseInLocation("2006-01-02", value, time.Local); err != nil {
+					fmt.Println(cError(usage))
+					fmt.Println()
+					return
+				}
+			case "--n":
+				if limit, err = strconv.Atoi(value); err != nil || limit <= 0 {
+					fmt.Println(cError(usage))
+					fmt.Println()
+					return
+				}
+			}
+			i++
+		case "--fallidos":
+			failedOnly = true
+		default:
+			text = strings.ToLower(strings.Join(fields[i:], " "))
+			i = len(fields)
+		}
+	}
+
+	var matched []auditEntry
+	for _, e := range entries {
+		if mode != "" && e.Mode != mode {
+			continue
+		}
+		if failedOnly && e.ExitCode == 0 {
+			continue
+		}
+		if !since.IsZero() {
+			if ts, err := time.Parse(time.RFC3339Nano, e.Timestamp); err != nil || ts.Before(since) {
+				continue
+			}
+		}
+		if text != "" && !strings.Contains(strings.ToLower(e.Command), text) && !strings.Contains(strings.ToLower(e.Request), text) {
+			continue
+		}
+		matched = append(matched, e)
+	}
+
+	if len(matched) == 0 {
+		fmt.Println(cSystem("IA> No hay entradas de auditoría que coincidan."))
+		fmt.Println()
+		return
+	}
+	if len(matched) > limit {
+		fmt.Println(cSystem(fmt.Sprintf("IA> Mostrando las últimas %d de %d entradas (usa --n para ver más).", limit, len(matched))))
+		matched = matched[len(matched)-limit:]
+	}
+	for _, e := range matched {
+		when := e.Timestamp
+		if ts, err := time.Parse(time.RFC3339Nano, e.Timestamp); err == nil {
+			when = ts.Local().Format("2006-01-02 15:04:05")
+		}
+		status := cIA("✔")
+		if e.ExitCode != 0 {
+			status = cError(fmt.Sprintf("✘ %d", e.ExitCode))
+		}
+		fmt.Printf("%s %s %s %s %s\n", cPrompt(fmt.Sprintf("#%d", e.Seq)), cSystem(when), cSystem(fmt.Sprintf("[%s]", e.Mode)), status, cSystem(fmt.Sprintf("%.1fs", float64(e.DurationMs)/1000)))
+		fmt.Println("    " + e.Command)
+		details := e.CWD
+		if e.Model != "" {
+			details += " · " + e.Model
+		}
+		if e.Request != "" {
+			details += " · \"" + e.Request + "\""
+		}
+		fmt.Println(cSystem("    " + details))
+	}
+	fmt.Println()
+}
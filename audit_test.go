@@ -0,0 +1,147 @@
+// Copyright (c) 2025 Daniel Serrano Armenta. dani.eus79@gmail.com Todos los derechos reservados.
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+)
+
+// writeTestAuditLog crea un registro con n entradas en un XDG_DATA_HOME temporal y
+// devuelve la ruta del archivo.
+func writeTestAuditLog(t *testing.T, n int) string {
+	t.Helper()
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+	for i := 0; i < n; i++ {
+		entry := auditEntry{Command: "echo " + strings.Repeat("x", i+1), Mode: auditModeConfirmed}
+		if err := appendAuditEntry(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path, err := auditFilePath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func verifyTestAuditLog(t *testing.T) []string {
+	t.Helper()
+	entries, badLines, err := readAuditLog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return verifyAuditLog(entries, badLines)
+}
+
+func TestAuditLogChain(t *testing.T) {
+	writeTestAuditLog(t, 3)
+	entries, _, err := readAuditLog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("%d entradas, se esperaban 3", len(entries))
+	}
+	for i, e := range entries {
+		if e.Seq != int64(i+1) {
+			t.Errorf("entrada %d con seq %d", i, e.Seq)
+		}
+		if i > 0 && e.Prev != entries[i-1].Hash {
+			t.Errorf("entrada %d no enlaza con la anterior", e.Seq)
+		}
+	}
+	if problems := verifyAuditLog(entries, nil); len(problems) != 0 {
+		t.Errorf("registro intacto con problemas: %v", problems)
+	}
+}
+
+func TestAuditLogTampering(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(lines [][]byte) [][]byte
+		want   string
+	}{
+		{"modificada", func(lines [][]byte) [][]byte {
+			lines[1] = bytes.Replace(lines[1], []byte(`"echo xx"`), []byte(`"rm -rf ~"`), 1)
+			return lines
+		}, "modificada"},
+		{"borrada", func(lines [][]byte) [][]byte {
+			return append(lines[:1], lines[2:]...)
+		}, "no enlaza"},
+		{"ilegible", func(lines [][]byte) [][]byte {
+			return append(lines, []byte("{basura"))
+		}, "no es una entrada válida"},
+		{"reordenada", func(lines [][]byte) [][]byte {
+			lines[0], lines[1] = lines[1], lines[0]
+			return lines
+		}, "no enlaza"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeTestAuditLog(t, 3)
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			lines := tt.tamper(bytes.Split(bytes.TrimRight(data, "\n"), []byte("\n")))
+			if err := os.WriteFile(path, append(bytes.Join(lines, []byte("\n")), '\n'), 0600); err != nil {
+				t.Fatal(err)
+			}
+			problems := verifyTestAuditLog(t)
+			found := false
+			for _, p := range problems {
+				found = found || strings.Contains(p, tt.want)
+			}
+			if !found {
+				t.Errorf("problemas %v, se esperaba uno con %q", problems, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuditLogContinuesChain(t *testing.T) {
+	writeTestAuditLog(t, 2)
+	// Una entrada añadida después (otra sesión) sigue la cadena existente
+	if err := appendAuditEntry(auditEntry{Command: "ls", Mode: auditModeAuto}); err != nil {
+		t.Fatal(err)
+	}
+	if problems := verifyTestAuditLog(t); len(problems) != 0 {
+		t.Errorf("problemas tras añadir: %v", problems)
+	}
+}
+
+func TestAuditLogLongLastEntry(t *testing.T) {
+	writeTestAuditLog(t, 1)
+	// Una entrada mayor que auditTailWindow no puede hacer que la cadena empiece de nuevo
+	long := auditEntry{Command: "echo " + strings.Repeat("y", 3*auditTailWindow), Mode: auditModeConfirmed}
+	if err := appendAuditEntry(long); err != nil {
+		t.Fatal(err)
+	}
+	if err := appendAuditEntry(auditEntry{Command: "ls", Mode: auditModeAuto}); err != nil {
+		t.Fatal(err)
+	}
+	if problems := verifyTestAuditLog(t); len(problems) != 0 {
+		t.Errorf("problemas tras una entrada larga: %v", problems)
+	}
+}
+
+func TestAuditLogRefusesCorruptTail(t *testing.T) {
+	path := writeTestAuditLog(t, 2)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"seq":3,"command":"echo a` + "\n")
+	f.Close()
+	before, _ := os.ReadFile(path)
+
+	if err := appendAuditEntry(auditEntry{Command: "ls", Mode: auditModeAuto}); err == nil {
+		t.Fatal("se esperaba un error con la última línea dañada")
+	}
+	if after, _ := os.ReadFile(path); !bytes.Equal(before, after) {
+		t.Error("no se debería añadir nada tras una línea dañada")
+	}
+}
@@ -0,0 +1,1170 @@
+// Copyright (c) 2025 Daniel Serrano Armenta. dani.eus79@gmail.com Todos los derechos reservados.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/ollama/ollama/api"
+	"github.com/peterh/liner"
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// --- Herramientas de /chat (tool calling) ---
+//
+// En /chat el modelo puede inspeccionar el proyecto con herramientas de sólo lectura:
+// read_file, list_dir y grep (limitadas al proyecto actual, o al CWD fuera de un
+// proyecto), git_diff y run_readonly_command (sólo programas de una lista de comandos
+// que no modifican nada). A ellas se suman las de los servidores MCP. Cada llamada se
+// muestra y se confirma antes de ejecutarse ("x" la permite sin preguntar durante la
+// sesión) y queda en el registro de auditoría.
+
+const (
+	chatMaxToolRounds    = 8         // Rondas de llamadas por mensaje de /chat
+	chatMaxToolResult    = 16 * 1024 // Resultado máximo que se devuelve al modelo
+	chatToolPreviewLines = 8
+	chatReadMaxLines     = 2000
+	chatReadDefaultLines = 400
+	chatListMaxEntries   = 300
+	chatListMaxDepth     = 3
+	chatGrepMaxMatches   = 100
+	chatGrepMaxFileSize  = 1024 * 1024
+	chatCommandTimeout   = 30 * time.Second
+	chatCommandMaxOutput = 32 * 1024
+)
+
+// chatTool es una herramienta interna de /chat.
+type chatTool struct {
+	def api.ToolFunction
+	run func(ctx context.Context, args api.ToolCallFunctionArguments) (string, error)
+}
+
+var (
+	trustedChatTools = map[string]bool{} // Herramientas aprobadas con "x" en esta sesión
+	chatNoToolsModel string              // Último modelo que respondió que no admite herramientas
+)
+
+// chatToolsEnabled indica si las herramientas internas están activas (por defecto sí).
+func chatToolsEnabled() bool {
+	return appConfig.ChatTools == nil || *appConfig.ChatTools
+}
+
+// toolParams construye los parámetros de una herramienta: nombre → (tipo, descripción).
+func toolParams(required []string, props map[string][2]string) api.ToolFunctionParameters {
+	params := api.ToolFunctionParameters{Type: "object", Required: required, Properties: map[string]api.ToolProperty{}}
+	for name, p := range props {
+		params.Properties[name] = api.ToolProperty{Type: api.PropertyType{p[0]}, Description: p[1]}
+	}
+	if params.Required == nil {
+		params.Required = []string{}
+	}
+	return params
+}
+
+// builtinChatTools son las herramientas internas, por nombre.
+var builtinChatTools = map[string]*chatTool{
+	"read_file": {
+		def: api.ToolFunction{
+			Name:        "read_file",
+			Description: "Lee un archivo de texto del proyecto actual, con números de línea.",
+			Parameters: toolParams([]string{"path"}, map[string][2]string{
+				"path":       {"string", "Ruta del archivo (relativa al directorio actual)"},
+				"start_line": {"integer", "Primera línea a leer (por defecto 1)"},
+				"max_lines":  {"integer", "Número máximo de líneas (por defecto 400)"},
+			}),
+		},
+		run: toolReadFile,
+	},
+	"list_dir": {
+		def: api.ToolFunction{
+			Name:        "list_dir",
+			Description: "Lista el contenido de un directorio del proyecto actual.",
+			Parameters: toolParams(nil, map[string][2]string{
+				"path":      {"string", "Directorio (por defecto el actual)"},
+				"recursive": {"boolean", "Incluir subdirectorios (hasta 3 niveles)"},
+			}),
+		},
+		run: toolListDir,
+	},
+	"grep": {
+		def: api.ToolFunction{
+			Name:        "grep",
+			Description: "Busca una expresión regular (sintaxis RE2) en los archivos del proyecto actual.",
+			Parameters: toolParams([]string{"pattern"}, map[string][2]string{
+				"pattern":     {"string", "Expresión regular a buscar"},
+				"path":        {"string", "Archivo o directorio donde buscar (por defecto el actual)"},
+				"glob":        {"string", "Filtra los archivos por nombre, ej. *.go"},
+				"ignore_case": {"boolean", "No distinguir mayúsculas"},
+			}),
+		},
+		run: toolGrep,
+	},
+	"run_readonly_command": {
+		def: api.ToolFunction{
+			Name:        "run_readonly_command",
+			Description: "Ejecuta un comando de shell de sólo lectura (ls, cat, grep, find, git status/diff/log, go vet/list, wc, ...) en el directorio actual y devuelve su salida. Sólo se admiten opciones conocidas de cada programa y rutas dentro del proyecto; los comandos que modifican archivos se rechazan.",
+			Parameters: toolParams([]string{"command"}, map[string][2]string{
+				"command": {"string", "Comando a ejecutar"},
+			}),
+		},
+		run: toolRunReadonly,
+	},
+	"git_diff": {
+		def: api.ToolFunction{
+			Name:        "git_diff",
+			Description: "Muestra los cambios sin confirmar del repositorio git actual.",
+			Parameters: toolParams(nil, map[string][2]string{
+				"staged": {"boolean", "Mostrar sólo los cambios preparados (git diff --cached)"},
+				"path":   {"string", "Limitar el diff a esta ruta"},
+				"stat":   {"boolean", "Mostrar sólo el resumen por archivo"},
+			}),
+		},
+		run: toolGitDiff,
+	},
+}
+
+// builtinChatToolOrder fija el orden en el que se ofrecen las herramientas al modelo.
+var builtinChatToolOrder = []string{"read_file", "list_dir", "grep", "git_diff", "run_readonly_command"}
+
+// chatTools devuelve las herramientas para /chat: las internas y las de MCP.
+func chatTools(modelName string) api.Tools {
+	if modelName == chatNoToolsModel {
+		return nil
+	}
+	var tools api.Tools
+	if chatToolsEnabled() {
+		for _, name := range builtinChatToolOrder {
+			tools = append(tools, api.Tool{Type: "function", Function: builtinChatTools[name].def})
+		}
+	}
+	return append(tools, mcpChatTools()...)
+}
+
+// runChatToolCall muestra una llamada del modelo, pide aprobación, la ejecuta y
+// devuelve el texto que se le devuelve al modelo.
+func runChatToolCall(ctx context.Context, state *liner.State, modelName string, request string, call api.ToolCall) string {
+	name := call.Function.Name
+	var title, auditCommand, mode string
+	var run func(ctx context.Context, args api.ToolCallFunctionArguments) (string, bool, error)
+
+	if tool, ok := builtinChatTools[name]; ok && chatToolsEnabled() {
+		title = "IA> Herramienta: " + name
+		auditCommand = fmt.Sprintf("herramienta:%s %s", name, call.Function.Arguments.String())
+		mode = auditModeTool
+		run = func(ctx context.Context, args api.ToolCallFunctionArguments) (string, bool, error) {
+			text, err := tool.run(ctx, args)
+			if err != nil {
+				return "Error: " + err.Error(), true, nil
+			}
+			return text, false, nil
+		}
+	} else if s, tool, ok := findMCPTool(name); ok {
+		title = fmt.Sprintf("IA> Llamada a herramienta MCP: %s/%s", s.Name, tool)
+		auditCommand = fmt.Sprintf("mcp:%s/%s %s", s.Name, tool, call.Function.Arguments.String())
+		mode = auditModeMCP
+		run = func(ctx context.Context, args api.ToolCallFunctionArguments) (string, bool, error) {
+			return s.callTool(ctx, tool, args)
+		}
+	} else {
+		fmt.Println(cError(fmt.Sprintf("IA> El modelo pidió una herramienta desconocida: %s", name)))
+		return fmt.Sprintf("Error: la herramienta %q no existe.", name)
+	}
+
+	argsJSON, _ := json.MarshalIndent(call.Function.Arguments, "    ", "  ")
+	fmt.Println(cSystem("---"))
+	fmt.Println(cIA(title))
+	fmt.Printf("\n    %s\n\n", argsJSON)
+	fmt.Println(cSystem("---"))
+
+	if !trustedChatTools[name] {
+		answer, err := state.Prompt("IA> ¿Permitir? [s/N/x (Siempre esta herramienta)]: ")
+		if err != nil {
+			fmt.Println(cSystem("\nCancelado."))
+			return "El usuario ha cancelado la llamada a la herramienta."
+		}
+		switch strings.TrimSpace(strings.ToLower(answer)) {
+		case "s":
+		case "x":
+			trustedChatTools[name] = true
+			fmt.Println(cSystem(fmt.Sprintf("IA> %s se permitirá sin preguntar durante esta sesión.", name)))
+		default:
+			fmt.Println(cSystem("IA> Llamada rechazada."))
+			return "El usuario ha rechazado la llamada a la herramienta. No vuelvas a intentarla sin preguntarle."
+		}
+	}
+
+	start := time.Now()
+	text, isError, err := run(ctx, call.Function.Arguments)
+	exitCode := 0
+	switch {
+	case err != nil:
+		exitCode = -1
+		text = fmt.Sprintf("Error al llamar a la herramienta: %v", err)
+		fmt.Println(cError("IA> " + text))
+	case isError:
+		exitCode = 1
+		fmt.Println(cError("IA> La herramienta devolvió un error:"))
+	default:
+		fmt.Println(cSystem(fmt.Sprintf("IA> Resultado (%d caracteres):", len(text))))
+	}
+	if err == nil {
+		printResultPreview(text)
+	}
+	recordAuditEntry(auditCommand, request, modelName, mode, exitCode, time.Since(start), []byte(text))
+
+	if len(text) > chatMaxToolResult {
+		text = text[:chatMaxToolResult] + "\n... (resultado truncado)"
+	}
+	return text
+}
+
+// printResultPreview muestra las primeras líneas del resultado de una herramienta.
+func printResultPreview(text string) {
+	lines := strings.Split(strings.TrimRight(text, "\n"), "\n")
+	for i, line := range lines {
+		if i >= chatToolPreviewLines {
+			fmt.Println(cSystem(fmt.Sprintf("    ... (%d líneas más)", len(lines)-i)))
+			break
+		}
+		fmt.Println(cSystem("    " + line))
+	}
+}
+
+// --- Argumentos ---
+
+func argString(args api.ToolCallFunctionArguments, key string) string {
+	switch v := args[key].(type) {
+	case string:
+		return v
+	case nil:
+		return ""
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func argInt(args api.ToolCallFunctionArguments, key string, def int) int {
+	switch v := args[key].(type) {
+	case float64:
+		return int(v)
+	case int:
+		return v
+	case string:
+		if n, err := strconv.Atoi(v); err == nil {
+			return n
+		}
+	}
+	return def
+}
+
+func argBool(args api.ToolCallFunctionArguments, key string) bool {
+	switch v := args[key].(type) {
+	case bool:
+		return v
+	case string:
+		return v == "true"
+	}
+	return false
+}
+
+// --- Herramientas de archivos ---
+
+// toolRoot devuelve la raíz a la que se limitan las herramientas de archivos: el
+// proyecto actual o, fuera de un proyecto, el directorio actual.
+func toolRoot() (string, error) {
+	root := currentProject()
+	if root == "" {
+		var err error
+		if root, err = os.Getwd(); err != nil {
+			return "", err
+		}
+	}
+	return filepath.EvalSymlinks(root)
+}
+
+// resolveToolPath resuelve una ruta del modelo (relativa al CWD) y comprueba que, tras
+// seguir los enlaces simbólicos, queda dentro de toolRoot.
+func resolveToolPath(path string) (string, error) {
+	if path == "" {
+		path = "."
+	}
+	root, err := toolRoot()
+	if err != nil {
+		return "", err
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	real, err := filepath.EvalSymlinks(abs)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", fmt.Errorf("%s: no existe", path)
+		}
+		return "", err
+	}
+	if real != root && !strings.HasPrefix(real, root+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s: está fuera del proyecto (%s)", path, root)
+	}
+	return real, nil
+}
+
+// isBinary indica si el contenido parece binario (contiene bytes nulos).
+func isBinary(data []byte) bool {
+	return bytes.IndexByte(data[:min(len(data), 8000)], 0) >= 0
+}
+
+func toolReadFile(ctx context.Context, args api.ToolCallFunctionArguments) (string, error) {
+	path, err := resolveToolPath(argString(args, "path"))
+	if err != nil {
+		return "", err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if info, err := f.Stat(); err != nil || info.IsDir() {
+		return "", fmt.Errorf("%s es un directorio (usa list_dir)", argString(args, "path"))
+	}
+
+	start := max(argInt(args, "start_line", 1), 1)
+	maxLines := argInt(args, "max_lines", chatReadDefaultLines)
+	if maxLines <= 0 || maxLines > chatReadMaxLines {
+		maxLines = chatReadMaxLines
+	}
+	reader := bufio.NewReader(f)
+	head, _ := reader.Peek(8000)
+	if isBinary(head) {
+		return "", fmt.Errorf("%s es un archivo binario", argString(args, "path"))
+	}
+
+	var out strings.Builder
+	n, shown := 0, 0
+	for {
+		line, err := reader.ReadString('\n')
+		if line == "" && err != nil {
+			break
+		}
+		n++
+		if n >= start && shown < maxLines && out.Len() < chatMaxToolResult {
+			fmt.Fprintf(&out, "%5d  %s\n", n, strings.TrimRight(line, "\r\n"))
+			shown++
+		}
+		if err != nil {
+			break
+		}
+	}
+	if shown == 0 {
+		return fmt.Sprintf("(%s tiene %d líneas; no hay nada a partir de la línea %d)", argString(args, "path"), n, start), nil
+	}
+	header := fmt.Sprintf("%s: líneas %d-%d de %d\n", argString(args, "path"), start, start+shown-1, n)
+	return header + out.String(), nil
+}
+
+// skipToolDir indica si un directorio no merece recorrerse.
+func skipToolDir(name string) bool {
+	return name == ".git" || name == "node_modules" || name == ".venv" || name == "__pycache__"
+}
+
+func toolListDir(ctx context.Context, args api.ToolCallFunctionArguments) (string, error) {
+	dir, err := resolveToolPath(argString(args, "path"))
+	if err != nil {
+		return "", err
+	}
+	maxDepth := 1
+	if argBool(args, "recursive") {
+		maxDepth = chatListMaxDepth
+	}
+	var out strings.Builder
+	count := 0
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || path == dir {
+			return nil
+		}
+		rel, _ := filepath.Rel(dir, path)
+		depth := strings.Count(rel, string(filepath.Separator)) + 1
+		if count >= chatListMaxEntries {
+			return filepath.SkipAll
+		}
+		count++
+		if d.IsDir() {
+			fmt.Fprintf(&out, "%s/\n", rel)
+			if depth >= maxDepth || skipToolDir(d.Name()) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			fmt.Fprintf(&out, "%s  (%s)\n", rel, formatBytes(info.Size()))
+		} else {
+			fmt.Fprintf(&out, "%s\n", rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	if count == 0 {
+		return "(directorio vacío)", nil
+	}
+	if count >= chatListMaxEntries {
+		fmt.Fprintf(&out, "... (listado recortado a %d entradas)\n", chatListMaxEntries)
+	}
+	return out.String(), nil
+}
+
+func toolGrep(ctx context.Context, args api.ToolCallFunctionArguments) (string, error) {
+	pattern := argString(args, "pattern")
+	if pattern == "" {
+		return "", fmt.Errorf("falta el patrón")
+	}
+	if argBool(args, "ignore_case") {
+		pattern = "(?i)" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return "", fmt.Errorf("expresión regular inválida: %v", err)
+	}
+	root, err := resolveToolPath(argString(args, "path"))
+	if err != nil {
+		return "", err
+	}
+	cwd, _ := os.Getwd()
+	glob := argString(args, "glob")
+
+	var out strings.Builder
+	matches := 0
+	filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || ctx.Err() != nil {
+			return nil
+		}
+		if d.IsDir() {
+			if path != root && skipToolDir(d.Name()) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if matches >= chatGrepMaxMatches {
+			return filepath.SkipAll
+		}
+		if glob != "" {
+			if ok, _ := filepath.Match(glob, d.Name()); !ok {
+				return nil
+			}
+		}
+		info, err := d.Info()
+		if err != nil || !info.Mode().IsRegular() || info.Size() > chatGrepMaxFileSize {
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil || isBinary(data) {
+			return nil
+		}
+		rel, err := filepath.Rel(cwd, path)
+		if err != nil {
+			rel = path
+		}
+		for i, line := range strings.Split(string(data), "\n") {
+			if !re.MatchString(line) {
+				continue
+			}
+			if len(line) > 200 {
+				line = line[:200] + "..."
+			}
+			fmt.Fprintf(&out, "%s:%d: %s\n", rel, i+1, strings.TrimRight(line, "\r"))
+			matches++
+			if matches >= chatGrepMaxMatches {
+				break
+			}
+		}
+		return nil
+	})
+	if matches == 0 {
+		return "(sin coincidencias)", nil
+	}
+	if matches >= chatGrepMaxMatches {
+		fmt.Fprintf(&out, "... (resultados recortados a %d coincidencias)\n", chatGrepMaxMatches)
+	}
+	return out.String(), nil
+}
+
+// --- Comandos de sólo lectura ---
+//
+// run_readonly_command sólo admite los programas de readonlyCommands y, de cada uno,
+// las opciones que aparecen en su lista: cualquier otra se rechaza, así que una opción
+// nueva o poco conocida (sort --compress-program, git grep -O...) no puede ejecutar
+// programas ni escribir archivos. Las rutas, incluidos los "<" y los comodines, tienen
+// que quedar dentro de toolRoot, como en read_file, list_dir y grep.
+
+// readonlyOperands indica qué admite un programa como operandos (lo que no son opciones).
+type readonlyOperands int
+
+const (
+	readonlyPaths   readonlyOperands = iota // Rutas dentro de toolRoot ("-" es la entrada estándar)
+	readonlyText                            // Texto libre, sin comodines
+	readonlyNone                            // Ninguno
+	readonlyPattern                         // Un patrón (salvo con -e/--regexp) y después rutas
+	readonlyFormat                          // Sólo formatos "+..." (date)
+	readonlyAny                             // No se interpretan opciones: todo es texto (echo, seq)
+)
+
+// readonlySpec describe las opciones permitidas de un programa al estilo GNU.
+type readonlySpec struct {
+	flags    string   // Opciones cortas sin valor, combinables ("-la")
+	valued   string   // Opciones cortas con valor ("-n 5" o "-n5")
+	long     []string // Opciones largas: "--x" sin valor, "--x=" con valor ("--x=v" o "--x v"), "--x=?" con valor opcional tras "="
+	numeric  bool     // Admite "-N" (head -5)
+	operands readonlyOperands
+	check    func(args []string) error // Validación propia en lugar de la genérica (git, go, find)
+}
+
+// readonlyCommands son los programas permitidos en run_readonly_command.
+var readonlyCommands = map[string]readonlySpec{
+	"ls":    {flags: "1aAlhrRtSdFiognpsuUvxXcCBGH", long: []string{"--all", "--almost-all", "--human-readable", "--color=?", "--sort=", "--time-style=", "--group-directories-first", "--classify", "--directory", "--recursive", "--reverse", "--full-time", "--inode", "--size", "--indicator-style="}},
+	"cat":   {flags: "AbeEnstTuv", long: []string{"--number", "--number-nonblank", "--show-all", "--show-ends", "--show-tabs", "--squeeze-blank"}},
+	"head":  {flags: "qvz", valued: "nc", numeric: true, long: []string{"--lines=", "--bytes=", "--quiet", "--silent", "--verbose"}},
+	"tail":  {flags: "qvz", valued: "nc", numeric: true, long: []string{"--lines=", "--bytes=", "--quiet", "--silent", "--verbose"}},
+	"wc":    {flags: "clmwL", long: []string{"--lines", "--words", "--bytes", "--chars", "--max-line-length"}},
+	"grep":  readonlyGrep,
+	"egrep": readonlyGrep,
+	"fgrep": readonlyGrep,
+	"rg": {flags: "iIvwxclnNHoqsSFUuP.", valued: "egtTABCmM", operands: readonlyPattern, long: []string{
+		"--regexp=", "--ignore-case", "--smart-case", "--case-sensitive", "--invert-match", "--word-regexp", "--line-regexp",
+		"--count", "--files", "--files-with-matches", "--files-without-match", "--line-number", "--no-line-number",
+		"--with-filename", "--no-filename", "--no-heading", "--heading", "--only-matching", "--quiet", "--fixed-strings",
+		"--hidden", "--no-ignore", "--unrestricted", "--glob=", "--iglob=", "--type=", "--type-not=", "--type-list",
+		"--context=", "--after-context=", "--before-context=", "--max-count=", "--max-columns=", "--max-depth=",
+		"--color=", "--json", "--vimgrep", "--multiline", "--pcre2", "--sort=", "--stats", "--trim"}},
+	"stat":      {flags: "Lt", valued: "c", long: []string{"--dereference", "--terse", "--format=", "--printf="}},
+	"file":      {flags: "bhiLkN", long: []string{"--brief", "--mime", "--mime-type", "--mime-encoding", "--dereference", "--no-dereference"}},
+	"du":        {flags: "ahsckbmx0", valued: "dt", long: []string{"--all", "--human-readable", "--summarize", "--total", "--max-depth=", "--apparent-size", "--bytes", "--one-file-system", "--exclude=", "--threshold="}},
+	"df":        {flags: "hHiklPTa", valued: "tx", long: []string{"--human-readable", "--si", "--inodes", "--local", "--print-type", "--portability", "--all", "--type=", "--exclude-type=", "--output=?", "--total"}},
+	"pwd":       {flags: "LP", operands: readonlyNone},
+	"echo":      {operands: readonlyAny},
+	"true":      {operands: readonlyAny},
+	"false":     {operands: readonlyAny},
+	"seq":       {operands: readonlyAny},
+	"which":     {flags: "a", operands: readonlyText},
+	"whereis":   {flags: "bmsu", operands: readonlyText},
+	"type":      {flags: "aftpP", operands: readonlyText},
+	"uname":     {flags: "asnrvmpio", long: []string{"--all", "--kernel-name", "--kernel-release", "--machine", "--operating-system"}, operands: readonlyNone},
+	"whoami":    {operands: readonlyNone},
+	"id":        {flags: "ugGnrz", long: []string{"--user", "--group", "--groups", "--name", "--real"}, operands: readonlyText},
+	"ps":        {flags: "aAdefFlHjLMTwxyZ", valued: "opuUCgGqst", long: []string{"--sort=", "--format=", "--pid=", "--ppid=", "--user=", "--forest", "--no-headers", "--headers", "--cols=", "--width="}, operands: readonlyText},
+	"uptime":    {flags: "ps", long: []string{"--pretty", "--since"}, operands: readonlyNone},
+	"free":      {flags: "bkmghltvw", long: []string{"--human", "--bytes", "--kilo", "--mega", "--giga", "--total", "--wide", "--si"}, operands: readonlyNone},
+	"nproc":     {long: []string{"--all", "--ignore="}, operands: readonlyNone},
+	"lsblk":     {flags: "abdfJlmnprSt", valued: "oe", long: []string{"--all", "--bytes", "--fs", "--json", "--list", "--perms", "--paths", "--raw", "--output=", "--nodeps", "--tree"}, operands: readonlyText},
+	"cut":       {flags: "sz", valued: "bcdf", long: []string{"--bytes=", "--characters=", "--delimiter=", "--fields=", "--only-delimited", "--output-delimiter=", "--complement"}},
+	"tr":        {flags: "cCdst", long: []string{"--complement", "--delete", "--squeeze-repeats", "--truncate-set1"}, operands: readonlyText},
+	"diff":      {flags: "qsuciwbBEaTtpyN", valued: "UCFIW", long: []string{"--brief", "--report-identical-files", "--unified=?", "--context=?", "--ignore-case", "--ignore-all-space", "--ignore-space-change", "--ignore-blank-lines", "--text", "--side-by-side", "--color=?", "--stat", "--minimal", "--new-file", "--label="}},
+	"cmp":       {flags: "blsz", valued: "in", long: []string{"--print-bytes", "--verbose", "--silent", "--quiet", "--bytes=", "--ignore-initial="}},
+	"md5sum":    {flags: "btz", long: []string{"--binary", "--text", "--tag", "--zero"}},
+	"sha1sum":   {flags: "btz", long: []string{"--binary", "--text", "--tag", "--zero"}},
+	"sha256sum": {flags: "btz", long: []string{"--binary", "--text", "--tag", "--zero"}},
+	"realpath":  {flags: "eLmPqsz", long: []string{"--canonicalize-existing", "--canonicalize-missing", "--logical", "--physical", "--quiet", "--strip", "--no-symlinks", "--relative-to=", "--relative-base=", "--zero"}},
+	"readlink":  {flags: "efmnqsvz", long: []string{"--canonicalize", "--canonicalize-existing", "--canonicalize-missing", "--no-newline", "--quiet", "--silent", "--verbose", "--zero"}},
+	"basename":  {flags: "az", valued: "s", long: []string{"--multiple", "--suffix=", "--zero"}, operands: readonlyText},
+	"dirname":   {flags: "z", long: []string{"--zero"}, operands: readonlyText},
+	"column":    {flags: "tnxeJ", valued: "scoNRHW", long: []string{"--table", "--separator=", "--output-separator=", "--json", "--table-columns="}},
+	"nl":        {flags: "p", valued: "bdfhilnsvw", long: []string{"--body-numbering=", "--number-format=", "--number-separator=", "--number-width=", "--starting-line-number=", "--line-increment="}},
+	"comm":      {flags: "123z", long: []string{"--check-order", "--nocheck-order", "--output-delimiter=", "--total", "--zero-terminated"}},
+	"sort": {flags: "bdfgiMhnRrVcCsuz", valued: "kt", long: []string{
+		"--ignore-leading-blanks", "--dictionary-order", "--ignore-case", "--general-numeric-sort", "--ignore-nonprinting",
+		"--month-sort", "--human-numeric-sort", "--numeric-sort", "--random-sort", "--reverse", "--version-sort",
+		"--check=?", "--stable", "--unique", "--zero-terminated", "--key=", "--field-separator=", "--sort="}},
+	// uniq no admite el segundo operando: "uniq entrada salida" escribe en salida
+	"uniq": {flags: "cdDiuz", valued: "fsw", long: []string{"--count", "--repeated", "--all-repeated=?", "--ignore-case", "--unique", "--skip-fields=", "--skip-chars=", "--check-chars=", "--zero-terminated"}},
+	"tree": {flags: "adfixACDFhpugsNrtvJQ", valued: "LIP", long: []string{"--dirsfirst", "--noreport", "--gitignore", "--prune", "--du", "--sort="}},
+	"date": {flags: "uRI", valued: "d", long: []string{"--date=", "--utc", "--universal", "--rfc-email", "--iso-8601=?", "--rfc-3339="}, operands: readonlyFormat},
+	"find": {check: checkReadonlyFind},
+	"git":  {check: checkReadonlyGit},
+	"go":   {check: checkReadonlyGo},
+}
+
+// readonlyGrep vale también para egrep y fgrep. Sin -R (sigue enlaces fuera del
+// proyecto) ni -f (lee patrones de otro archivo).
+var readonlyGrep = readonlySpec{flags: "iyvwxclLnhHoqsbrEFGPIzZaUT", valued: "eABCm", operands: readonlyPattern, long: []string{
+	"--regexp=", "--ignore-case", "--invert-match", "--word-regexp", "--line-regexp", "--count", "--files-with-matches",
+	"--files-without-match", "--line-number", "--no-filename", "--with-filename", "--only-matching", "--quiet", "--silent",
+	"--no-messages", "--recursive", "--extended-regexp", "--fixed-strings", "--basic-regexp", "--perl-regexp",
+	"--include=", "--exclude=", "--exclude-dir=", "--context=", "--after-context=", "--before-context=", "--max-count=",
+	"--color=?", "--colour=?", "--binary-files=", "--null", "--null-data", "--text"}}
+
+// readonlyWord es un argumento sin comillas. glob indica que lleva "*" o "?" sin
+// comillas: bash lo expandirá con los nombres de archivo que encajen.
+type readonlyWord struct {
+	text string
+	glob bool
+}
+
+// checkArgs valida los argumentos según la lista de opciones y el tipo de operandos.
+func (spec readonlySpec) checkArgs(name string, args []readonlyWord) error {
+	if spec.check != nil || spec.operands == readonlyAny {
+		plain := make([]string, len(args))
+		for i, arg := range args {
+			if arg.glob {
+				return fmt.Errorf("%s: no se permiten comodines en %s", arg.text, name)
+			}
+			plain[i] = arg.text
+		}
+		if spec.check != nil {
+			return spec.check(plain)
+		}
+		return nil
+	}
+
+	var operands []readonlyWord
+	patternGiven := false
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg.text == "--" && !arg.glob {
+			operands = append(operands, args[i+1:]...)
+			break
+		}
+		if !strings.HasPrefix(arg.text, "-") || arg.text == "-" {
+			operands = append(operands, arg)
+			continue
+		}
+		if arg.glob {
+			return fmt.Errorf("%s: los comodines de las opciones tienen que ir entre comillas", arg.text)
+		}
+		used, option, err := spec.parseOption(args, i)
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		patternGiven = patternGiven || option == "-e" || option == "--regexp"
+		i += used - 1
+	}
+	if name == "uniq" && len(operands) > 1 {
+		return fmt.Errorf("uniq con archivo de salida")
+	}
+
+	switch spec.operands {
+	case readonlyNone:
+		if len(operands) > 0 {
+			return fmt.Errorf("%s no admite argumentos", name)
+		}
+	case readonlyText:
+		for _, op := range operands {
+			if op.glob {
+				return fmt.Errorf("%s: no se permiten comodines en %s", op.text, name)
+			}
+		}
+	case readonlyFormat:
+		for _, op := range operands {
+			if op.glob || !strings.HasPrefix(op.text, "+") {
+				return fmt.Errorf("%s sólo admite formatos \"+...\"", name)
+			}
+		}
+	case readonlyPattern:
+		if !patternGiven && len(operands) > 0 {
+			if operands[0].glob {
+				return fmt.Errorf("%s: el patrón tiene que ir entre comillas", operands[0].text)
+			}
+			operands = operands[1:]
+		}
+		fallthrough
+	case readonlyPaths:
+		for _, op := range operands {
+			if err := checkReadonlyPath(op); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// parseOption valida la opción args[i] y devuelve cuántas palabras ocupa (con su
+// valor) y su nombre ("-e", "--regexp").
+func (spec readonlySpec) parseOption(args []readonlyWord, i int) (int, string, error) {
+	arg := args[i].text
+	nextValue := func(option string) (int, string, error) {
+		if i+1 >= len(args) {
+			return 0, "", fmt.Errorf("falta el valor de %s", option)
+		}
+		if args[i+1].glob {
+			return 0, "", fmt.Errorf("no se permiten comodines en el valor de %s", option)
+		}
+		return 2, option, nil
+	}
+
+	if strings.HasPrefix(arg, "--") {
+		name, _, hasValue := strings.Cut(arg, "=")
+		for _, allowed := range spec.long {
+			switch allowed {
+			case name:
+				if hasValue {
+					return 0, "", fmt.Errorf("%s no lleva valor", name)
+				}
+				return 1, name, nil
+			case name + "=":
+				if hasValue {
+					return 1, name, nil
+				}
+				return nextValue(name)
+			case name + "=?":
+				return 1, name, nil
+			}
+		}
+		return 0, "", fmt.Errorf("la opción %s no está permitida", name)
+	}
+
+	if spec.numeric && strings.Trim(arg[1:], "0123456789") == "" {
+		return 1, "-N", nil
+	}
+	for j := 1; j < len(arg); j++ {
+		c := arg[j]
+		switch {
+		case strings.IndexByte(spec.flags, c) >= 0:
+		case strings.IndexByte(spec.valued, c) >= 0:
+			if j+1 < len(arg) {
+				return 1, "-" + string(c), nil // Valor pegado: "-n5"
+			}
+			return nextValue("-" + string(c))
+		default:
+			return 0, "", fmt.Errorf("la opción -%c no está permitida", c)
+		}
+	}
+	return 1, arg, nil
+}
+
+// checkReadonlyPath comprueba que la ruta (o todas las que encajan con el comodín)
+// quede dentro de toolRoot.
+func checkReadonlyPath(w readonlyWord) error {
+	paths := []string{w.text}
+	if w.glob {
+		matches, err := filepath.Glob(w.text)
+		if err != nil || len(matches) == 0 {
+			return fmt.Errorf("%s: no hay archivos que encajen", w.text)
+		}
+		paths = matches
+	} else if w.text == "-" || w.text == os.DevNull {
+		return nil
+	}
+	for _, path := range paths {
+		if strings.HasPrefix(path, "-") {
+			// bash pasaría el nombre como si fuera una opción
+			return fmt.Errorf("%s: el nombre empieza por \"-\"", path)
+		}
+		if _, err := resolveToolPath(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkReadonlyFind permite find con las rutas dentro de toolRoot y sólo expresiones
+// que no ejecutan, borran ni escriben nada.
+func checkReadonlyFind(args []string) error {
+	noValue := []string{"-print", "-print0", "-prune", "-quit", "-empty", "-readable", "-writable", "-executable",
+		"-true", "-false", "-not", "-a", "-and", "-o", "-or", "!", "(", ")", ",", "-depth", "-xdev", "-mount",
+		"-nowarn", "-noleaf", "-ls", "-daystart"}
+	withValue := []string{"-name", "-iname", "-path", "-ipath", "-wholename", "-iwholename", "-regex", "-iregex",
+		"-regextype", "-type", "-xtype", "-size", "-mtime", "-mmin", "-atime", "-amin", "-ctime", "-cmin", "-perm",
+		"-user", "-group", "-uid", "-gid", "-links", "-inum", "-maxdepth", "-mindepth", "-printf", "-fstype", "-newermt"}
+	withPath := []string{"-newer", "-anewer", "-cnewer", "-samefile"}
+
+	i := 0
+	for i < len(args) && (args[i] == "-P" || args[i] == "-H") {
+		i++
+	}
+	for ; i < len(args) && !strings.HasPrefix(args[i], "-") && args[i] != "(" && args[i] != "!"; i++ {
+		if err := checkReadonlyPath(readonlyWord{text: args[i]}); err != nil {
+			return err
+		}
+	}
+	for ; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case containsString(noValue, arg):
+		case containsString(withValue, arg) || containsString(withPath, arg):
+			if i+1 >= len(args) {
+				return fmt.Errorf("find: falta el valor de %s", arg)
+			}
+			i++
+			if containsString(withPath, arg) {
+				if err := checkReadonlyPath(readonlyWord{text: args[i]}); err != nil {
+					return err
+				}
+			}
+		default:
+			return fmt.Errorf("find %s no está permitido", arg)
+		}
+	}
+	return nil
+}
+
+// readonlyGitDiffOptions son las opciones de formato comunes a diff, show y log.
+var readonlyGitDiffOptions = []string{
+	"--cached", "--staged", "--stat=?", "--shortstat", "--numstat", "--name-only", "--name-status", "--summary",
+	"--patch", "--no-patch", "--color=?", "--no-color", "--word-diff=?", "--unified=", "--ignore-all-space",
+	"--ignore-space-change", "--ignore-blank-lines", "--no-ext-diff", "--no-textconv", "--diff-filter=",
+	"--find-renames=?", "--no-renames", "--check", "--minimal", "--histogram", "--patience", "--relative=?",
+	"--exit-code", "--quiet", "--merge-base", "--function-context", "--abbrev=?", "--full-index", "--raw",
+}
+
+// readonlyGitLogOptions son las opciones de selección y formato de show y log.
+var readonlyGitLogOptions = []string{
+	"--oneline", "--graph", "--decorate=?", "--no-decorate", "--all", "--branches=?", "--tags=?", "--remotes=?",
+	"--format=", "--pretty=?", "--author=", "--committer=", "--since=", "--until=", "--after=", "--before=",
+	"--grep=", "--max-count=", "--skip=", "--reverse", "--no-merges", "--merges", "--first-parent", "--follow",
+	"--date=", "--abbrev-commit", "--regexp-ignore-case", "--all-match", "--invert-grep", "--left-right",
+	"--cherry-pick", "--topo-order", "--date-order", "--simplify-by-decoration", "--show-signature",
+}
+
+// readonlyGit son las opciones permitidas de cada subcomando de git. Sin --output,
+// --ext-diff, --no-index ni -O/--open-files-in-pager, que escriben archivos, ejecutan
+// programas o leen fuera del repositorio.
+var readonlyGit = map[string]readonlySpec{
+	"status": {flags: "sbvz", operands: readonlyText, long: []string{"--short", "--branch", "--porcelain=?", "--long",
+		"--verbose", "--untracked-files=?", "--ignored=?", "--show-stash", "--ahead-behind", "--no-ahead-behind", "--renames", "--no-renames"}},
+	"diff":  {flags: "pRawbz", valued: "U", operands: readonlyText, long: readonlyGitDiffOptions},
+	"show":  {flags: "pRswbz", valued: "U", operands: readonlyText, long: append(append([]string{}, readonlyGitDiffOptions...), readonlyGitLogOptions...)},
+	"log":   {flags: "pRsiwbz", valued: "nUSG", numeric: true, operands: readonlyText, long: append(append([]string{}, readonlyGitDiffOptions...), readonlyGitLogOptions...)},
+	"blame": {flags: "lstfnwecpM", valued: "L", operands: readonlyText, long: []string{"--porcelain", "--line-porcelain", "--date=", "--show-email", "--show-name", "--show-number", "--root", "--since=", "--incremental"}},
+	"ls-files": {flags: "cdmoiskutz", valued: "x", operands: readonlyText, long: []string{"--cached", "--deleted", "--modified", "--others", "--ignored", "--stage",
+		"--killed", "--unmerged", "--exclude=", "--exclude-standard", "--directory", "--no-empty-directory", "--full-name", "--error-unmatch", "--eol", "--recurse-submodules"}},
+	"rev-parse": {flags: "q", operands: readonlyText, long: []string{"--show-toplevel", "--abbrev-ref=?", "--short=?", "--git-dir", "--git-common-dir",
+		"--absolute-git-dir", "--is-inside-work-tree", "--is-inside-git-dir", "--is-bare-repository", "--verify", "--show-prefix",
+		"--show-cdup", "--symbolic", "--symbolic-full-name", "--quiet", "--all", "--branches=?", "--tags=?"}},
+	"describe": {operands: readonlyText, long: []string{"--tags", "--always", "--dirty=?", "--abbrev=", "--long", "--exact-match", "--all", "--contains", "--first-parent", "--match=", "--exclude="}},
+	"grep": {flags: "iwvlLcEFGPIhHoqnz", valued: "eABCm", operands: readonlyText, long: []string{
+		"--cached", "--untracked", "--no-exclude-standard", "--exclude-standard", "--recurse-submodules", "--ignore-case",
+		"--word-regexp", "--invert-match", "--files-with-matches", "--name-only", "--files-without-match", "--count",
+		"--extended-regexp", "--fixed-strings", "--basic-regexp", "--perl-regexp", "--line-number", "--column",
+		"--context=", "--after-context=", "--before-context=", "--max-count=", "--max-depth=", "--heading", "--break",
+		"--all-match", "--and", "--or", "--not", "--full-name", "--only-matching", "--quiet", "--color=?", "--no-color", "--null"}},
+	"shortlog": {flags: "nse", operands: readonlyText, long: []string{"--summary", "--numbered", "--email", "--all", "--since=", "--until=", "--author=", "--group=", "--no-merges"}},
+	// Con operandos, branch y tag crean ramas o etiquetas
+	"branch": {flags: "arv", operands: readonlyNone, long: []string{"--all", "--remotes", "--list", "--show-current", "--verbose", "--no-color", "--merged=?", "--no-merged=?", "--contains=?", "--sort="}},
+	"tag":    {flags: "l", valued: "n", operands: readonlyNone, long: []string{"--list", "--sort=", "--merged=?", "--contains=?", "--points-at="}},
+	"remote": {flags: "v", operands: readonlyNone, long: []string{"--verbose"}},
+}
+
+// checkReadonlyGit permite los subcomandos de git que sólo consultan, con sus opciones.
+func checkReadonlyGit(args []string) error {
+	for len(args) > 0 && args[0] == "--no-pager" {
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("falta el subcomando de git")
+	}
+	spec, ok := readonlyGit[args[0]]
+	if !ok {
+		return fmt.Errorf("git %s no está permitido", args[0])
+	}
+	words := make([]readonlyWord, len(args)-1)
+	for i, arg := range args[1:] {
+		words[i] = readonlyWord{text: arg}
+	}
+	return spec.checkArgs("git "+args[0], words)
+}
+
+// readonlyGo son las opciones permitidas de cada subcomando de go (el valor indica si
+// llevan argumento). Sin -toolexec, -vettool, -exec, -ldflags, -gcflags, -overlay,
+// -modfile ni -C, que ejecutan programas o cambian qué se compila.
+var readonlyGo = map[string]map[string]bool{
+	"vet":     {"json": false, "tags": true, "v": false, "n": false, "x": false, "c": true, "race": false, "trimpath": false},
+	"list":    {"json": false, "m": false, "u": false, "e": false, "f": true, "deps": false, "test": false, "find": false, "compiled": false, "export": false, "tags": true, "versions": false, "retracted": false},
+	"doc":     {"all": false, "c": false, "cmd": false, "short": false, "src": false, "u": false},
+	"version": {"m": false, "v": false, "json": false},
+	"env":     {"json": false, "changed": false},
+	"build":   {"o": true, "v": false, "n": false, "x": false, "a": false, "race": false, "tags": true, "trimpath": false, "cover": false, "buildvcs": true},
+}
+
+// checkReadonlyGo permite los subcomandos de go que no modifican el proyecto.
+func checkReadonlyGo(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("falta el subcomando de go")
+	}
+	sub := args[0]
+	allowed, ok := readonlyGo[sub]
+	if !ok {
+		return fmt.Errorf("go %s no está permitido", sub)
+	}
+	output := ""
+	var operands []string
+	for i := 1; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			operands = append(operands, args[i+1:]...)
+			break
+		}
+		if !strings.HasPrefix(arg, "-") {
+			operands = append(operands, arg)
+			continue
+		}
+		// Las opciones de go admiten "-x", "--x", "-x=v" y "-x v"
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		takesValue, ok := allowed[name]
+		if !ok {
+			return fmt.Errorf("go %s -%s no está permitido", sub, name)
+		}
+		if takesValue && !hasValue {
+			if i+1 >= len(args) {
+				return fmt.Errorf("falta el valor de -%s", name)
+			}
+			i++
+			value = args[i]
+		}
+		if name == "o" {
+			output = value
+		}
+	}
+	if sub == "build" && output != os.DevNull {
+		// Sólo para comprobar que compila, sin dejar binarios
+		return fmt.Errorf("go build sólo se permite con -o %s", os.DevNull)
+	}
+	for _, op := range operands {
+		// Los paquetes relativos o absolutos son directorios, y los operandos de go
+		// version, binarios: dentro del proyecto
+		if sub == "version" || strings.HasPrefix(op, ".") || strings.HasPrefix(op, "/") {
+			if err := checkReadonlyPath(readonlyWord{text: strings.TrimSuffix(op, "/...")}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkReadonlyCommand comprueba que todos los comandos de la línea estén permitidos y
+// que no haya redirecciones a archivos, sustituciones ni procesos en segundo plano.
+func checkReadonlyCommand(command string) error {
+	file, err := syntax.NewParser(syntax.Variant(syntax.LangBash)).Parse(strings.NewReader(command), "")
+	if err != nil {
+		return fmt.Errorf("error de sintaxis: %v", err)
+	}
+	var problem error
+	syntax.Walk(file, func(node syntax.Node) bool {
+		if problem != nil {
+			return false
+		}
+		switch n := node.(type) {
+		case *syntax.Stmt:
+			if n.Background || n.Coprocess {
+				problem = fmt.Errorf("no se permiten procesos en segundo plano")
+			}
+		case *syntax.Redirect:
+			problem = checkReadonlyRedirect(n)
+		case *syntax.CmdSubst, *syntax.ProcSubst:
+			problem = fmt.Errorf("no se permiten sustituciones de comandos")
+		case *syntax.FuncDecl:
+			problem = fmt.Errorf("no se permiten funciones")
+		case *syntax.DeclClause, *syntax.LetClause, *syntax.ArithmCmd, *syntax.CoprocClause, *syntax.TestClause:
+			// [[ ]] evalúa los subíndices de array ("a[$(cmd)]") de forma aritmética
+			problem = fmt.Errorf("no se permiten declaraciones, asignaciones ni [[ ]]")
+		case *syntax.CallExpr:
+			if len(n.Assigns) > 0 {
+				problem = fmt.Errorf("no se permiten asignaciones de variables")
+				return false
+			}
+			if len(n.Args) == 0 {
+				return true
+			}
+			words := make([]readonlyWord, len(n.Args))
+			for i, w := range n.Args {
+				text, glob, ok := staticWord(w)
+				if !ok || (i == 0 && glob) {
+					// Sin conocer el valor no se pueden validar el programa ni sus argumentos
+					problem = fmt.Errorf("no se permiten variables ni expansiones en los argumentos")
+					return false
+				}
+				words[i] = readonlyWord{text: text, glob: glob}
+			}
+			problem = checkReadonlyCall(words)
+		}
+		return true
+	})
+	return problem
+}
+
+// checkReadonlyRedirect permite leer de archivos del proyecto, duplicar descriptores
+// ("2>&1") y redirigir la salida sólo a /dev/null.
+func checkReadonlyRedirect(r *syntax.Redirect) error {
+	switch r.Op {
+	case syntax.Hdoc, syntax.DashHdoc, syntax.WordHdoc:
+		return nil
+	}
+	target, glob, ok := "", false, false
+	if r.Word != nil {
+		target, glob, ok = staticWord(r.Word)
+	}
+	if !ok || glob {
+		return fmt.Errorf("no se permiten variables ni comodines en las redirecciones")
+	}
+	switch r.Op {
+	case syntax.DplIn, syntax.DplOut:
+		// ">& archivo" escribe en el archivo: sólo descriptores ("2>&1", ">&-", "3>&1-")
+		if strings.Trim(strings.TrimSuffix(target, "-"), "0123456789") != "" {
+			return fmt.Errorf("sólo se pueden duplicar descriptores numéricos")
+		}
+		return nil
+	case syntax.RdrIn:
+		return checkReadonlyPath(readonlyWord{text: target})
+	}
+	if target != os.DevNull {
+		return fmt.Errorf("no se permite redirigir la salida a archivos")
+	}
+	return nil
+}
+
+// staticWord devuelve el valor de una palabra sin comillas si no depende de
+// expansiones (variables, llaves, "~"...). Una palabra sin comillas con "*" o "?" se
+// devuelve con glob a true.
+func staticWord(w *syntax.Word) (string, bool, bool) {
+	if len(w.Parts) == 1 {
+		if lit, ok := w.Parts[0].(*syntax.Lit); ok && !strings.ContainsAny(lit.Value, "[{~\\") {
+			return lit.Value, strings.ContainsAny(lit.Value, "*?"), true
+		}
+	}
+	var b strings.Builder
+	var add func(parts []syntax.WordPart) bool
+	add = func(parts []syntax.WordPart) bool {
+		for _, part := range parts {
+			switch p := part.(type) {
+			case *syntax.Lit:
+				if strings.ContainsAny(p.Value, "*?[{~\\") {
+					return false
+				}
+				b.WriteString(p.Value)
+			case *syntax.SglQuoted:
+				if p.Dollar {
+					return false
+				}
+				b.WriteString(p.Value)
+			case *syntax.DblQuoted:
+				if !add(p.Parts) {
+					return false
+				}
+			default:
+				return false
+			}
+		}
+		return true
+	}
+	if !add(w.Parts) {
+		return "", false, false
+	}
+	return b.String(), false, true
+}
+
+// checkReadonlyCall valida un comando simple contra la lista de permitidos y las
+// entradas de chat_readonly_commands.
+func checkReadonlyCall(words []readonlyWord) error {
+	texts := make([]string, len(words))
+	for i, w := range words {
+		texts[i] = w.text
+	}
+	for _, text := range texts {
+		// printf -v y test -v evalúan "a[$(cmd)]" como subíndice aritmético y ejecutan cmd
+		if strings.Contains(text, "[") && (strings.Contains(text, "$(") || strings.Contains(text, "`")) {
+			return fmt.Errorf("no se permiten subíndices con sustituciones de comandos")
+		}
+	}
+	line := strings.Join(texts, " ")
+	for _, allowed := range appConfig.ChatReadonlyCommands {
+		if line == allowed || strings.HasPrefix(line, allowed+" ") {
+			return nil
+		}
+	}
+	name := words[0].text
+	spec, ok := readonlyCommands[name]
+	if !ok {
+		return fmt.Errorf("%s no está en la lista de comandos de sólo lectura", name)
+	}
+	return spec.checkArgs(name, words[1:])
+}
+
+func toolRunReadonly(ctx context.Context, args api.ToolCallFunctionArguments) (string, error) {
+	command := strings.TrimSpace(argString(args, "command"))
+	if command == "" {
+		return "", fmt.Errorf("falta el comando")
+	}
+	if err := checkReadonlyCommand(command); err != nil {
+		return "", fmt.Errorf("comando rechazado: %v", err)
+	}
+	if decision := evaluatePolicy(command); decision.Action == policyDeny {
+		return "", fmt.Errorf("comando bloqueado por la política (%s)", decision.describe())
+	}
+	return runCapturedCommand(ctx, "bash", "-c", command)
+}
+
+func toolGitDiff(ctx context.Context, args api.ToolCallFunctionArguments) (string, error) {
+	gitArgs := []string{"--no-pager", "diff", "--no-ext-diff"}
+	if argBool(args, "staged") {
+		gitArgs = append(gitArgs, "--cached")
+	}
+	if argBool(args, "stat") {
+		gitArgs = append(gitArgs, "--stat")
+	}
+	if path := argString(args, "path"); path != "" {
+		gitArgs = append(gitArgs, "--", path)
+	}
+	out, err := runCapturedCommand(ctx, "git", gitArgs...)
+	if err != nil {
+		return "", err
+	}
+	if strings.HasSuffix(out, "código de salida 0\n") && strings.Count(out, "\n") == 1 {
+		return "(sin cambios)", nil
+	}
+	return out, nil
+}
+
+// runCapturedCommand ejecuta un programa con el tiempo límite de las herramientas y
+// devuelve su salida combinada (recortada) seguida de su código de salida.
+func runCapturedCommand(ctx context.Context, name string, args ...string) (string, error) {
+	text, truncated, exitCode, err := captureCommand(ctx, chatCommandTimeout, chatCommandMaxOutput, name, args...)
+	if err != nil {
+		return "", err
+	}
+	if truncated {
+		text += "\n... (salida recortada)"
+	}
+	return fmt.Sprintf("%scódigo de salida %d\n", text, exitCode), nil
+}
+
+// captureCommand ejecuta un programa sin terminal, en su propio grupo de procesos y con
+// tiempo límite (0 = sin límite), y devuelve su salida combinada y su código de salida.
+func captureCommand(ctx context.Context, timeout time.Duration, maxOutput int, name string, args ...string) (string, bool, int, error) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error { return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL) }
+	output := &limitedBuffer{max: maxOutput}
+	cmd.Stdout = output
+	cmd.Stderr = output
+	runErr := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return "", false, -1, fmt.Errorf("tiempo agotado (%s)", timeout)
+	} else if ctx.Err() != nil {
+		return "", false, -1, fmt.Errorf("cancelado")
+	}
+	exitCode := 0
+	if runErr != nil {
+		exitErr, ok := runErr.(*exec.ExitError)
+		if !ok {
+			return "", false, -1, runErr
+		}
+		exitCode = exitErr.ExitCode()
+	}
+	return output.buf.String(), output.truncated, exitCode, nil
+}
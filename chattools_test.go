@@ -0,0 +1,167 @@
+// Copyright (c) 2025 Daniel Serrano Armenta. dani.eus79@gmail.com Todos los derechos reservados.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ollama/ollama/api"
+)
+
+// withToolProject crea un proyecto temporal con algunos archivos y entra en él.
+func withToolProject(t *testing.T, files ...string) string {
+	t.Helper()
+	dir := filepath.Join(t.TempDir(), "proyecto")
+	if err := os.MkdirAll(filepath.Join(dir, ".git"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("x\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Chdir(dir)
+	return dir
+}
+
+func TestCheckReadonlyCommand(t *testing.T) {
+	dir := withToolProject(t, "main.go", "notas.txt")
+	if err := os.Symlink("/etc", filepath.Join(dir, "fuera")); err != nil {
+		t.Fatal(err)
+	}
+	saved := appConfig.ChatReadonlyCommands
+	t.Cleanup(func() { appConfig.ChatReadonlyCommands = saved })
+	appConfig.ChatReadonlyCommands = nil
+
+	tests := []struct {
+		command string
+		ok      bool
+	}{
+		{"ls -la", true},
+		{"ls -la --color=auto main.go", true},
+		{"cat main.go | grep -n 'func' | head -5", true},
+		{"grep -rn --include='*.go' TODO .", true},
+		{"grep -e x -e y notas.txt", true},
+		{"wc -l *.go", true},
+		{"sort -u -k2 notas.txt", true},
+		{"ls 2>&1 >/dev/null", true},
+		{"cat < notas.txt", true},
+		{"git status -s", true},
+		{"git log --oneline -5", true},
+		{"git grep -n TODO -- '*.go'", true},
+		{"go vet ./...", true},
+		{"go build -o /dev/null ./...", true},
+		{"find . -name '*.go' -type f", true},
+		{"date +%Y", true},
+		{"echo $HOME", false},
+
+		// Opciones que ejecutan programas o escriben archivos
+		{"sort --compress-program=sh notas.txt", false},
+		{"sort --compress=sh notas.txt", false}, // Abreviatura de getopt
+		{"sort -oOUT notas.txt", false},
+		{"sort -o OUT notas.txt", false},
+		{"go build -toolexec=sh -o /dev/null .", false},
+		{"go build -o /dev/null -ldflags=-extld=sh .", false},
+		{"go vet -vettool=/bin/sh .", false},
+		{"go build .", false},
+		{"go env -w GOFLAGS=-x", false},
+		{"git grep -Ovim TODO", false},
+		{"git grep --open-files-in-pager=vim TODO", false},
+		{"git diff --output=x", false},
+		{"git diff --no-index /etc/passwd notas.txt", false},
+		{"git -c core.pager=sh log", false},
+		{"git branch nueva", false},
+		{"find . -exec rm {} ;", false},
+		{"find . -delete", false},
+		{"find . -fprint salida", false},
+		{"rg --pre=sh x", false},
+		{"rg -z x", false},
+		{"tree -o salida", false},
+		{"date -s 2020-01-01", false},
+		{"uniq notas.txt salida", false},
+		{"grep -f /etc/passwd notas.txt", false},
+
+		// Redirecciones
+		{"ls >& out.txt", false},
+		{"ls &> out.txt", false},
+		{"ls > out.txt", false},
+		{"ls 2>&1", true},
+		{"ls >&2", true},
+		{"cat < /etc/passwd", false},
+
+		// Rutas fuera del proyecto
+		{"cat ~/.ssh/id_rsa", false},
+		{"cat /etc/passwd", false},
+		{"cat ../secreto", false},
+		{"cat fuera/passwd", false},
+		{"head -5 -- /etc/passwd", false},
+		{"ls /", false},
+		{"cat /e*/passwd", false},
+		{"find / -name x", false},
+		{"go list ../...", false},
+
+		// Construcciones de shell
+		{"ls $(pwd)", false},
+		{"ls &", false},
+		{"export PATH=/tmp; ls", false},
+		{"PATH=/tmp ls", false},
+		{"f() { ls; }", false},
+		{"rm -rf x", false},
+
+		// Subíndices de array que bash evalúa aritméticamente (ejecutan la sustitución)
+		{"printf -v 'a[$(touch /tmp/x)]' x", false},
+		{"test -v 'a[$(touch /tmp/x)]'", false},
+		{"[[ 'a[$(touch /tmp/x)]' -eq 0 ]]", false},
+		{"[ -f notas.txt ]", false},
+		{"echo 'a[`id`]'", false},
+	}
+	for _, tt := range tests {
+		if err := checkReadonlyCommand(tt.command); (err == nil) != tt.ok {
+			t.Errorf("checkReadonlyCommand(%q) = %v, se esperaba permitido: %v", tt.command, err, tt.ok)
+		}
+	}
+}
+
+func TestCheckReadonlyGlobOption(t *testing.T) {
+	// "sort *" con un archivo llamado como una opción ejecutaría sh
+	withToolProject(t, "--compress-program=sh", "a.txt")
+	if err := checkReadonlyCommand("sort *"); err == nil {
+		t.Error("sort * con un archivo \"--compress-program=sh\" debería rechazarse")
+	}
+	if err := checkReadonlyCommand("cat *.txt"); err != nil {
+		t.Errorf("cat *.txt: %v", err)
+	}
+	if err := checkReadonlyCommand("cat *.md"); err == nil {
+		t.Error("un comodín sin coincidencias debería rechazarse")
+	}
+}
+
+func TestCheckReadonlyConfigPrefix(t *testing.T) {
+	withToolProject(t)
+	saved := appConfig.ChatReadonlyCommands
+	t.Cleanup(func() { appConfig.ChatReadonlyCommands = saved })
+	appConfig.ChatReadonlyCommands = []string{"make -n"}
+
+	if err := checkReadonlyCommand("make -n build"); err != nil {
+		t.Errorf("make -n build: %v", err)
+	}
+	if err := checkReadonlyCommand("make build"); err == nil {
+		t.Error("make build no está en chat_readonly_commands")
+	}
+
+	appConfig.ChatReadonlyCommands = []string{"printf"}
+	if err := checkReadonlyCommand("printf -v 'a[$(touch /tmp/x)]' x"); err == nil {
+		t.Error("un subíndice con $( ) debería rechazarse aunque printf esté configurado")
+	}
+}
+
+func TestToolRunReadonlyPolicy(t *testing.T) {
+	withToolProject(t, "notas.txt")
+	withPolicyRules(t, policyRule{Binary: "cat", Action: policyDeny, Reason: "cat prohibido"})
+	args := api.ToolCallFunctionArguments{"command": "cat notas.txt"}
+	if _, err := toolRunReadonly(t.Context(), args); err == nil {
+		t.Fatal("una regla deny debería bloquear run_readonly_command")
+	}
+}
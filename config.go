@@ -0,0 +1,102 @@
+// Copyright (c) 2025 Daniel Serrano Armenta. dani.eus79@gmail.com Todos los derechos reservados.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// --- Configuración de Usuario ---
+//
+// Archivo opcional en ~/.config/terminal-ia/config.json. Todas las claves son
+// opcionales; si el archivo no existe se usan los valores por defecto.
+
+const (
+	configDirName         = "terminal-ia"
+	configFileName        = "config.json"
+	defaultEmbeddingModel = "nomic-embed-text"
+)
+
+// Config es la configuración persistente del usuario.
+type Config struct {
+	// EmbeddingModel es el modelo de Ollama usado para el historial semántico.
+	EmbeddingModel string `json:"embedding_model,omitempty"`
+	// DocGrounding activa la consulta de man/--help al generar comandos (por defecto sí).
+	DocGrounding *bool `json:"doc_grounding,omitempty"`
+	// UndoMaxMB limita el tamaño de cada instantánea de /deshacer.
+	UndoMaxMB int `json:"undo_max_mb,omitempty"`
+	// UndoKeep es el número de cambios que se conservan para /deshacer.
+	UndoKeep int `json:"undo_keep,omitempty"`
+	// CommandTimeout es el tiempo límite (segundos) de cada comando; 0 = sin límite.
+	CommandTimeout int `json:"command_timeout,omitempty"`
+	// CommandTimeouts asigna tiempos límite por patrón (glob) de comando.
+	CommandTimeouts map[string]int `json:"command_timeouts,omitempty"`
+	// LimitCPUSeconds, LimitMemoryMB y LimitOutputMB son límites opcionales por comando.
+	LimitCPUSeconds int `json:"limit_cpu_seconds,omitempty"`
+	LimitMemoryMB   int `json:"limit_memory_mb,omitempty"`
+	LimitOutputMB   int `json:"limit_output_mb,omitempty"`
+	// AnalyzeBackgroundErrors manda la salida de error de los trabajos en segundo plano
+	// fallidos al análisis de errores al volver al prompt.
+	AnalyzeBackgroundErrors bool `json:"analyze_background_errors,omitempty"`
+	// Aliases sustituye la primera palabra de los comandos de shell.
+	Aliases map[string]string `json:"aliases,omitempty"`
+	// Macros define comandos /nombre propios (ver macros.go).
+	Macros map[string]macroDef `json:"macros,omitempty"`
+	// MCPServers son los servidores MCP cuyas herramientas se ofrecen en /chat.
+	MCPServers map[string]mcpServerConfig `json:"mcp_servers,omitempty"`
+	// AnalyzeErrors decide si se analizan los comandos fallidos: "auto" (por defecto),
+	// "ask" (preguntando antes) u "off".
+	AnalyzeErrors string `json:"analyze_errors,omitempty"`
+	// AnalyzeIgnore son códigos de salida que no se analizan, por programa o glob de
+	// comando (lista vacía = cualquier código), ej. {"make": [2], "curl *": []}.
+	AnalyzeIgnore map[string][]int `json:"analyze_ignore,omitempty"`
+	// ChatTools activa las herramientas internas de /chat (por defecto true).
+	ChatTools *bool `json:"chat_tools,omitempty"`
+	// ChatReadonlyCommands amplía la lista de run_readonly_command con prefijos de
+	// comando, ej. "go test" o "make -n".
+	ChatReadonlyCommands []string `json:"chat_readonly_commands,omitempty"`
+}
+
+var (
+	appConfig  Config
+	configPath string
+)
+
+// loadConfig lee el archivo de configuración y aplica los valores por defecto.
+func loadConfig() {
+	if dir, err := os.UserConfigDir(); err == nil {
+		configPath = filepath.Join(dir, configDirName, configFileName)
+		data, err := os.ReadFile(configPath)
+		if err == nil {
+			if err := json.Unmarshal(data, &appConfig); err != nil {
+				fmt.Fprintln(os.Stderr, cError(fmt.Sprintf("Error al parsear %s (se usará la configuración por defecto): %v", configPath, err)))
+				appConfig = Config{}
+			}
+		} else if !os.IsNotExist(err) {
+			fmt.Fprintln(os.Stderr, cError(fmt.Sprintf("Error al leer %s: %v", configPath, err)))
+		}
+	}
+
+	if appConfig.EmbeddingModel == "" {
+		appConfig.EmbeddingModel = defaultEmbeddingModel
+	}
+	if appConfig.UndoMaxMB <= 0 {
+		appConfig.UndoMaxMB = defaultUndoMaxMB
+	}
+	if appConfig.UndoKeep <= 0 {
+		appConfig.UndoKeep = defaultUndoKeep
+	}
+	switch appConfig.AnalyzeErrors {
+	case "":
+		appConfig.AnalyzeErrors = analyzeErrorsAuto
+	case analyzeErrorsAuto, analyzeErrorsAsk, analyzeErrorsOff:
+	default:
+		fmt.Fprintln(os.Stderr, cError(fmt.Sprintf("analyze_errors inválido (%q): se usará \"auto\"", appConfig.AnalyzeErrors)))
+		appConfig.AnalyzeErrors = analyzeErrorsAuto
+	}
+	embeddingModelName = appConfig.EmbeddingModel
+	loadMacros()
+}
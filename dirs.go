@@ -0,0 +1,506 @@
+// Copyright (c) 2025 Daniel Serrano Armenta. dani.eus79@gmail.com Todos los derechos reservados.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"mvdan.cc/sh/v3/expand"
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// --- Directorios: cd, pushd/popd/dirs y saltos con z ---
+//
+// El directorio de trabajo es de terminal-ia (un "cd" dentro de bash -c no duraría más
+// que el propio comando), así que estos builtins se ejecutan aquí: cd con "cd -", "~" y
+// CDPATH, la pila de pushd/popd/dirs y "z <fragmento>", que salta al directorio más
+// "frecente" (frecuente y reciente) de los visitados. Los directorios visitados se
+// guardan en ~/.local/share/terminal-ia/directorios.json. Sólo se interceptan las
+// líneas con un único comando simple; "cd x && make" se deja a bash.
+
+const (
+	dirHistoryFileName = "directorios.json"
+	dirHistoryMaxRank  = 10000 // Al superarlo se envejecen todas las entradas
+	dirHistoryAging    = 0.9
+	dirHistoryMinRank  = 1.0 // Entradas por debajo tras envejecer se descartan
+	dirListMax         = 10
+)
+
+// dirVisit es la entrada de un directorio en el historial de directorios.
+type dirVisit struct {
+	Path string  `json:"path"`
+	Rank float64 `json:"rank"`
+	Last int64   `json:"last"` // Unix, segundos
+}
+
+var (
+	previousDir string   // OLDPWD, para "cd -"
+	dirStack    []string // Pila de pushd (sin incluir el directorio actual)
+)
+
+// dirBuiltinArgs indica si la línea es uno de los builtins de directorios y devuelve su
+// nombre y sus argumentos ya expandidos (~, variables, comillas y globs).
+func dirBuiltinArgs(input string) (string, []string, bool) {
+	file, err := syntax.NewParser(syntax.Variant(syntax.LangBash)).Parse(strings.NewReader(input), "")
+	if err != nil || len(file.Stmts) != 1 {
+		return "", nil, false
+	}
+	stmt := file.Stmts[0]
+	call, ok := stmt.Cmd.(*syntax.CallExpr)
+	if !ok || stmt.Background || stmt.Negated || len(stmt.Redirs) > 0 || len(call.Assigns) > 0 || len(call.Args) == 0 {
+		return "", nil, false
+	}
+	name := call.Args[0].Lit()
+	switch name {
+	case "cd", "pushd", "popd", "dirs", "z":
+	default:
+		return "", nil, false
+	}
+	cfg := &expand.Config{Env: expand.ListEnviron(os.Environ()...), ReadDir2: os.ReadDir}
+	args, err := expand.Fields(cfg, call.Args[1:]...)
+	if err != nil {
+		// Sustituciones de comandos y similares: mejor que lo intente bash
+		return "", nil, false
+	}
+	return name, args, true
+}
+
+// isDirBuiltin indica si la línea la gestiona handleDirBuiltin.
+func isDirBuiltin(input string) bool {
+	_, _, ok := dirBuiltinArgs(input)
+	return ok
+}
+
+// handleDirBuiltin implementa cd, pushd, popd, dirs y z.
+func handleDirBuiltin(input string) {
+	name, args, _ := dirBuiltinArgs(input)
+	var err error
+	switch name {
+	case "cd":
+		err = builtinCd(args)
+	case "pushd":
+		err = builtinPushd(args)
+	case "popd":
+		err = builtinPopd(args)
+	case "dirs":
+		err = builtinDirs(args)
+	case "z":
+		err = builtinZ(args)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, cError(fmt.Sprintf("%s: %v", name, err)))
+	}
+}
+
+// changeDir cambia de directorio, actualiza PWD/OLDPWD y lo anota en el historial.
+func changeDir(dir string) error {
+	oldDir, _ := os.Getwd()
+	if err := os.Chdir(dir); err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("%s: no existe el directorio", dir)
+		}
+		if pathErr, ok := err.(*os.PathError); ok {
+			return fmt.Errorf("%s: %v", dir, pathErr.Err)
+		}
+		return err
+	}
+	newDir, _ := os.Getwd()
+	if oldDir != newDir {
+		previousDir = oldDir
+	}
+	os.Setenv("OLDPWD", previousDir)
+	os.Setenv("PWD", newDir)
+	recordDirVisit(newDir)
+	return nil
+}
+
+// builtinCd implementa "cd [-L|-P] [dir|-]" con CDPATH.
+func builtinCd(args []string) error {
+	for len(args) > 0 && (args[0] == "-L" || args[0] == "-P" || args[0] == "--") {
+		args = args[1:]
+	}
+	if len(args) > 1 {
+		return fmt.Errorf("demasiados argumentos")
+	}
+	if len(args) == 0 {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return fmt.Errorf("no se encuentra el home dir: %v", err)
+		}
+		return changeDir(home)
+	}
+
+	dir := args[0]
+	if dir == "-" {
+		if previousDir == "" {
+			return fmt.Errorf("OLDPWD no establecido")
+		}
+		target := previousDir
+		if err := changeDir(target); err != nil {
+			return err
+		}
+		fmt.Println(target)
+		return nil
+	}
+	if target, ok := resolveCdPath(dir); ok {
+		if err := changeDir(target); err != nil {
+			return err
+		}
+		fmt.Println(target)
+		return nil
+	}
+	return changeDir(dir)
+}
+
+// resolveCdPath busca dir en los directorios de CDPATH como bash: sólo para rutas
+// relativas que no empiezan por "." o "..", y sólo si no se encuentra en CDPATH el
+// propio directorio actual (entrada vacía o ".").
+func resolveCdPath(dir string) (string, bool) {
+	cdPath := os.Getenv("CDPATH")
+	if cdPath == "" || filepath.IsAbs(dir) || dir == "." || dir == ".." ||
+		strings.HasPrefix(dir, "./") || strings.HasPrefix(dir, "../") {
+		return "", false
+	}
+	for _, base := range filepath.SplitList(cdPath) {
+		if base == "" || base == "." {
+			if info, err := os.Stat(dir); err == nil && info.IsDir() {
+				return "", false // Relativo al CWD: bash no imprime nada
+			}
+			continue
+		}
+		candidate := filepath.Join(base, dir)
+		if info, err := os.Stat(candidate); err == nil && info.IsDir() {
+			return candidate, true
+		}
+	}
+	return "", false
+}
+
+// builtinPushd implementa "pushd [dir | +n | -n]"; sin argumentos intercambia los dos
+// primeros directorios de la pila.
+func builtinPushd(args []string) error {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	if len(args) > 1 {
+		return fmt.Errorf("demasiados argumentos")
+	}
+	full := append([]string{cwd}, dirStack...)
+
+	switch {
+	case len(args) == 0:
+		if len(dirStack) == 0 {
+			return fmt.Errorf("no hay otro directorio")
+		}
+		full[0], full[1] = full[1], full[0]
+	case isStackIndex(args[0]):
+		n, err := stackIndex(args[0], len(full))
+		if err != nil {
+			return err
+		}
+		full = append(append([]string{}, full[n:]...), full[:n]...) // Rotación: el n-ésimo queda arriba
+	default:
+		target := args[0]
+		if resolved, ok := resolveCdPath(target); ok {
+			target = resolved
+		}
+		if err := changeDir(target); err != nil {
+			return err
+		}
+		dirStack = append([]string{cwd}, dirStack...)
+		return builtinDirs(nil)
+	}
+
+	if err := changeDir(full[0]); err != nil {
+		return err
+	}
+	dirStack = full[1:]
+	return builtinDirs(nil)
+}
+
+// builtinPopd implementa "popd [+n | -n]": quita el directorio superior de la pila y
+// cambia a él, o quita la entrada n sin cambiar de directorio.
+func builtinPopd(args []string) error {
+	if len(dirStack) == 0 {
+		return fmt.Errorf("la pila de directorios está vacía")
+	}
+	if len(args) > 1 {
+		return fmt.Errorf("demasiados argumentos")
+	}
+	if len(args) == 1 {
+		if !isStackIndex(args[0]) {
+			return fmt.Errorf("%s: argumento no válido (usa +n o -n)", args[0])
+		}
+		cwd, _ := os.Getwd()
+		full := append([]string{cwd}, dirStack...)
+		n, err := stackIndex(args[0], len(full))
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			dirStack = append(dirStack[:n-1], dirStack[n:]...)
+			return builtinDirs(nil)
+		}
+	}
+	if err := changeDir(dirStack[0]); err != nil {
+		return err
+	}
+	dirStack = dirStack[1:]
+	return builtinDirs(nil)
+}
+
+// builtinDirs implementa "dirs [-c] [-v] [-l]".
+func builtinDirs(args []string) error {
+	verbose, long := false, false
+	for _, arg := range args {
+		switch arg {
+		case "-c":
+			dirStack = nil
+			return nil
+		case "-v":
+			verbose = true
+		case "-l":
+			long = true
+		case "-p":
+			verbose = true
+		default:
+			return fmt.Errorf("%s: opción no válida (usa -c, -l, -p o -v)", arg)
+		}
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	full := append([]string{cwd}, dirStack...)
+	display := make([]string, len(full))
+	for i, dir := range full {
+		display[i] = dir
+		if !long {
+			display[i] = tildePath(dir)
+		}
+	}
+	if verbose {
+		for i, dir := range display {
+			fmt.Printf("%2d  %s\n", i, dir)
+		}
+		return nil
+	}
+	fmt.Println(strings.Join(display, " "))
+	return nil
+}
+
+// isStackIndex indica si el argumento es un índice de la pila (+n o -n).
+func isStackIndex(arg string) bool {
+	if len(arg) < 2 || (arg[0] != '+' && arg[0] != '-') {
+		return false
+	}
+	_, err := strconv.Atoi(arg[1:])
+	return err == nil
+}
+
+// stackIndex convierte +n (desde arriba) o -n (desde abajo) en un índice de la pila
+// completa (directorio actual incluido).
+func stackIndex(arg string, size int) (int, error) {
+	n, _ := strconv.Atoi(arg[1:])
+	if arg[0] == '-' {
+		n = size - 1 - n
+	}
+	if n < 0 || n >= size {
+		return 0, fmt.Errorf("%s: índice de la pila fuera de rango", arg)
+	}
+	return n, nil
+}
+
+// tildePath abrevia el home como "~".
+func tildePath(dir string) string {
+	home, err := os.UserHomeDir()
+	if err == nil && (dir == home || strings.HasPrefix(dir, home+"/")) {
+		return "~" + strings.TrimPrefix(dir, home)
+	}
+	return dir
+}
+
+// dirHistoryPath devuelve la ruta del historial de directorios.
+func dirHistoryPath() (string, error) {
+	dataHome := os.Getenv("XDG_DATA_HOME")
+	if dataHome == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dataHome = filepath.Join(home, ".local", "share")
+	}
+	return filepath.Join(dataHome, configDirName, dirHistoryFileName), nil
+}
+
+// loadDirHistory lee el historial de directorios (vacío si no existe).
+func loadDirHistory() []dirVisit {
+	path, err := dirHistoryPath()
+	if err != nil {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var visits []dirVisit
+	if err := json.Unmarshal(data, &visits); err != nil {
+		fmt.Fprintln(os.Stderr, cError(fmt.Sprintf("Error al leer %s: %v", path, err)))
+		return nil
+	}
+	return visits
+}
+
+// saveDirHistory guarda el historial de directorios de forma atómica.
+func saveDirHistory(visits []dirVisit) {
+	path, err := dirHistoryPath()
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return
+	}
+	data, err := json.Marshal(visits)
+	if err != nil {
+		return
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return
+	}
+	os.Rename(tmp, path)
+}
+
+// recordDirVisit suma una visita al directorio. Cuando la suma de rangos supera
+// dirHistoryMaxRank todas las entradas envejecen y las olvidadas se descartan.
+func recordDirVisit(dir string) {
+	visits := loadDirHistory()
+	now := time.Now().Unix()
+	found := false
+	total := 0.0
+	for i := range visits {
+		if visits[i].Path == dir {
+			visits[i].Rank++
+			visits[i].Last = now
+			found = true
+		}
+		total += visits[i].Rank
+	}
+	if !found {
+		visits = append(visits, dirVisit{Path: dir, Rank: 1, Last: now})
+		total++
+	}
+	if total > dirHistoryMaxRank {
+		kept := visits[:0]
+		for _, v := range visits {
+			v.Rank *= dirHistoryAging
+			if v.Rank >= dirHistoryMinRank {
+				kept = append(kept, v)
+			}
+		}
+		visits = kept
+	}
+	saveDirHistory(visits)
+}
+
+// frecency combina frecuencia y antigüedad de la última visita.
+func frecency(v dirVisit, now int64) float64 {
+	age := time.Duration(now-v.Last) * time.Second
+	switch {
+	case age < time.Hour:
+		return v.Rank * 4
+	case age < 24*time.Hour:
+		return v.Rank * 2
+	case age < 7*24*time.Hour:
+		return v.Rank * 0.5
+	}
+	return v.Rank * 0.25
+}
+
+// dirMatches indica si los fragmentos aparecen, en orden y sin distinguir mayúsculas,
+// en la ruta, y el último en su componente final.
+func dirMatches(path string, fragments []string) bool {
+	lower := strings.ToLower(path)
+	pos := 0
+	for _, frag := range fragments {
+		frag = strings.ToLower(frag)
+		idx := strings.Index(lower[pos:], frag)
+		if idx < 0 {
+			return false
+		}
+		pos += idx + len(frag)
+	}
+	last := strings.ToLower(fragments[len(fragments)-1])
+	return strings.Contains(strings.ToLower(filepath.Base(path)), last)
+}
+
+// rankedDirs devuelve los directorios del historial que coinciden con los fragmentos,
+// ordenados por frecency. Los que ya no existen se omiten.
+func rankedDirs(fragments []string) []dirVisit {
+	cwd, _ := os.Getwd()
+	now := time.Now().Unix()
+	var matched []dirVisit
+	for _, v := range loadDirHistory() {
+		if v.Path == cwd || (len(fragments) > 0 && !dirMatches(v.Path, fragments)) {
+			continue
+		}
+		if info, err := os.Stat(v.Path); err != nil || !info.IsDir() {
+			continue
+		}
+		matched = append(matched, v)
+	}
+	sort.SliceStable(matched, func(i, j int) bool {
+		return frecency(matched[i], now) > frecency(matched[j], now)
+	})
+	return matched
+}
+
+// builtinZ implementa "z <fragmentos>" (salta al mejor directorio) y
+// "z -l [fragmentos]" (lista los candidatos con su puntuación).
+func builtinZ(args []string) error {
+	list := false
+	if len(args) > 0 && args[0] == "-l" {
+		list = true
+		args = args[1:]
+	}
+	if !list && len(args) == 0 {
+		return builtinCd(nil)
+	}
+	// "z ruta" con una ruta existente se comporta como cd
+	if !list && len(args) == 1 {
+		if info, err := os.Stat(args[0]); err == nil && info.IsDir() {
+			return changeDir(args[0])
+		}
+	}
+
+	matched := rankedDirs(args)
+	if len(matched) == 0 {
+		return fmt.Errorf("ningún directorio visitado coincide con %q", strings.Join(args, " "))
+	}
+	if list {
+		now := time.Now().Unix()
+		for i, v := range matched {
+			if i >= dirListMax {
+				fmt.Println(cSystem(fmt.Sprintf("... y %d más", len(matched)-i)))
+				break
+			}
+			fmt.Printf("%s  %s\n", cSystem(fmt.Sprintf("%8.1f", math.Round(frecency(v, now)*10)/10)), tildePath(v.Path))
+		}
+		return nil
+	}
+	target := matched[0].Path
+	if err := changeDir(target); err != nil {
+		return err
+	}
+	fmt.Println(cSystem(tildePath(target)))
+	return nil
+}
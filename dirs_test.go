@@ -0,0 +1,100 @@
+// Copyright (c) 2025 Daniel Serrano Armenta. dani.eus79@gmail.com Todos los derechos reservados.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDirMatches(t *testing.T) {
+	tests := []struct {
+		path      string
+		fragments []string
+		want      bool
+	}{
+		{"/home/ana/proyectos/api", []string{"api"}, true},
+		{"/home/ana/proyectos/api", []string{"proy", "api"}, true},
+		{"/home/ana/proyectos/api", []string{"API"}, true},          // Sin distinguir mayúsculas
+		{"/home/ana/proyectos/api", []string{"api", "proy"}, false}, // En orden
+		{"/home/ana/proyectos/api", []string{"proy"}, false},        // El último, en el componente final
+		{"/home/ana/proyectos/api-v2", []string{"proy", "v2"}, true},
+		{"/srv/web", []string{"api"}, false},
+		{"/srv/aa", []string{"a", "a"}, true},
+		{"/srv/a", []string{"a", "a"}, false}, // Cada fragmento avanza por la ruta
+	}
+	for _, tt := range tests {
+		if got := dirMatches(tt.path, tt.fragments); got != tt.want {
+			t.Errorf("dirMatches(%q, %q) = %v, se esperaba %v", tt.path, tt.fragments, got, tt.want)
+		}
+	}
+}
+
+func TestFrecency(t *testing.T) {
+	now := time.Now().Unix()
+	tests := []struct {
+		age  time.Duration
+		want float64
+	}{
+		{time.Minute, 40},
+		{3 * time.Hour, 20},
+		{3 * 24 * time.Hour, 5},
+		{30 * 24 * time.Hour, 2.5},
+	}
+	for _, tt := range tests {
+		v := dirVisit{Path: "/x", Rank: 10, Last: now - int64(tt.age.Seconds())}
+		if got := frecency(v, now); got != tt.want {
+			t.Errorf("frecency con antigüedad %s = %v, se esperaba %v", tt.age, got, tt.want)
+		}
+	}
+}
+
+func TestRecordDirVisitAging(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+	saveDirHistory([]dirVisit{
+		{Path: "/muy/usado", Rank: dirHistoryMaxRank, Last: 1},
+		{Path: "/casi/olvidado", Rank: 1, Last: 1},
+	})
+	recordDirVisit("/nuevo")
+
+	visits := loadDirHistory()
+	ranks := make(map[string]float64)
+	for _, v := range visits {
+		ranks[v.Path] = v.Rank
+	}
+	if _, ok := ranks["/casi/olvidado"]; ok {
+		t.Error("una entrada por debajo de dirHistoryMinRank tras envejecer debería descartarse")
+	}
+	if got, want := ranks["/muy/usado"], dirHistoryMaxRank*dirHistoryAging; got != want {
+		t.Errorf("rango de /muy/usado = %v, se esperaba %v", got, want)
+	}
+}
+
+func TestRankedDirs(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+	root := t.TempDir()
+	api, web := filepath.Join(root, "proyectos", "api"), filepath.Join(root, "proyectos", "web")
+	for _, dir := range []string{api, web} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Chdir(root)
+	now := time.Now().Unix()
+	saveDirHistory([]dirVisit{
+		{Path: web, Rank: 10, Last: now - 30*24*3600}, // Muchas visitas, pero antiguas
+		{Path: api, Rank: 2, Last: now},
+		{Path: filepath.Join(root, "borrado"), Rank: 50, Last: now},
+		{Path: root, Rank: 50, Last: now}, // El directorio actual no es un destino
+	})
+
+	got := rankedDirs(nil)
+	if len(got) != 2 || got[0].Path != api || got[1].Path != web {
+		t.Errorf("rankedDirs = %v, se esperaba [api web]", got)
+	}
+	if got := rankedDirs([]string{"proy", "web"}); len(got) != 1 || got[0].Path != web {
+		t.Errorf("rankedDirs(proy web) = %v", got)
+	}
+}
@@ -0,0 +1,214 @@
+// Copyright (c) 2025 Daniel Serrano Armenta. dani.eus79@gmail.com Todos los derechos reservados.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ollama/ollama/api"
+)
+
+// --- Cola de Embeddings en Segundo Plano ---
+//
+// Los comandos que terminan con éxito se encolan aquí en lugar de lanzar una goroutine
+// por comando. Un único worker agrupa los textos pendientes y los vectoriza con una sola
+// llamada a /api/embed, reintentando con backoff si Ollama falla. Un comando permanece
+// marcado como "en cola" hasta que se guarda, así que repetirlo rápido no genera
+// duplicados. Los errores no se imprimen en mitad del prompt: se entregan como aviso
+// en la siguiente vuelta del bucle principal.
+
+const (
+	embeddingQueueBatchSize = 32
+	embeddingQueueDebounce  = 150 * time.Millisecond // Espera para agrupar comandos seguidos
+	embeddingQueueRetries   = 4
+	embeddingQueueBackoff   = 500 * time.Millisecond // Se duplica en cada reintento
+)
+
+// queuedCommand es un comando pendiente de vectorizar junto con su proyecto y, si lo
+// sugirió la IA, la petición que lo originó.
+type queuedCommand struct {
+	Command string
+	Project string
+	Request string
+}
+
+// semanticEmbeddingText es el texto que se vectoriza para una entrada del historial. Si
+// la sugirió la IA, incluye la petición: así las consultas en lenguaje natural (los
+// ejemplos few-shot, /buscar) se comparan también con lo que el usuario pidió y no sólo
+// con el texto del comando.
+func semanticEmbeddingText(command string, request string) string {
+	if request == "" {
+		return command
+	}
+	return request + "\n" + command
+}
+
+type embeddingQueue struct {
+	client *api.Client
+
+	mu      sync.Mutex
+	pending []queuedCommand
+	queued  map[string]bool // semanticKey de los pendientes o en vuelo
+
+	wake    chan struct{}
+	closing chan struct{}
+	done    chan struct{}
+}
+
+// newEmbeddingQueue crea la cola y arranca su worker.
+func newEmbeddingQueue(client *api.Client) *embeddingQueue {
+	q := &embeddingQueue{
+		client:  client,
+		queued:  make(map[string]bool),
+		wake:    make(chan struct{}, 1),
+		closing: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go q.run()
+	return q
+}
+
+// Enqueue añade un comando para vectorizarlo en segundo plano. Ignora los comandos que
+// ya están en el historial o en la cola para ese proyecto. Si el historial es de otro
+// proceso, el comando va a comandos-shell.jsonl para que lo procese él.
+func (q *embeddingQueue) Enqueue(item queuedCommand) {
+	if !isIndexableCommand(item.Command) {
+		return
+	}
+	key := semanticKey(item.Project, item.Command)
+
+	semanticHistoryLock.Lock()
+	_, exists := semanticHistoryIndex[key]
+	semanticHistoryLock.Unlock()
+	if exists {
+		return
+	}
+	if !ownsSemanticHistory() {
+		// Otro proceso posee el historial: se lo pasamos a través del archivo de la shell
+		rec := shellRecord{Command: item.Command, Project: item.Project, Request: item.Request, Time: time.Now().Unix()}
+		if err := appendShellSpool(rec); err != nil {
+			notifyBackground(cError(fmt.Sprintf("[Error al guardar el comando para el historial semántico: %v]", err)))
+		}
+		return
+	}
+
+	q.mu.Lock()
+	if q.queued[key] {
+		q.mu.Unlock()
+		return
+	}
+	q.queued[key] = true
+	q.pending = append(q.pending, item)
+	q.mu.Unlock()
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Close deja de aceptar trabajo, vacía lo pendiente y espera al worker (como mucho timeout).
+func (q *embeddingQueue) Close(timeout time.Duration) {
+	close(q.closing)
+	select {
+	case <-q.done:
+	case <-time.After(timeout):
+		q.mu.Lock()
+		lost := len(q.pending)
+		q.mu.Unlock()
+		fmt.Fprintln(os.Stderr, cError(fmt.Sprintf("Aviso: %d comandos no llegaron al historial semántico (Ollama no respondió a tiempo).", lost)))
+	}
+}
+
+func (q *embeddingQueue) run() {
+	defer close(q.done)
+	for {
+		select {
+		case <-q.wake:
+			// Pequeña espera para que los comandos seguidos viajen en el mismo lote
+			select {
+			case <-time.After(embeddingQueueDebounce):
+			case <-q.closing:
+			}
+			q.drain(false)
+		case <-q.closing:
+			q.drain(true)
+			return
+		}
+	}
+}
+
+// drain procesa lotes hasta vaciar la cola.
+func (q *embeddingQueue) drain(exiting bool) {
+	for {
+		q.mu.Lock()
+		n := min(len(q.pending), embeddingQueueBatchSize)
+		batch := append([]queuedCommand(nil), q.pending[:n]...)
+		q.pending = q.pending[n:]
+		q.mu.Unlock()
+		if len(batch) == 0 {
+			return
+		}
+
+		err := q.process(batch, exiting)
+
+		q.mu.Lock()
+		for _, item := range batch {
+			delete(q.queued, semanticKey(item.Project, item.Command))
+		}
+		q.mu.Unlock()
+
+		if err != nil {
+			msg := fmt.Sprintf("[Error de Embedding: %v] (%d comandos no se añadieron al historial semántico)", err, len(batch))
+			if exiting {
+				fmt.Fprintln(os.Stderr, cError(msg))
+			} else {
+				notifyBackground(cError(msg))
+			}
+		}
+	}
+}
+
+// process vectoriza un lote con reintentos y lo guarda en el historial.
+func (q *embeddingQueue) process(batch []queuedCommand, exiting bool) error {
+	texts := make([]string, len(batch))
+	for i, item := range batch {
+		texts[i] = semanticEmbeddingText(item.Command, item.Request)
+	}
+
+	var embeddings [][]float32
+	var err error
+	backoff := embeddingQueueBackoff
+	for attempt := 0; attempt < embeddingQueueRetries; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+		embeddings, err = getEmbeddings(ctx, q.client, texts)
+		cancel()
+		if err == nil {
+			break
+		}
+		if attempt == embeddingQueueRetries-1 || (exiting && attempt > 0) {
+			break // Tras el último intento, o al salir, no se espera a otro reintento
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+	if err != nil {
+		return err
+	}
+
+	entries := make([]SemanticHistoryEntry, len(batch))
+	for i, item := range batch {
+		entries[i] = SemanticHistoryEntry{
+			Command:   item.Command,
+			Embedding: embeddings[i],
+			Project:   item.Project,
+			Request:   item.Request,
+		}
+	}
+	_, err = storeSemanticEntries(entries)
+	return err
+}
@@ -0,0 +1,238 @@
+// Copyright (c) 2025 Daniel Serrano Armenta. dani.eus79@gmail.com Todos los derechos reservados.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// --- Ejecución de Comandos: Timeouts, Señales y Límites ---
+//
+// Todos los comandos (los del usuario y los de la IA) se lanzan con bash en su propio
+// grupo de procesos. Si hay terminal, ese grupo pasa a primer plano mientras dura el
+// comando (así sudo, ssh y compañía pueden leer de /dev/tty) y Ctrl+C le llega sólo a
+// él; sin terminal, terminal-ia captura SIGINT y lo reenvía al grupo (un segundo Ctrl+C
+// fuerza SIGKILL). SIGTERM también se reenvía, y Ctrl+Z detiene el comando y lo deja en
+// la tabla de trabajos (ver jobs.go). En ningún caso Ctrl+C cierra terminal-ia.
+// Opcionalmente se aplica un tiempo límite (global o por patrón de comando), límites de
+// CPU y memoria (ulimit) y un tamaño máximo de salida.
+
+const commandKillGrace = 3 * time.Second // Margen entre SIGTERM y SIGKILL
+
+// errTerminated indica que terminal-ia recibió SIGTERM mientras esperaba al comando. El
+// comando ya ha terminado (se le reenvió la señal); el bucle principal sale en cuanto
+// recupera el control, pasando por el mismo camino que "exit" para guardar el historial
+// y restaurar la terminal.
+var errTerminated = errors.New("terminal-ia recibió SIGTERM")
+
+var terminating atomic.Bool
+
+// terminationRequested indica si se recibió SIGTERM durante un comando.
+func terminationRequested() bool {
+	return terminating.Load()
+}
+
+// commandLimitError indica que terminal-ia detuvo el comando por superar un límite.
+type commandLimitError struct {
+	reason string
+}
+
+func (e *commandLimitError) Error() string { return e.reason }
+
+// outputLimiter cuenta los bytes escritos por el comando y avisa al superar el máximo.
+type outputLimiter struct {
+	mu       sync.Mutex
+	written  int64
+	max      int64
+	exceeded chan struct{}
+	once     sync.Once
+}
+
+type limitedWriter struct {
+	w io.Writer
+	l *outputLimiter
+}
+
+func (lw *limitedWriter) Write(p []byte) (int, error) {
+	lw.l.mu.Lock()
+	room := lw.l.max - lw.l.written
+	lw.l.written += int64(len(p))
+	lw.l.mu.Unlock()
+	if room <= 0 {
+		lw.l.once.Do(func() { close(lw.l.exceeded) })
+		return len(p), nil // Se descarta, el grupo se va a terminar
+	}
+	if int64(len(p)) > room {
+		lw.w.Write(p[:room])
+		lw.l.once.Do(func() { close(lw.l.exceeded) })
+		return len(p), nil
+	}
+	return lw.w.Write(p)
+}
+
+// commandTimeout devuelve el tiempo límite aplicable: el del patrón más específico de
+// command_timeouts que encaje o, si no hay ninguno, command_timeout.
+func commandTimeout(command string) time.Duration {
+	best, bestLen := appConfig.CommandTimeout, -1
+	for pattern, seconds := range appConfig.CommandTimeouts {
+		if len(pattern) > bestLen && globToRegexp(pattern).MatchString(command) {
+			best, bestLen = seconds, len(pattern)
+		}
+	}
+	if best <= 0 {
+		return 0
+	}
+	return time.Duration(best) * time.Second
+}
+
+// rlimitPrefix devuelve los "ulimit" a ejecutar antes del comando según la configuración.
+func rlimitPrefix() string {
+	prefix := ""
+	if appConfig.LimitCPUSeconds > 0 {
+		prefix += fmt.Sprintf("ulimit -t %d || exit 126; ", appConfig.LimitCPUSeconds)
+	}
+	if appConfig.LimitMemoryMB > 0 {
+		prefix += fmt.Sprintf("ulimit -v %d || exit 126; ", appConfig.LimitMemoryMB*1024)
+	}
+	return prefix
+}
+
+// runShellCommand ejecuta el comando con bash aplicando los límites configurados y
+// reenviando SIGINT/SIGTERM a su grupo de procesos. Devuelve el error de ejecución, un
+// *commandLimitError si terminal-ia lo detuvo, errJobStopped si se detuvo con Ctrl+Z
+// (en ese caso sigue vivo en la tabla de trabajos) o errTerminated si terminal-ia
+// recibió SIGTERM.
+func runShellCommand(command string, stdout io.Writer, stderr io.Writer) error {
+	limiter := &outputLimiter{max: int64(appConfig.LimitOutputMB) * 1024 * 1024, exceeded: make(chan struct{})}
+	if limiter.max > 0 {
+		stdout = &limitedWriter{w: stdout, l: limiter}
+		stderr = &limitedWriter{w: stderr, l: limiter}
+	}
+
+	// Capturar las señales antes de lanzar el proceso para no perder un Ctrl+C temprano
+	sigChan := make(chan os.Signal, 2)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigChan)
+
+	j, err := startJob(command, stdout, stderr, true, nil)
+	if err != nil {
+		return err
+	}
+	return waitForeground(j, sigChan, commandTimeout(command), limiter.exceeded)
+}
+
+// waitForeground espera a un trabajo en primer plano hasta que termina o se detiene,
+// reenviando las señales de sigChan y aplicando el tiempo límite y el límite de salida
+// (exceeded) si se indican. Al volver, la terminal es otra vez de terminal-ia.
+func waitForeground(j *job, sigChan chan os.Signal, timeout time.Duration, exceeded <-chan struct{}) error {
+	if j.tty >= 0 {
+		defer setTerminalForeground(j.tty, syscall.Getpgrp())
+	}
+	var timeoutChan <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		timeoutChan = timer.C
+	}
+
+	pgid := j.Pgid
+	var stopReason error
+	terminated := false
+	interrupts := 0
+	for {
+		select {
+		case <-j.changed:
+			jobsMu.Lock()
+			state, err := j.state, j.err
+			if state == jobStopped {
+				j.foreground = false
+			}
+			jobsMu.Unlock()
+
+			switch state {
+			case jobStopped:
+				if terminated || stopReason != nil {
+					continue // Se está terminando: terminateProcessGroup también envía SIGCONT
+				}
+				addJob(j)
+				fmt.Println(cSystem(fmt.Sprintf("\n[%d]+  %-*s %s", j.ID, jobStatusWide, "Detenido", j.Command)))
+				return errJobStopped
+			case jobDone:
+				j.waitCopies()
+				removeJob(j)
+				if terminated {
+					// SIGTERM para terminal-ia: ya se ha reenviado al comando, ahora salimos
+					terminating.Store(true)
+					return errTerminated
+				}
+				if stopReason != nil {
+					fmt.Fprintln(os.Stderr, cError(fmt.Sprintf("\n[Comando detenido: %v]", stopReason)))
+					return stopReason
+				}
+				return err
+			}
+
+		case sig := <-sigChan:
+			if sig == syscall.SIGTERM {
+				terminated = true
+				terminateProcessGroup(pgid)
+				continue
+			}
+			interrupts++
+			if interrupts == 1 {
+				syscall.Kill(-pgid, syscall.SIGINT)
+			} else {
+				syscall.Kill(-pgid, syscall.SIGKILL)
+			}
+
+		case <-timeoutChan:
+			stopReason = &commandLimitError{reason: fmt.Sprintf("tiempo límite de %s agotado", timeout)}
+			terminateProcessGroup(pgid)
+			timeoutChan = nil
+
+		case <-exceeded:
+			stopReason = &commandLimitError{reason: fmt.Sprintf("la salida supera %d MB", appConfig.LimitOutputMB)}
+			terminateProcessGroup(pgid)
+			exceeded = nil
+		}
+	}
+}
+
+// terminalForegroundPgrp devuelve el grupo de procesos en primer plano de la terminal
+// fd, o false si fd no es una terminal.
+func terminalForegroundPgrp(fd int) (int, bool) {
+	var pgrp int32
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), uintptr(syscall.TIOCGPGRP), uintptr(unsafe.Pointer(&pgrp)))
+	return int(pgrp), errno == 0
+}
+
+// setTerminalForeground devuelve la terminal al grupo indicado. Desde segundo plano
+// tcsetpgrp genera SIGTTOU, que se ignora durante la llamada.
+func setTerminalForeground(fd int, pgrp int) {
+	signal.Ignore(syscall.SIGTTOU)
+	defer signal.Reset(syscall.SIGTTOU)
+	p := int32(pgrp)
+	syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), uintptr(syscall.TIOCSPGRP), uintptr(unsafe.Pointer(&p)))
+}
+
+// terminateProcessGroup envía SIGTERM al grupo y, si sigue vivo tras commandKillGrace,
+// SIGKILL.
+func terminateProcessGroup(pgid int) {
+	syscall.Kill(-pgid, syscall.SIGTERM)
+	syscall.Kill(-pgid, syscall.SIGCONT) // Por si estaba detenido
+	go func() {
+		time.Sleep(commandKillGrace)
+		if syscall.Kill(-pgid, 0) == nil {
+			syscall.Kill(-pgid, syscall.SIGKILL)
+		}
+	}()
+}
@@ -0,0 +1,266 @@
+// Copyright (c) 2025 Daniel Serrano Armenta. dani.eus79@gmail.com Todos los derechos reservados.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"syscall"
+
+	"github.com/peterh/liner"
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// --- Análisis de Fallos: cuándo y con qué contexto ---
+//
+// No todo código de salida distinto de cero es un error: grep sin coincidencias o diff
+// con diferencias devuelven 1, y Ctrl+C termina el comando con una señal. Antes de
+// analizar un fallo se descartan las salidas por señales de interrupción (no los
+// cuelgues como SIGSEGV), los fallos detenidos por un límite de terminal-ia y los que
+// encajan en una regla de "analyze_ignore" (o en las de serie). El análisis recibe el
+// comando, el directorio, el código de salida y las últimas líneas de stdout y stderr.
+// Con "analyze_errors": "ask" se pregunta antes de analizar, y con "off" nunca se hace.
+
+const (
+	analyzeErrorsAuto = "auto"
+	analyzeErrorsAsk  = "ask"
+	analyzeErrorsOff  = "off"
+
+	failureTailBytes = 4096 // Lo que se guarda de cada salida
+	failureTailLines = 40   // Lo que se manda al modelo de cada salida
+)
+
+// defaultAnalyzeIgnore son los códigos de salida que no indican un error. La clave es un
+// programa (el último de una tubería) o un glob sobre la línea completa; una lista vacía
+// ignora cualquier código. Las reglas de analyze_ignore con la misma clave las sustituyen.
+var defaultAnalyzeIgnore = map[string][]int{
+	"grep": {1}, "egrep": {1}, "fgrep": {1}, "zgrep": {1}, "rg": {1}, "ag": {1}, "ack": {1},
+	"diff": {1}, "cmp": {1}, "colordiff": {1},
+	"test": {}, "[": {}, "[[": {}, "false": {},
+	"which": {1}, "type": {1}, "pgrep": {1}, "pidof": {1},
+	"git diff*":       {1},
+	"git grep*":       {1},
+	"command -v *":    {1},
+	"systemctl is-*":  {},
+	"git merge-base*": {1},
+}
+
+// interruptSignals son las señales con las que el usuario (o el sistema) termina un
+// comando a propósito; no se analizan.
+var interruptSignals = map[syscall.Signal]bool{
+	syscall.SIGINT: true, syscall.SIGTERM: true, syscall.SIGKILL: true, syscall.SIGHUP: true,
+	syscall.SIGPIPE: true, syscall.SIGQUIT: true, syscall.SIGTSTP: true,
+}
+
+var ansiEscapeRe = regexp.MustCompile(`\x1b\[[0-9;?]*[A-Za-z]`)
+
+// failureContext es lo que se sabe de un comando fallido.
+type failureContext struct {
+	Command  string
+	Cwd      string
+	ExitCode int
+	Signal   syscall.Signal // 0 si terminó normalmente
+	Stdout   string
+	Stderr   string
+}
+
+// tailBuffer guarda los últimos max bytes escritos.
+type tailBuffer struct {
+	buf []byte
+	max int
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.buf = append(t.buf, p...)
+	if len(t.buf) > 2*t.max {
+		t.buf = append(t.buf[:0], t.buf[len(t.buf)-t.max:]...)
+	}
+	return len(p), nil
+}
+
+func (t *tailBuffer) String() string {
+	if len(t.buf) > t.max {
+		return string(t.buf[len(t.buf)-t.max:])
+	}
+	return string(t.buf)
+}
+
+// commandExit extrae el código de salida y, si murió por una señal, cuál. Los códigos
+// 128+n de bash cuentan también como la señal n.
+func commandExit(err error) (int, syscall.Signal, bool) {
+	var jobErr *jobExitError
+	if errors.As(err, &jobErr) {
+		if jobErr.status.Signaled() {
+			sig := jobErr.status.Signal()
+			return 128 + int(sig), sig, true
+		}
+		code := jobErr.status.ExitStatus()
+		if code > 128 && code <= 128+64 {
+			return code, syscall.Signal(code - 128), true
+		}
+		return code, 0, true
+	}
+	var exitErr interface{ ExitCode() int }
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode(), 0, true
+	}
+	return -1, 0, false
+}
+
+// newFailureContext decide si un fallo merece análisis y, en ese caso, reúne su contexto.
+func newFailureContext(command string, err error, stdout string, stderr string) (failureContext, bool) {
+	var limitErr *commandLimitError
+	if err == nil || errors.Is(err, errJobStopped) || errors.Is(err, errTerminated) || errors.As(err, &limitErr) || appConfig.AnalyzeErrors == analyzeErrorsOff {
+		return failureContext{}, false
+	}
+	cwd, _ := os.Getwd()
+	fc := failureContext{Command: command, Cwd: cwd, Stdout: stdout, Stderr: stderr}
+	code, sig, ok := commandExit(err)
+	if !ok {
+		// No llegó a ejecutarse (bash no disponible, etc.)
+		fc.ExitCode = -1
+		fc.Stderr = strings.TrimSpace(stderr + "\n" + err.Error())
+		return fc, true
+	}
+	fc.ExitCode, fc.Signal = code, sig
+	if interruptSignals[sig] || ignoredFailure(command, code) {
+		return fc, false
+	}
+	if strings.TrimSpace(stdout) == "" && strings.TrimSpace(stderr) == "" {
+		return fc, false // Sin salida no hay nada que analizar
+	}
+	return fc, true
+}
+
+// ignoredFailure comprueba las reglas de analyze_ignore y las de serie.
+func ignoredFailure(command string, code int) bool {
+	rules := make(map[string][]int, len(defaultAnalyzeIgnore)+len(appConfig.AnalyzeIgnore))
+	for key, codes := range defaultAnalyzeIgnore {
+		rules[key] = codes
+	}
+	for key, codes := range appConfig.AnalyzeIgnore {
+		rules[key] = codes
+	}
+	program := lastPipelineProgram(command)
+	for key, codes := range rules {
+		var matches bool
+		if strings.ContainsAny(key, " *?") {
+			matches = globToRegexp(key).MatchString(strings.TrimSpace(command))
+		} else {
+			matches = key == program
+		}
+		if matches && (len(codes) == 0 || containsInt(codes, code)) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsInt(list []int, n int) bool {
+	for _, v := range list {
+		if v == n {
+			return true
+		}
+	}
+	return false
+}
+
+// lastPipelineProgram devuelve el programa cuyo código de salida es el de la línea: el
+// último de una tubería, saltando sudo, time y similares. Con && o || (o si no se puede
+// saber) devuelve "".
+func lastPipelineProgram(command string) string {
+	file, err := syntax.NewParser().Parse(strings.NewReader(command), "")
+	if err != nil || len(file.Stmts) == 0 {
+		return ""
+	}
+	stmt := file.Stmts[len(file.Stmts)-1]
+	for {
+		switch cmd := stmt.Cmd.(type) {
+		case *syntax.BinaryCmd:
+			if cmd.Op != syntax.Pipe && cmd.Op != syntax.PipeAll {
+				return ""
+			}
+			stmt = cmd.Y
+			continue
+		case *syntax.CallExpr:
+			for _, word := range cmd.Args {
+				switch lit := word.Lit(); lit {
+				case "sudo", "time", "nice", "nohup", "stdbuf":
+					continue
+				default:
+					return lit
+				}
+			}
+		case *syntax.TestClause:
+			return "[["
+		case *syntax.TimeClause:
+			// bash trata "time" como palabra reservada, no como un programa
+			if cmd.Stmt == nil {
+				return ""
+			}
+			stmt = cmd.Stmt
+			continue
+		}
+		return ""
+	}
+}
+
+// confirmFailureAnalysis pregunta si analizar el fallo cuando analyze_errors es "ask".
+func confirmFailureAnalysis(state *liner.State, fc failureContext) bool {
+	if appConfig.AnalyzeErrors != analyzeErrorsAsk {
+		return true
+	}
+	answer, err := state.Prompt(fmt.Sprintf("IA> %s falló (%s). ¿Analizar el error? [s/N]: ", fc.Command, describeExitCode(fc)))
+	return err == nil && strings.TrimSpace(strings.ToLower(answer)) == "s"
+}
+
+// describeExitCode explica el código de salida.
+func describeExitCode(fc failureContext) string {
+	switch {
+	case fc.Signal != 0:
+		return fmt.Sprintf("código %d, señal %s", fc.ExitCode, fc.Signal)
+	case fc.ExitCode == 126:
+		return "código 126: no es ejecutable o falta permiso"
+	case fc.ExitCode == 127:
+		return "código 127: comando no encontrado"
+	case fc.ExitCode < 0:
+		return "no se pudo ejecutar"
+	}
+	return fmt.Sprintf("código %d", fc.ExitCode)
+}
+
+// tailLines devuelve las últimas n líneas del texto, sin secuencias de color.
+func tailLines(text string, n int) string {
+	text = strings.TrimRight(ansiEscapeRe.ReplaceAllString(text, ""), "\n")
+	lines := strings.Split(text, "\n")
+	if len(lines) > n {
+		lines = append([]string{"... (líneas anteriores omitidas)"}, lines[len(lines)-n:]...)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// debugPrompt prepara el prompt de análisis de un comando fallido.
+func debugPrompt(fc failureContext) string {
+	var b strings.Builder
+	b.WriteString(debugSystemPrompt)
+	b.WriteString("\n\n")
+	if fc.Command != "" {
+		fmt.Fprintf(&b, "Comando: %s\n", fc.Command)
+	}
+	if fc.Cwd != "" {
+		fmt.Fprintf(&b, "Directorio: %s\n", fc.Cwd)
+	}
+	if fc.ExitCode != 0 {
+		fmt.Fprintf(&b, "Resultado: %s\n", describeExitCode(fc))
+	}
+	if stdout := tailLines(fc.Stdout, failureTailLines); strings.TrimSpace(stdout) != "" {
+		fmt.Fprintf(&b, "\nÚltimas líneas de stdout:\n```\n%s\n```\n", stdout)
+	}
+	if stderr := tailLines(fc.Stderr, failureTailLines); strings.TrimSpace(stderr) != "" {
+		fmt.Fprintf(&b, "\nÚltimas líneas de stderr:\n```\n%s\n```\n", stderr)
+	}
+	return b.String()
+}
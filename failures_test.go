@@ -0,0 +1,65 @@
+// Copyright (c) 2025 Daniel Serrano Armenta. dani.eus79@gmail.com Todos los derechos reservados.
+
+package main
+
+import "testing"
+
+func TestLastPipelineProgram(t *testing.T) {
+	tests := []struct {
+		command, want string
+	}{
+		{"grep x archivo", "grep"},
+		{"cat archivo | grep x", "grep"},
+		{"cat archivo |& sort | uniq -c", "uniq"},
+		{"sudo grep x /var/log/syslog", "grep"},
+		{"time nice go test ./...", "go"},
+		{"time make | tee log", "tee"},
+		{"cd /tmp; ls", "ls"},
+		{"make && make install", ""}, // && no es una tubería
+		{"[[ -f x ]]", "[["},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := lastPipelineProgram(tt.command); got != tt.want {
+			t.Errorf("lastPipelineProgram(%q) = %q, se esperaba %q", tt.command, got, tt.want)
+		}
+	}
+}
+
+func TestIgnoredFailure(t *testing.T) {
+	saved := appConfig.AnalyzeIgnore
+	t.Cleanup(func() { appConfig.AnalyzeIgnore = saved })
+	appConfig.AnalyzeIgnore = map[string][]int{
+		"curl":         {22},
+		"make check*":  {2},
+		"mi-validador": {},
+		"grep":         {1, 2}, // Sustituye a la regla de serie
+	}
+
+	tests := []struct {
+		command string
+		code    int
+		want    bool
+	}{
+		{"grep x archivo", 1, true},
+		{"grep x archivo", 2, true},
+		{"ls | grep x", 1, true},
+		{"grep x archivo | wc -l", 1, false}, // Decide el último programa
+		{"diff a b", 1, true},
+		{"diff a b", 2, false},
+		{"test -f x", 1, true},
+		{"git diff --quiet", 1, true},
+		{"git status", 1, false},
+		{"curl -f http://x", 22, true},
+		{"curl -f http://x", 7, false},
+		{"make check-all", 2, true},
+		{"make", 2, false},
+		{"mi-validador --strict", 5, true},
+		{"ls /noexiste", 2, false},
+	}
+	for _, tt := range tests {
+		if got := ignoredFailure(tt.command, tt.code); got != tt.want {
+			t.Errorf("ignoredFailure(%q, %d) = %v, se esperaba %v", tt.command, tt.code, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+// Copyright (c) 2025 Daniel Serrano Armenta. dani.eus79@gmail.com Todos los derechos reservados.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ollama/ollama/api"
+)
+
+// --- Ejemplos Few-Shot desde el Historial Semántico ---
+
+const (
+	fewShotMaxExamples = 3
+	fewShotMinScore    = 0.55 // Por debajo, los ejemplos confunden más de lo que ayudan
+)
+
+// buildFewShotExamples busca en el historial semántico los comandos que ya funcionaron
+// para peticiones parecidas y los formatea como ejemplos para el prompt de generación,
+// para que el modelo siga las convenciones reales del usuario (flags, hosts, scripts).
+// Devuelve "" si no hay ejemplos suficientemente parecidos o si falla el embedding.
+func buildFewShotExamples(client *api.Client, userPrompt string) string {
+	if index := currentSemanticIndex(); index == nil || index.Len() == 0 {
+		return ""
+	}
+	queryEmbedding, err := getEmbedding(client, userPrompt, embeddingModelName)
+	if err != nil {
+		return "" // Los ejemplos son opcionales: sin embeddings se genera igual
+	}
+
+	var examples strings.Builder
+	count := 0
+	for _, res := range searchSemanticHistory(queryEmbedding, fewShotMaxExamples, currentProject()) {
+		if res.Score < fewShotMinScore {
+			continue
+		}
+		if res.Request != "" {
+			examples.WriteString(fmt.Sprintf("\n\t- Petición: %s\n\t  Comando: %s", res.Request, res.Command))
+		} else {
+			examples.WriteString(fmt.Sprintf("\n\t- Comando: %s", res.Command))
+		}
+		count++
+	}
+	if count == 0 {
+		return ""
+	}
+
+	fmt.Println(cSystem(fmt.Sprintf("IA> Usando %d ejemplos de tu historial.", count)))
+	return "Ejemplos de comandos que el usuario ya ejecutó con éxito en peticiones parecidas. " +
+		"Sigue sus convenciones (flags, rutas, hosts, scripts) cuando sean aplicables:" + examples.String()
+}
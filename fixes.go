@@ -0,0 +1,221 @@
+// Copyright (c) 2025 Daniel Serrano Armenta. dani.eus79@gmail.com Todos los derechos reservados.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/ollama/ollama/api"
+	"github.com/peterh/liner"
+)
+
+// --- Sugerencias de Corrección tras el Análisis de un Error ---
+//
+// Al analizar un error, el modelo devuelve en la misma respuesta (modo JSON) la
+// explicación y un comando que lo arregla; se le indica el gestor de paquetes del
+// sistema para que pueda proponer instalar lo que falta. Antes que el comando del
+// modelo se prueban reglas rápidas al estilo thefuck (un programa mal escrito que se
+// parece a uno del PATH, o repetir con sudo un "Permission denied"). La sugerencia pasa
+// por la misma confirmación que cualquier comando de la IA (validación, vista previa,
+// políticas, "p" para probarla y "e" para editarla) y nunca se ejecuta sin confirmar,
+// aunque esté el modo auto.
+
+const (
+	fixMaxTypoDist = 2
+	fixModelName   = "analisis" // Modelo registrado en la auditoría para las reglas locales
+)
+
+var (
+	commandNotFoundRe  = regexp.MustCompile(`([^\s:]+): (?:command not found|orden no encontrada|no se encontró la orden|not found)`)
+	permissionDeniedRe = regexp.MustCompile(`(?i)permission denied|operation not permitted|permiso denegado|operación no permitida|must be (?:run as )?root|are you root|superuser|requires root`)
+	// remoteDeniedRe reconoce los rechazos de autenticación de ssh (también vía git, scp
+	// o rsync): con sudo se usarían las claves de root, que no es lo que se quiere.
+	remoteDeniedRe = regexp.MustCompile(`(?i)permission denied \((?:publickey|password|keyboard-interactive|gssapi)`)
+)
+
+// failureAnalysis es la respuesta JSON que se pide al modelo al analizar un error.
+type failureAnalysis struct {
+	Analysis string `json:"analysis"`
+	Command  string `json:"command"`
+	Reason   string `json:"reason"`
+}
+
+// failureAnalysisSchema es el esquema de salida estructurada para Ollama.
+var failureAnalysisSchema = json.RawMessage(`{"type":"object","properties":{"analysis":{"type":"string"},"command":{"type":"string"},"reason":{"type":"string"}},"required":["analysis","command","reason"]}`)
+
+// packageInstallCommand devuelve el comando para instalar paquetes con el gestor
+// detectado (sin el nombre del paquete), o "" si no se reconoce ninguno.
+func packageInstallCommand() string {
+	sudo := ""
+	if os.Geteuid() != 0 {
+		sudo = "sudo "
+	}
+	for _, pm := range []struct{ bin, install string }{
+		{"apt-get", sudo + "apt install"},
+		{"dnf", sudo + "dnf install"},
+		{"yum", sudo + "yum install"},
+		{"pacman", sudo + "pacman -S"},
+		{"zypper", sudo + "zypper install"},
+		{"apk", sudo + "apk add"},
+		{"brew", "brew install"},
+	} {
+		if _, err := exec.LookPath(pm.bin); err == nil {
+			return pm.install
+		}
+	}
+	return ""
+}
+
+// editDistance calcula la distancia de edición entre dos cadenas contando también el
+// intercambio de dos letras seguidas como un solo error ("gti" → "git").
+func editDistance(a, b string) int {
+	d := make([][]int, len(a)+1)
+	for i := range d {
+		d[i] = make([]int, len(b)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			d[i][j] = min(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				d[i][j] = min(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(a)][len(b)]
+}
+
+// closestExecutable busca en el PATH el programa más parecido a name.
+func closestExecutable(name string) string {
+	best, bestDist := "", fixMaxTypoDist+1
+	seen := map[string]bool{}
+	for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			candidate := entry.Name()
+			if seen[candidate] || abs(len(candidate)-len(name)) > fixMaxTypoDist {
+				continue
+			}
+			seen[candidate] = true
+			if d := editDistance(name, candidate); d < bestDist || (d == bestDist && candidate < best) {
+				best, bestDist = candidate, d
+			}
+		}
+	}
+	return best
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// localFix aplica las reglas rápidas. Devuelve "" si ninguna encaja.
+func localFix(fc failureContext) (string, string) {
+	command := strings.TrimSpace(fc.Command)
+
+	// Programa mal escrito: "gti status" → "git status"
+	if fc.ExitCode == 127 {
+		if m := commandNotFoundRe.FindStringSubmatch(fc.Stderr); m != nil && len(m[1]) > 2 {
+			if candidate := closestExecutable(m[1]); candidate != "" {
+				wordRe := regexp.MustCompile(`(^|[\s;|&(])` + regexp.QuoteMeta(m[1]) + `(\s|$)`)
+				if loc := wordRe.FindStringSubmatchIndex(command); loc != nil {
+					fixed := command[:loc[3]] + candidate + command[loc[3]+len(m[1]):]
+					return fixed, fmt.Sprintf("%s no existe; ¿querías decir %s?", m[1], candidate)
+				}
+			}
+		}
+	}
+
+	// Falta de permisos: repetir con sudo (como "sudo !!")
+	if fc.ExitCode != 126 && os.Geteuid() != 0 && !strings.HasPrefix(command, "sudo ") &&
+		permissionDeniedRe.MatchString(fc.Stderr) && !remoteDeniedRe.MatchString(fc.Stderr) {
+		if _, err := exec.LookPath("sudo"); err == nil {
+			return "sudo " + command, "el comando necesita permisos de administrador"
+		}
+	}
+	return "", ""
+}
+
+// analyzeFailure pide al modelo, en una sola llamada en modo JSON, la explicación del
+// error y, si hay uno claro, el comando que lo arregla.
+func analyzeFailure(ctx context.Context, client *api.Client, modelName string, fc failureContext) (failureAnalysis, error) {
+	var prompt strings.Builder
+	prompt.WriteString(debugPrompt(fc))
+	if install := packageInstallCommand(); install != "" {
+		fmt.Fprintf(&prompt, "\nPara instalar paquetes en este sistema se usa: %s <paquete>\n", install)
+	}
+	prompt.WriteString(`
+Devuelve SÓLO un JSON {"analysis": "...", "command": "...", "reason": "..."}: en "analysis" tu explicación del error y de cómo solucionarlo; en "command" UN comando de shell que lo solucione o repita el comando corregido (por ejemplo con sudo, con el nombre bien escrito o instalando el programa que falta), y en "reason" una frase breve explicándolo. Si no hay un comando claro, deja "command" vacío.`)
+
+	req := &api.GenerateRequest{
+		Model:  modelName,
+		Prompt: prompt.String(),
+		Stream: new(bool),
+		Format: failureAnalysisSchema,
+	}
+	var resp api.GenerateResponse
+	if err := client.Generate(ctx, req, func(r api.GenerateResponse) error {
+		resp = r
+		return nil
+	}); err != nil {
+		return failureAnalysis{}, err
+	}
+	var result failureAnalysis
+	if err := json.Unmarshal([]byte(strings.TrimSpace(resp.Response)), &result); err != nil {
+		return failureAnalysis{}, fmt.Errorf("respuesta JSON inválida: %v", err)
+	}
+	result.Analysis = strings.TrimSpace(result.Analysis)
+	result.Command = sanitizeIACommand(result.Command)
+	result.Reason = strings.TrimSpace(result.Reason)
+	return result, nil
+}
+
+// suggestFix ofrece la corrección del fallo con la confirmación de siempre
+// (confirmSuggestion), sin la opción 'x'. Las reglas locales tienen prioridad sobre el
+// comando del modelo, salvo la de repetir con sudo, que es sólo una suposición.
+func suggestFix(state *liner.State, modelName string, fc failureContext, analysis failureAnalysis) {
+	if fc.Command == "" {
+		return
+	}
+	command, reason := localFix(fc)
+	suggestionModel := fixModelName
+	sudoRetry := command == "sudo "+strings.TrimSpace(fc.Command)
+	if command == "" || (sudoRetry && analysis.Command != "") {
+		command, reason, suggestionModel = analysis.Command, analysis.Reason, modelName
+	}
+	command = strings.TrimSpace(command)
+	if command == "" || command == strings.TrimSpace(fc.Command) {
+		return
+	}
+	fmt.Println()
+	if reason != "" {
+		fmt.Println(cSystem("IA> Sugerencia: " + reason))
+	}
+	confirmSuggestion(state, commandSuggestion{
+		Command:  command,
+		Request:  "corregir: " + fc.Command,
+		Model:    suggestionModel,
+		Problems: validateShellCommand(command),
+		NoAuto:   true,
+	})
+}
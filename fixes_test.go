@@ -0,0 +1,70 @@
+// Copyright (c) 2025 Daniel Serrano Armenta. dani.eus79@gmail.com Todos los derechos reservados.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"git", "git", 0},
+		{"gti", "git", 1}, // Intercambio de dos letras seguidas
+		{"gi", "git", 1},
+		{"gitt", "git", 1},
+		{"dokcer", "docker", 1},
+		{"ls", "cat", 3},
+		{"", "ls", 2},
+	}
+	for _, tt := range tests {
+		if got := editDistance(tt.a, tt.b); got != tt.want {
+			t.Errorf("editDistance(%q, %q) = %d, se esperaba %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+// withFakePath deja en el PATH sólo un directorio con los ejecutables dados.
+func withFakePath(t *testing.T, programs ...string) {
+	t.Helper()
+	dir := t.TempDir()
+	for _, name := range programs {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", dir)
+}
+
+func TestLocalFix(t *testing.T) {
+	withFakePath(t, "git", "docker", "sudo")
+	sudoFix := "sudo cat /etc/shadow"
+	if os.Geteuid() == 0 {
+		sudoFix = "" // root no necesita sudo
+	}
+
+	tests := []struct {
+		name string
+		fc   failureContext
+		want string
+	}{
+		{"typo", failureContext{Command: "gti status", ExitCode: 127, Stderr: "bash: gti: command not found"}, "git status"},
+		{"typo tras tubería", failureContext{Command: "echo x | dokcer ps", ExitCode: 127, Stderr: "bash: dokcer: command not found"}, "echo x | docker ps"},
+		{"sin parecido", failureContext{Command: "zzzz", ExitCode: 127, Stderr: "bash: zzzz: command not found"}, ""},
+		{"permisos", failureContext{Command: "cat /etc/shadow", ExitCode: 1, Stderr: "cat: /etc/shadow: Permission denied"}, sudoFix},
+		{"ssh sin clave", failureContext{Command: "ssh servidor", ExitCode: 255, Stderr: "usuario@servidor: Permission denied (publickey,password)."}, ""},
+		{"git push sin clave", failureContext{Command: "git push", ExitCode: 128, Stderr: "git@github.com: Permission denied (publickey).\nfatal: Could not read from remote repository."}, ""},
+		{"ya con sudo", failureContext{Command: "sudo cat /x", ExitCode: 1, Stderr: "Permission denied"}, ""},
+		{"no ejecutable", failureContext{Command: "./script.sh", ExitCode: 126, Stderr: "bash: ./script.sh: Permission denied"}, ""},
+		{"otro error", failureContext{Command: "ls /x", ExitCode: 2, Stderr: "ls: cannot access '/x': No such file or directory"}, ""},
+	}
+	for _, tt := range tests {
+		if got, _ := localFix(tt.fc); got != tt.want {
+			t.Errorf("%s: localFix = %q, se esperaba %q", tt.name, got, tt.want)
+		}
+	}
+}
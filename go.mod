@@ -8,6 +8,7 @@ require (
 	github.com/lucasb-eyer/go-colorful v1.3.0
 	github.com/ollama/ollama v0.12.10
 	github.com/peterh/liner v1.2.2
+	mvdan.cc/sh/v3 v3.12.0
 )
 
 require (
@@ -0,0 +1,510 @@
+// Copyright (c) 2025 Daniel Serrano Armenta. dani.eus79@gmail.com Todos los derechos reservados.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ollama/ollama/api"
+)
+
+// --- Documentación Local (man / --help) para Fundamentar Comandos ---
+//
+// Los modelos pequeños se inventan flags. Para las herramientas implicadas en una
+// petición se lee su documentación local (man -P cat <tool>, o <tool> --help solo para
+// las herramientas conocidas de helpFallbackTools), se trocea,
+// se vectoriza con el modelo de embeddings y se guarda en caché. Los fragmentos más
+// relevantes se añaden al prompt de generación, y los flags del comando final se
+// comprueban contra los que aparecen en la documentación.
+
+const (
+	docsCacheDirName    = "docs"
+	docChunkMaxChars    = 800
+	docMaxChars         = 200 * 1024 // Páginas enormes (bash, zsh) se recortan
+	docMaxTools         = 3
+	docSnippetCount     = 3
+	docSnippetMinScore  = 0.35
+	docCommandTimeout   = 5 * time.Second
+	docsCacheVersion    = 1
+	docMinSourceLength  = 80 // Menos que esto no es ayuda real ("command not found"...)
+	docFlagPrefixLength = 2
+)
+
+// docChunk es un fragmento de documentación con su embedding.
+type docChunk struct {
+	Text      string    `json:"text"`
+	Embedding []float32 `json:"embedding"`
+}
+
+// toolDoc es la documentación procesada de una herramienta (también el formato de caché).
+type toolDoc struct {
+	Version   int        `json:"version"`
+	Tool      string     `json:"tool"`
+	Source    string     `json:"source"` // "man" o "--help"
+	Model     string     `json:"model"`
+	BinaryMod int64      `json:"binary_mod"` // mtime del binario; si cambia, se regenera
+	Flags     []string   `json:"flags"`
+	Chunks    []docChunk `json:"chunks"`
+
+	flagSet map[string]bool
+}
+
+var (
+	// flagPattern reconoce flags en texto de ayuda: -x, --long-flag, -name (estilo find).
+	flagPattern = regexp.MustCompile(`(?:^|[\s,\[|(])(--?[A-Za-z0-9][A-Za-z0-9_-]*)`)
+	// overstrikePattern elimina el negrita/subrayado por retroceso de la salida de man.
+	overstrikePattern = regexp.MustCompile(".\x08")
+	toolNamePattern   = regexp.MustCompile(`^[a-z0-9][a-z0-9._+-]*$`)
+)
+
+// docGroundingEnabled indica si está activa la consulta de documentación local.
+func docGroundingEnabled() bool {
+	return appConfig.DocGrounding == nil || *appConfig.DocGrounding
+}
+
+// commandWrappers son programas que ejecutan a otro; el binario relevante es el siguiente.
+var commandWrappers = map[string]bool{
+	"sudo": true, "doas": true, "env": true, "time": true, "nohup": true, "nice": true,
+	"ionice": true, "timeout": true, "xargs": true, "exec": true, "command": true, "builtin": true,
+}
+
+// helpFallbackTools son las herramientas conocidas a las que se puede pedir --help cuando
+// no tienen página man. Nunca se ejecuta --help de otros programas del PATH: un script
+// propio (deploy, backup...) puede ignorar --help y hacer su trabajo sin confirmación.
+var helpFallbackTools = map[string]bool{
+	"go": true, "cargo": true, "rustc": true, "rustup": true, "node": true, "npm": true,
+	"npx": true, "yarn": true, "pnpm": true, "deno": true, "bun": true, "python3": true,
+	"pip": true, "pip3": true, "kubectl": true, "helm": true, "docker": true, "podman": true,
+	"terraform": true, "gh": true, "aws": true, "gcloud": true, "az": true, "jq": true,
+	"yq": true, "rg": true, "fd": true, "bat": true, "fzf": true, "ffmpeg": true,
+}
+
+// toolsMentionedIn devuelve las palabras de la petición que son ejecutables del PATH.
+func toolsMentionedIn(text string) []string {
+	var tools []string
+	seen := make(map[string]bool)
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || strings.ContainsRune("._+-", r))
+	}) {
+		if len(word) < 2 || seen[word] || commandWrappers[word] || !toolNamePattern.MatchString(word) {
+			continue
+		}
+		seen[word] = true
+		if _, err := exec.LookPath(word); err == nil {
+			tools = append(tools, word)
+		}
+	}
+	return tools
+}
+
+// commandBinaries extrae los programas invocados en un comando de shell (primer palabra
+// de cada segmento separado por |, ;, && o ||, saltando asignaciones y envoltorios).
+func commandBinaries(command string) []string {
+	var tools []string
+	seen := make(map[string]bool)
+	for _, segment := range splitCommandSegments(command) {
+		name := segmentBinary(segment)
+		if name != "" && !seen[name] && toolNamePattern.MatchString(name) {
+			seen[name] = true
+			tools = append(tools, name)
+		}
+	}
+	return tools
+}
+
+// wrapperValueOptions son las opciones de los envoltorios que llevan su valor en la
+// palabra siguiente ("sudo -u root", "xargs -n 1"), para no tomarlo por el programa.
+var wrapperValueOptions = map[string]map[string]bool{
+	"sudo":    {"-u": true, "-g": true, "-h": true, "-p": true, "-C": true, "-D": true, "-r": true, "-t": true, "-U": true},
+	"doas":    {"-u": true, "-C": true},
+	"env":     {"-u": true, "-C": true, "--unset": true, "--chdir": true},
+	"nice":    {"-n": true, "--adjustment": true},
+	"ionice":  {"-c": true, "-n": true, "-p": true, "-P": true, "-u": true},
+	"timeout": {"-s": true, "-k": true, "--signal": true, "--kill-after": true},
+	"xargs": {"-a": true, "-d": true, "-E": true, "-I": true, "-L": true, "-n": true, "-P": true, "-s": true,
+		"--arg-file": true, "--delimiter": true, "--max-args": true, "--max-procs": true, "--max-chars": true, "--max-lines": true},
+}
+
+// segmentCommand devuelve el comando simple que ejecuta realmente un segmento: desde su
+// programa en adelante, sin asignaciones (VAR=valor), envoltorios (sudo, env...) ni
+// las opciones de éstos.
+func segmentCommand(words []string) []string {
+	wrapper := ""
+	for i := 0; i < len(words); i++ {
+		word := words[i]
+		if strings.HasPrefix(word, "-") {
+			if wrapperValueOptions[wrapper][word] {
+				i++ // sudo -u root
+			}
+			continue
+		}
+		if strings.Contains(word, "=") || isNumericWord(word) {
+			continue // VAR=valor, timeout 5...
+		}
+		if commandWrappers[filepath.Base(word)] {
+			wrapper = filepath.Base(word)
+			continue
+		}
+		return words[i:]
+	}
+	return nil
+}
+
+// segmentBinary devuelve el programa de un segmento (ver segmentCommand).
+func segmentBinary(words []string) string {
+	command := segmentCommand(words)
+	if len(command) == 0 {
+		return ""
+	}
+	return filepath.Base(command[0])
+}
+
+// splitCommandSegments trocea un comando en segmentos (|, ;, &&, ||, saltos de línea)
+// y cada segmento en palabras, respetando comillas simples y dobles.
+func splitCommandSegments(command string) [][]string {
+	var segments [][]string
+	var words []string
+	var word strings.Builder
+	inWord := false
+	var quote rune
+
+	flushWord := func() {
+		if inWord {
+			words = append(words, word.String())
+			word.Reset()
+			inWord = false
+		}
+	}
+	flushSegment := func() {
+		flushWord()
+		if len(words) > 0 {
+			segments = append(segments, words)
+			words = nil
+		}
+	}
+
+	runes := []rune(command)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inWord = true
+		case r == '\\' && i+1 < len(runes):
+			i++
+			word.WriteRune(runes[i])
+			inWord = true
+		case r == '|' || r == ';' || r == '&' || r == '\n' || r == '(' || r == ')':
+			flushSegment()
+		case r == ' ' || r == '\t':
+			flushWord()
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	flushSegment()
+	return segments
+}
+
+// docsCacheDir devuelve el directorio de caché de documentación.
+func docsCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, configDirName, docsCacheDirName)
+}
+
+// readToolHelp obtiene el texto de ayuda local de una herramienta: su página man o, si no
+// tiene y es una herramienta conocida, la salida de --help.
+func readToolHelp(tool string) (string, string) {
+	run := func(env []string, name string, args ...string) string {
+		ctx, cancel := context.WithTimeout(context.Background(), docCommandTimeout)
+		defer cancel()
+		cmd := exec.CommandContext(ctx, name, args...)
+		cmd.Env = append(os.Environ(), env...)
+		cmd.Stdin = nil // --help no debe esperar entrada
+		var out bytes.Buffer
+		cmd.Stdout = &out
+		cmd.Stderr = &out // Muchas herramientas imprimen la ayuda por stderr
+		cmd.Run()
+		text := overstrikePattern.ReplaceAllString(out.String(), "")
+		if len(text) > docMaxChars {
+			text = text[:docMaxChars]
+		}
+		return text
+	}
+
+	if _, err := exec.LookPath("man"); err == nil {
+		if text := run([]string{"MANWIDTH=100", "MANPAGER=cat"}, "man", "-P", "cat", tool); len(text) >= docMinSourceLength && !strings.HasPrefix(text, "No manual entry") {
+			return text, "man"
+		}
+	}
+	if !helpFallbackTools[tool] {
+		return "", ""
+	}
+	if text := run(nil, tool, "--help"); len(text) >= docMinSourceLength {
+		return text, "--help"
+	}
+	return "", ""
+}
+
+// chunkHelpText divide la ayuda en fragmentos de hasta docChunkMaxChars, cortando por
+// párrafos para no separar un flag de su descripción.
+func chunkHelpText(text string) []string {
+	var chunks []string
+	var current strings.Builder
+	for _, para := range strings.Split(text, "\n\n") {
+		para = strings.TrimRight(para, " \n")
+		if strings.TrimSpace(para) == "" {
+			continue
+		}
+		for len(para) > docChunkMaxChars {
+			cut := strings.LastIndex(para[:docChunkMaxChars], "\n")
+			if cut <= 0 {
+				cut = docChunkMaxChars
+			}
+			if current.Len() > 0 {
+				chunks = append(chunks, current.String())
+				current.Reset()
+			}
+			chunks = append(chunks, para[:cut])
+			para = strings.TrimLeft(para[cut:], "\n")
+		}
+		if current.Len()+len(para) > docChunkMaxChars && current.Len() > 0 {
+			chunks = append(chunks, current.String())
+			current.Reset()
+		}
+		if current.Len() > 0 {
+			current.WriteString("\n\n")
+		}
+		current.WriteString(para)
+	}
+	if current.Len() > 0 {
+		chunks = append(chunks, current.String())
+	}
+	return chunks
+}
+
+// extractHelpFlags devuelve los flags que aparecen en el texto de ayuda.
+func extractHelpFlags(text string) []string {
+	seen := make(map[string]bool)
+	var flags []string
+	for _, m := range flagPattern.FindAllStringSubmatch(text, -1) {
+		flag := strings.TrimRight(m[1], "-_")
+		if len(flag) >= docFlagPrefixLength && !seen[flag] {
+			seen[flag] = true
+			flags = append(flags, flag)
+		}
+	}
+	sort.Strings(flags)
+	return flags
+}
+
+// loadToolDoc devuelve la documentación de una herramienta, desde caché si está al día.
+func loadToolDoc(client *api.Client, tool string) *toolDoc {
+	path, err := exec.LookPath(tool)
+	if err != nil {
+		return nil
+	}
+	var binaryMod int64
+	if info, err := os.Stat(path); err == nil {
+		binaryMod = info.ModTime().Unix()
+	}
+
+	cacheDir := docsCacheDir()
+	cachePath := filepath.Join(cacheDir, tool+".json")
+	if data, err := os.ReadFile(cachePath); err == nil {
+		var doc toolDoc
+		if json.Unmarshal(data, &doc) == nil && doc.Version == docsCacheVersion &&
+			doc.Model == embeddingModelName && doc.BinaryMod == binaryMod {
+			doc.indexFlags()
+			return &doc
+		}
+	}
+
+	text, source := readToolHelp(tool)
+	if text == "" {
+		return nil
+	}
+	chunks := chunkHelpText(text)
+	doc := &toolDoc{
+		Version:   docsCacheVersion,
+		Tool:      tool,
+		Source:    source,
+		Model:     embeddingModelName,
+		BinaryMod: binaryMod,
+		Flags:     extractHelpFlags(text),
+	}
+	for start := 0; start < len(chunks); start += importBatchSize {
+		end := min(start+importBatchSize, len(chunks))
+		ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+		embeddings, err := getEmbeddings(ctx, client, chunks[start:end])
+		cancel()
+		if err != nil {
+			break // Sin embeddings seguimos teniendo los flags para la comprobación
+		}
+		for i, chunk := range chunks[start:end] {
+			doc.Chunks = append(doc.Chunks, docChunk{Text: chunk, Embedding: embeddings[i]})
+		}
+	}
+	doc.indexFlags()
+
+	if cacheDir != "" && len(doc.Chunks) == len(chunks) {
+		if err := os.MkdirAll(cacheDir, 0755); err == nil {
+			if data, err := json.Marshal(doc); err == nil {
+				os.WriteFile(cachePath, data, 0644)
+			}
+		}
+	}
+	return doc
+}
+
+func (d *toolDoc) indexFlags() {
+	d.flagSet = make(map[string]bool, len(d.Flags))
+	for _, f := range d.Flags {
+		d.flagSet[f] = true
+	}
+}
+
+// loadToolDocs carga la documentación de varias herramientas (como mucho docMaxTools).
+func loadToolDocs(client *api.Client, tools []string) map[string]*toolDoc {
+	docs := make(map[string]*toolDoc)
+	for _, tool := range tools {
+		if len(docs) >= docMaxTools {
+			break
+		}
+		if doc := loadToolDoc(client, tool); doc != nil {
+			docs[tool] = doc
+		}
+	}
+	return docs
+}
+
+// relevantDocSnippets selecciona los fragmentos de documentación más parecidos a la
+// petición y los formatea para el prompt. Devuelve "" si no hay ninguno relevante.
+func relevantDocSnippets(client *api.Client, userPrompt string, docs map[string]*toolDoc) string {
+	if len(docs) == 0 {
+		return ""
+	}
+	queryEmbedding, err := getEmbedding(client, userPrompt, embeddingModelName)
+	if err != nil {
+		return ""
+	}
+
+	type scored struct {
+		tool  string
+		text  string
+		score float64
+	}
+	var all []scored
+	for tool, doc := range docs {
+		for _, chunk := range doc.Chunks {
+			all = append(all, scored{tool: tool, text: chunk.Text, score: cosineSimilarity(queryEmbedding, chunk.Embedding)})
+		}
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].score > all[j].score })
+
+	var snippets strings.Builder
+	for i := 0; i < len(all) && i < docSnippetCount; i++ {
+		if all[i].score < docSnippetMinScore {
+			break
+		}
+		snippets.WriteString(fmt.Sprintf("\n[%s]\n%s\n", all[i].tool, all[i].text))
+	}
+	if snippets.Len() == 0 {
+		return ""
+	}
+	return "Extractos de la documentación local de las herramientas (usa SOLO flags que existan aquí o que conozcas con certeza):" + snippets.String()
+}
+
+// checkCommandFlags compara los flags del comando con los documentados y devuelve un
+// aviso por cada flag que no aparece en la ayuda de su herramienta.
+func checkCommandFlags(command string, docs map[string]*toolDoc) []string {
+	var warnings []string
+	for _, segment := range splitCommandSegments(command) {
+		var doc *toolDoc
+		args := segment
+		for i, word := range segment {
+			if commandWrappers[word] || strings.Contains(word, "=") && !strings.HasPrefix(word, "-") {
+				continue
+			}
+			doc = docs[filepath.Base(word)]
+			args = segment[i+1:]
+			break
+		}
+		if doc == nil {
+			continue
+		}
+		for _, arg := range args {
+			if arg == "--" {
+				break // A partir de aquí son argumentos posicionales
+			}
+			if !strings.HasPrefix(arg, "-") || arg == "-" || isNumericFlag(arg) {
+				continue
+			}
+			if !doc.hasFlag(arg) {
+				warnings = append(warnings, fmt.Sprintf("El flag %s no aparece en la ayuda de %s (%s).", arg, doc.Tool, doc.Source))
+			}
+		}
+	}
+	return warnings
+}
+
+// hasFlag comprueba un flag contra la ayuda, admitiendo --flag=valor y flags cortos
+// agrupados (-la = -l -a).
+func (d *toolDoc) hasFlag(arg string) bool {
+	flag := arg
+	if eq := strings.Index(flag, "="); eq > 0 {
+		flag = flag[:eq]
+	}
+	if d.flagSet[flag] {
+		return true
+	}
+	if strings.HasPrefix(flag, "--") || len(flag) <= 2 {
+		return false
+	}
+	for _, r := range flag[1:] {
+		if !d.flagSet["-"+string(r)] {
+			return false
+		}
+	}
+	return true
+}
+
+// isNumericFlag detecta argumentos como -5 (head -5) o -1 que no son flags con nombre.
+func isNumericFlag(arg string) bool {
+	for _, r := range arg[1:] {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// sortedDocTools devuelve los nombres de las herramientas con documentación cargada.
+func sortedDocTools(docs map[string]*toolDoc) []string {
+	tools := make([]string, 0, len(docs))
+	for tool := range docs {
+		tools = append(tools, tool)
+	}
+	sort.Strings(tools)
+	return tools
+}
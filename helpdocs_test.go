@@ -0,0 +1,29 @@
+// Copyright (c) 2025 Daniel Serrano Armenta. dani.eus79@gmail.com Todos los derechos reservados.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadToolHelpSkipsUnknownTools(t *testing.T) {
+	dir := t.TempDir()
+	marker := filepath.Join(dir, "ejecutado")
+	script := "#!/bin/sh\ntouch " + marker + "\n"
+	if err := os.WriteFile(filepath.Join(dir, "deploy"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir)
+
+	if got := toolsMentionedIn("haz deploy de la rama"); len(got) != 1 || got[0] != "deploy" {
+		t.Fatalf("toolsMentionedIn = %v", got)
+	}
+	if text, source := readToolHelp("deploy"); text != "" || source != "" {
+		t.Errorf("readToolHelp(deploy) = %q, %q; se esperaba vacío", text, source)
+	}
+	if _, err := os.Stat(marker); err == nil {
+		t.Error("readToolHelp ejecutó un programa desconocido del PATH")
+	}
+}
@@ -0,0 +1,328 @@
+// Copyright (c) 2025 Daniel Serrano Armenta. dani.eus79@gmail.com Todos los derechos reservados.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/ollama/ollama/api"
+)
+
+// --- Importación de Historiales de Shell (/importar historial) ---
+
+const importBatchSize = 64 // Textos por llamada a /api/embed
+
+// importedCommand es un comando leído de un historial externo.
+type importedCommand struct {
+	Command   string
+	Timestamp int64 // Unix; 0 si el historial no guarda fechas
+}
+
+// defaultHistoryPath devuelve la ruta habitual del historial de cada shell.
+func defaultHistoryPath(shell string) (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	switch shell {
+	case "bash":
+		if h := os.Getenv("HISTFILE"); h != "" && strings.Contains(os.Getenv("SHELL"), "bash") {
+			return h, nil
+		}
+		return filepath.Join(home, ".bash_history"), nil
+	case "zsh":
+		if h := os.Getenv("HISTFILE"); h != "" && strings.Contains(os.Getenv("SHELL"), "zsh") {
+			return h, nil
+		}
+		return filepath.Join(home, ".zsh_history"), nil
+	case "fish":
+		dataHome := os.Getenv("XDG_DATA_HOME")
+		if dataHome == "" {
+			dataHome = filepath.Join(home, ".local", "share")
+		}
+		return filepath.Join(dataHome, "fish", "fish_history"), nil
+	case "atuin":
+		dataHome := os.Getenv("XDG_DATA_HOME")
+		if dataHome == "" {
+			dataHome = filepath.Join(home, ".local", "share")
+		}
+		return filepath.Join(dataHome, "atuin", "history.db"), nil
+	}
+	return "", fmt.Errorf("shell no soportada: %s (usa bash, zsh, fish o atuin)", shell)
+}
+
+// parseBashHistory lee ~/.bash_history. Si HISTTIMEFORMAT estaba activo, cada comando
+// va precedido de una línea "#<timestamp>".
+func parseBashHistory(data []byte) []importedCommand {
+	var out []importedCommand
+	var ts int64
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") {
+			if v, err := strconv.ParseInt(line[1:], 10, 64); err == nil {
+				ts = v
+				continue
+			}
+		}
+		out = append(out, importedCommand{Command: line, Timestamp: ts})
+		ts = 0
+	}
+	return out
+}
+
+// unmetafyZsh deshace la codificación "metafied" con la que zsh guarda bytes especiales:
+// el byte 0x83 indica que el siguiente byte está XOR-eado con 32.
+func unmetafyZsh(data []byte) []byte {
+	const meta = 0x83
+	if bytes.IndexByte(data, meta) < 0 {
+		return data
+	}
+	out := make([]byte, 0, len(data))
+	for i := 0; i < len(data); i++ {
+		if data[i] == meta && i+1 < len(data) {
+			i++
+			out = append(out, data[i]^32)
+			continue
+		}
+		out = append(out, data[i])
+	}
+	return out
+}
+
+// parseZshHistory lee ~/.zsh_history en formato simple o extendido
+// (": <inicio>:<duración>;<comando>"). Las líneas terminadas en "\" continúan el comando.
+func parseZshHistory(data []byte) []importedCommand {
+	var out []importedCommand
+	lines := strings.Split(string(unmetafyZsh(data)), "\n")
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		for strings.HasSuffix(line, "\\") && i+1 < len(lines) {
+			i++
+			line = strings.TrimSuffix(line, "\\") + "\n" + lines[i]
+		}
+
+		var ts int64
+		if strings.HasPrefix(line, ": ") {
+			if semi := strings.Index(line, ";"); semi > 0 {
+				meta := strings.SplitN(line[2:semi], ":", 2)
+				ts, _ = strconv.ParseInt(strings.TrimSpace(meta[0]), 10, 64)
+				line = line[semi+1:]
+			}
+		}
+		out = append(out, importedCommand{Command: line, Timestamp: ts})
+	}
+	return out
+}
+
+// parseFishHistory lee el historial de fish, un pseudo-YAML con entradas "- cmd:" y
+// "  when:". En cmd, fish escapa los saltos de línea como \n y las barras como \\.
+func parseFishHistory(data []byte) []importedCommand {
+	var out []importedCommand
+	unescape := strings.NewReplacer(`\\`, `\`, `\n`, "\n")
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "- cmd: "):
+			out = append(out, importedCommand{Command: unescape.Replace(strings.TrimPrefix(line, "- cmd: "))})
+		case strings.HasPrefix(line, "  when: ") && len(out) > 0:
+			out[len(out)-1].Timestamp, _ = strconv.ParseInt(strings.TrimPrefix(line, "  when: "), 10, 64)
+		}
+	}
+	return out
+}
+
+// readAtuinHistory consulta la base de datos SQLite de atuin mediante el cliente sqlite3.
+func readAtuinHistory(path string) ([]importedCommand, error) {
+	if _, err := exec.LookPath("sqlite3"); err != nil {
+		return nil, fmt.Errorf("se necesita el programa 'sqlite3' para leer la base de datos de atuin")
+	}
+	query := "SELECT command, timestamp FROM history WHERE deleted_at IS NULL ORDER BY timestamp"
+	output, err := exec.Command("sqlite3", "-readonly", "-json", path, query).Output()
+	if err != nil {
+		return nil, fmt.Errorf("sqlite3 falló al leer %s: %v", path, err)
+	}
+
+	var rows []struct {
+		Command   string `json:"command"`
+		Timestamp int64  `json:"timestamp"` // Nanosegundos
+	}
+	if len(bytes.TrimSpace(output)) > 0 {
+		if err := json.Unmarshal(output, &rows); err != nil {
+			return nil, fmt.Errorf("respuesta inesperada de sqlite3: %v", err)
+		}
+	}
+	out := make([]importedCommand, len(rows))
+	for i, row := range rows {
+		out[i] = importedCommand{Command: row.Command, Timestamp: row.Timestamp / 1e9}
+	}
+	return out, nil
+}
+
+// readShellHistory lee y parsea el historial de la shell indicada.
+func readShellHistory(shell string, path string) ([]importedCommand, error) {
+	if shell == "atuin" {
+		return readAtuinHistory(path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	switch shell {
+	case "bash":
+		return parseBashHistory(data), nil
+	case "zsh":
+		return parseZshHistory(data), nil
+	case "fish":
+		return parseFishHistory(data), nil
+	}
+	return nil, fmt.Errorf("shell no soportada: %s", shell)
+}
+
+// dedupeImportedCommands limpia y deduplica los comandos, conservando la fecha de su
+// último uso, y los devuelve ordenados del más antiguo al más reciente.
+func dedupeImportedCommands(commands []importedCommand) []importedCommand {
+	latest := make(map[string]int, len(commands))
+	var unique []importedCommand
+	for _, c := range commands {
+		c.Command = strings.TrimSpace(c.Command)
+		if !isIndexableCommand(c.Command) {
+			continue
+		}
+		if i, ok := latest[c.Command]; ok {
+			if c.Timestamp > unique[i].Timestamp {
+				unique[i].Timestamp = c.Timestamp
+			}
+			continue
+		}
+		latest[c.Command] = len(unique)
+		unique = append(unique, c)
+	}
+	sort.SliceStable(unique, func(i, j int) bool { return unique[i].Timestamp < unique[j].Timestamp })
+	return unique
+}
+
+// handleImportCommand implementa "/importar historial <shell> [ruta]". Los comandos
+// importados no tienen proyecto (ámbito global). Se vectorizan por lotes y cada lote se
+// guarda en cuanto termina, así que si se interrumpe basta con repetir la orden: los ya
+// importados se detectan como duplicados y se saltan.
+func handleImportCommand(client *api.Client, args string) {
+	fields := strings.Fields(args)
+	if len(fields) < 2 || fields[0] != "historial" {
+		fmt.Println(cError("Uso: /importar historial <bash|zsh|fish|atuin> [ruta]"))
+		fmt.Println()
+		return
+	}
+	shell := strings.ToLower(fields[1])
+	path, err := defaultHistoryPath(shell)
+	if err != nil {
+		fmt.Println(cError(err.Error()))
+		fmt.Println()
+		return
+	}
+	if len(fields) > 2 {
+		path = strings.Join(fields[2:], " ")
+	}
+
+	commands, err := readShellHistory(shell, path)
+	if err != nil {
+		fmt.Println(cError(fmt.Sprintf("Error al leer el historial de %s: %v", shell, err)))
+		fmt.Println()
+		return
+	}
+	commands = dedupeImportedCommands(commands)
+	if err := claimSemanticHistory(); err != nil {
+		fmt.Println(cError(fmt.Sprintf("No se puede importar: %v.", err)))
+		fmt.Println()
+		return
+	}
+
+	// Saltar los que ya están en el historial semántico (importaciones previas o interrumpidas)
+	semanticHistoryLock.Lock()
+	pending := commands[:0]
+	for _, c := range commands {
+		if _, exists := semanticHistoryIndex[semanticKey("", c.Command)]; !exists {
+			pending = append(pending, c)
+		}
+	}
+	semanticHistoryLock.Unlock()
+
+	already := len(commands) - len(pending)
+	fmt.Println(cSystem(fmt.Sprintf("IA> %s: %d comandos únicos, %d ya estaban en el historial semántico.", path, len(commands), already)))
+	if len(pending) == 0 {
+		fmt.Println(cIA("IA> Nada que importar."))
+		fmt.Println()
+		return
+	}
+	fmt.Println(cIA(fmt.Sprintf("IA> Importando %d comandos...", len(pending))) + cSystem(" (Presiona Ctrl+C para interrumpir)"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT)
+	go func() {
+		<-sigChan
+		cancel()
+	}()
+	defer signal.Stop(sigChan)
+
+	imported := 0
+	for start := 0; start < len(pending); start += importBatchSize {
+		end := min(start+importBatchSize, len(pending))
+		batch := pending[start:end]
+
+		texts := make([]string, len(batch))
+		for i, c := range batch {
+			texts[i] = c.Command
+		}
+		embeddings, err := getEmbeddings(ctx, client, texts)
+		if err != nil {
+			fmt.Println()
+			if ctx.Err() != nil {
+				fmt.Println(cError(fmt.Sprintf("[Importación interrumpida: %d/%d]", imported, len(pending))))
+			} else {
+				fmt.Println(cError(fmt.Sprintf("Error de Embedding: %v", err)))
+			}
+			fmt.Println(cSystem(fmt.Sprintf("IA> Vuelve a ejecutar /importar historial %s para continuar donde se quedó.", shell)))
+			fmt.Println()
+			return
+		}
+
+		entries := make([]SemanticHistoryEntry, len(batch))
+		for i, c := range batch {
+			entries[i] = SemanticHistoryEntry{
+				Command:   c.Command,
+				Embedding: embeddings[i],
+				Timestamp: c.Timestamp,
+			}
+		}
+		n, err := storeSemanticEntries(entries)
+		if err != nil {
+			fmt.Println()
+			fmt.Println(cError(fmt.Sprintf("Error al guardar historial semántico: %v", err)))
+			fmt.Println()
+			return
+		}
+		imported += n
+		fmt.Print("\r" + renderProgressBar(end, len(pending), 30))
+	}
+
+	fmt.Println()
+	saveSemanticIndex()
+	fmt.Println(cIA(fmt.Sprintf("IA> Importación completa: %d comandos añadidos al historial semántico.", imported)))
+	fmt.Println()
+}
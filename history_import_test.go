@@ -0,0 +1,89 @@
+// Copyright (c) 2025 Daniel Serrano Armenta. dani.eus79@gmail.com Todos los derechos reservados.
+
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+// nonEmptyCommands quita las entradas vacías, que el importador descarta después.
+func nonEmptyCommands(commands []importedCommand) []importedCommand {
+	return slices.DeleteFunc(commands, func(c importedCommand) bool { return c.Command == "" })
+}
+
+func TestParseBashHistory(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want []importedCommand
+	}{
+		{"simple", "ls -la\ngit status\n", []importedCommand{{"ls -la", 0}, {"git status", 0}}},
+		{"con HISTTIMEFORMAT", "#1700000000\nmake\n#1700000100\nmake test\n", []importedCommand{{"make", 1700000000}, {"make test", 1700000100}}},
+		{"marca sólo para el siguiente", "#1700000000\nuno\ndos\n", []importedCommand{{"uno", 1700000000}, {"dos", 0}}},
+		{"comentario no numérico", "#TODO revisar\nls\n", []importedCommand{{"#TODO revisar", 0}, {"ls", 0}}},
+		{"sin salto final", "echo fin", []importedCommand{{"echo fin", 0}}},
+	}
+	for _, tt := range tests {
+		if got := parseBashHistory([]byte(tt.data)); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: parseBashHistory = %v, se esperaba %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseZshHistory(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want []importedCommand
+	}{
+		{"simple", "ls\ngit status\n", []importedCommand{{"ls", 0}, {"git status", 0}}},
+		{"extendido", ": 1700000000:0;make\n: 1700000100:12;go test ./...\n", []importedCommand{{"make", 1700000000}, {"go test ./...", 1700000100}}},
+		{"punto y coma en el comando", ": 1700000000:0;cd /tmp; ls\n", []importedCommand{{"cd /tmp; ls", 1700000000}}},
+		{"continuación", ": 1700000000:0;for i in 1 2; do\\\necho $i\\\ndone\nls\n", []importedCommand{{"for i in 1 2; do\necho $i\ndone", 1700000000}, {"ls", 0}}},
+		{"metafied", "echo \xc4\x83\xbf\n", []importedCommand{{"echo ğ", 0}}}, // ğ = C4 9F; 0x9F se guarda como 0x83 0xBF
+		{"utf-8 sin metafied", "echo año\n", []importedCommand{{"echo año", 0}}},
+	}
+	for _, tt := range tests {
+		if got := nonEmptyCommands(parseZshHistory([]byte(tt.data))); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: parseZshHistory = %q, se esperaba %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestUnmetafyZsh(t *testing.T) {
+	tests := []struct {
+		data, want string
+	}{
+		{"ls", "ls"},
+		{"\x83\xa3", "\x83"}, // El propio byte Meta
+		{"a\x83", "a\x83"},   // Meta al final: se deja tal cual
+	}
+	for _, tt := range tests {
+		if got := string(unmetafyZsh([]byte(tt.data))); got != tt.want {
+			t.Errorf("unmetafyZsh(%q) = %q, se esperaba %q", tt.data, got, tt.want)
+		}
+	}
+}
+
+func TestParseFishHistory(t *testing.T) {
+	data := `- cmd: ls -la
+  when: 1700000000
+- cmd: echo uno\necho dos
+  when: 1700000100
+  paths:
+    - /tmp
+- cmd: printf 'a\\b'
+- cmd: git status
+  when: no-es-un-número
+`
+	want := []importedCommand{
+		{"ls -la", 1700000000},
+		{"echo uno\necho dos", 1700000100},
+		{`printf 'a\b'`, 0},
+		{"git status", 0},
+	}
+	if got := parseFishHistory([]byte(data)); !slices.Equal(got, want) {
+		t.Errorf("parseFishHistory = %q, se esperaba %q", got, want)
+	}
+}
@@ -32,7 +32,7 @@ if [ ! -f "$BINARY_PATH" ]; then
     echo -e "${RED}Error: No se encontró el binario '$BINARY_NAME' en la carpeta:${NC}"
     echo -e "$SCRIPT_DIR"
     echo -e "${YELLOW}Por favor, primero compila el programa con:${NC}"
-    echo -e "go build -o $BINARY_NAME main.go"
+    echo -e "go build -o $BINARY_NAME ."
     exit 1
 fi
 echo -e "${GREEN}✔ Binario '$BINARY_NAME' encontrado en '$SCRIPT_DIR'.${NC}"
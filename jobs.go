@@ -0,0 +1,542 @@
+// Copyright (c) 2025 Daniel Serrano Armenta. dani.eus79@gmail.com Todos los derechos reservados.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// --- Control de Trabajos (jobs, fg, bg, kill %n) ---
+//
+// Un comando terminado en "&" se lanza como trabajo en segundo plano: se recupera el
+// prompt y terminal-ia sigue su estado. Ctrl+Z sobre un comando en primer plano lo
+// detiene y también lo convierte en trabajo. Los builtins jobs, fg, bg y kill %n
+// funcionan como en bash, y al terminar o detenerse un trabajo en segundo plano se avisa
+// en el siguiente prompt. Con "analyze_background_errors" la salida de error de los
+// trabajos fallidos se manda al análisis de errores al volver al prompt.
+
+const (
+	jobStderrMax  = 16 * 1024       // Salida de error guardada por trabajo para el análisis
+	jobCopyGrace  = 2 * time.Second // Espera máxima a que se vacíen las tuberías de salida
+	jobStatusWide = 11
+)
+
+type jobState int
+
+const (
+	jobRunning jobState = iota
+	jobStopped
+	jobDone
+)
+
+func (s jobState) String() string {
+	switch s {
+	case jobStopped:
+		return "Detenido"
+	case jobDone:
+		return "Terminado"
+	}
+	return "Ejecutando"
+}
+
+// job es un comando lanzado por terminal-ia en su propio grupo de procesos.
+type job struct {
+	ID      int // 0 mientras no esté en la tabla de trabajos
+	Command string
+	Pgid    int
+
+	process *os.Process
+	tty     int            // Terminal que controla mientras está en primer plano (-1 si no hay)
+	changed chan struct{}  // Aviso (no bloqueante) de cambio de estado
+	copies  sync.WaitGroup // Copias de las tuberías de salida
+	stderr  *limitedBuffer // Salida de error de los trabajos lanzados con &
+
+	// Protegidos por jobsMu
+	state      jobState
+	err        error
+	foreground bool
+}
+
+// jobExitError es el estado de salida no nulo de un trabajo.
+type jobExitError struct {
+	status syscall.WaitStatus
+}
+
+func (e *jobExitError) Error() string {
+	if e.status.Signaled() {
+		return "signal: " + e.status.Signal().String()
+	}
+	return fmt.Sprintf("exit status %d", e.status.ExitStatus())
+}
+
+// ExitCode devuelve el código de salida, o -1 si el proceso murió por una señal (como
+// exec.ExitError).
+func (e *jobExitError) ExitCode() int {
+	if e.status.Signaled() {
+		return -1
+	}
+	return e.status.ExitStatus()
+}
+
+// errJobStopped indica que el comando en primer plano se detuvo (Ctrl+Z) y pasó a la
+// tabla de trabajos.
+var errJobStopped = errors.New("trabajo detenido")
+
+var (
+	jobsMu         sync.Mutex
+	jobTable       []*job // En orden de uso: el último es el trabajo actual (%+)
+	failedJobs     []*job // Trabajos en segundo plano fallidos pendientes de análisis
+	jobsExitWarned bool
+)
+
+// startJob lanza el comando con bash en un grupo de procesos nuevo. Si foreground es
+// true y terminal-ia controla la terminal, el grupo pasa a primer plano. Las salidas
+// que no son archivos se copian mediante tuberías propias porque el proceso se espera
+// con wait4 (para ver las paradas) y no con exec.Cmd.Wait. Si stderrTail no es nil, la
+// salida de error se guarda también ahí para el análisis de errores.
+func startJob(command string, stdout io.Writer, stderr io.Writer, foreground bool, stderrTail *limitedBuffer) (*job, error) {
+	args := []string{"-c", command}
+	if prefix := rlimitPrefix(); prefix != "" {
+		// El comando se pasa como argumento para no tener que escaparlo
+		args = []string{"-c", prefix + `exec bash -c "$1"`, "bash", command}
+	}
+	cmd := exec.Command("bash", args...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	j := &job{Command: command, tty: -1, changed: make(chan struct{}, 1), foreground: foreground, stderr: stderrTail}
+	if stderrTail != nil {
+		stderr = io.MultiWriter(stderr, stderrTail)
+	}
+
+	ttyFd := int(os.Stdin.Fd())
+	if fg, ok := terminalForegroundPgrp(ttyFd); ok && fg == syscall.Getpgrp() {
+		// Con terminal el trabajo la usa como entrada: en segundo plano, leer de ella
+		// lo detiene (SIGTTIN) hasta que se traiga con fg
+		cmd.Stdin = os.Stdin
+		if foreground {
+			cmd.SysProcAttr.Foreground = true
+			cmd.SysProcAttr.Ctty = ttyFd
+			j.tty = ttyFd
+		}
+	}
+
+	var closeAfterStart []*os.File
+	attach := func(w io.Writer) (*os.File, error) {
+		if f, ok := w.(*os.File); ok {
+			return f, nil
+		}
+		pr, pw, err := os.Pipe()
+		if err != nil {
+			return nil, err
+		}
+		closeAfterStart = append(closeAfterStart, pw)
+		j.copies.Add(1)
+		go func() {
+			defer j.copies.Done()
+			io.Copy(w, pr)
+			pr.Close()
+		}()
+		return pw, nil
+	}
+	var err error
+	if cmd.Stdout, err = attach(stdout); err == nil {
+		cmd.Stderr, err = attach(stderr)
+	}
+	if err == nil {
+		err = cmd.Start()
+	}
+	for _, f := range closeAfterStart {
+		f.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	j.process = cmd.Process
+	j.Pgid = cmd.Process.Pid
+	go j.wait()
+	return j, nil
+}
+
+// wait sigue los cambios de estado del proceso hasta que termina.
+func (j *job) wait() {
+	for {
+		var ws syscall.WaitStatus
+		_, err := syscall.Wait4(j.Pgid, &ws, syscall.WUNTRACED|syscall.WCONTINUED, nil)
+		if err == syscall.EINTR {
+			continue
+		}
+		jobsMu.Lock()
+		switch {
+		case err != nil:
+			j.state, j.err = jobDone, err
+		case ws.Stopped():
+			j.state = jobStopped
+		case ws.Continued():
+			j.state = jobRunning
+		default:
+			j.state = jobDone
+			if ws.Signaled() || ws.ExitStatus() != 0 {
+				j.err = &jobExitError{status: ws}
+			}
+		}
+		state, background := j.state, !j.foreground
+		jobsMu.Unlock()
+
+		select {
+		case j.changed <- struct{}{}:
+		default:
+		}
+		if background {
+			j.reportBackground(state)
+		}
+		if state == jobDone {
+			j.process.Release()
+			return
+		}
+	}
+}
+
+// reportBackground avisa en el siguiente prompt de que un trabajo en segundo plano se
+// ha detenido o ha terminado.
+func (j *job) reportBackground(state jobState) {
+	switch state {
+	case jobStopped:
+		notifyBackground(cSystem(fmt.Sprintf("[%d]+  %-*s %s", j.ID, jobStatusWide, "Detenido", j.Command)))
+	case jobDone:
+		j.waitCopies()
+		removeJob(j)
+		if j.err == nil {
+			notifyBackground(cSystem(fmt.Sprintf("[%d]+  %-*s %s", j.ID, jobStatusWide, "Terminado", j.Command)))
+			return
+		}
+		notifyBackground(cError(fmt.Sprintf("[%d]+  %-*s %s", j.ID, jobStatusWide, fmt.Sprintf("Falló (%v)", j.err), j.Command)))
+		if appConfig.AnalyzeBackgroundErrors && j.stderr != nil && strings.TrimSpace(j.stderr.String()) != "" {
+			jobsMu.Lock()
+			failedJobs = append(failedJobs, j)
+			jobsMu.Unlock()
+		}
+	}
+}
+
+// waitCopies espera a que se vacíen las tuberías de salida. Un proceso hijo que sigue
+// vivo con la tubería abierta no debe bloquear el prompt, así que la espera es limitada.
+func (j *job) waitCopies() {
+	done := make(chan struct{})
+	go func() {
+		j.copies.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(jobCopyGrace):
+	}
+}
+
+// addJob añade el trabajo a la tabla (o lo mueve al final) y lo convierte en el actual.
+func addJob(j *job) {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	if j.ID == 0 {
+		j.ID = 1
+		for _, other := range jobTable {
+			if other.ID >= j.ID {
+				j.ID = other.ID + 1
+			}
+		}
+	}
+	removeJobLocked(j)
+	jobTable = append(jobTable, j)
+}
+
+// removeJob quita el trabajo de la tabla.
+func removeJob(j *job) {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	removeJobLocked(j)
+}
+
+func removeJobLocked(j *job) {
+	for i, other := range jobTable {
+		if other == j {
+			jobTable = append(jobTable[:i], jobTable[i+1:]...)
+			return
+		}
+	}
+}
+
+// findJob busca un trabajo por su especificación: "", "%", "%+" o "%%" para el actual,
+// "%-" para el anterior y "%n" (o "n") para el número n.
+func findJob(spec string) (*job, error) {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	if len(jobTable) == 0 {
+		return nil, fmt.Errorf("no hay trabajos")
+	}
+	switch spec {
+	case "", "%", "%+", "%%":
+		return jobTable[len(jobTable)-1], nil
+	case "%-":
+		if len(jobTable) < 2 {
+			return nil, fmt.Errorf("no hay trabajo anterior")
+		}
+		return jobTable[len(jobTable)-2], nil
+	}
+	id, err := strconv.Atoi(strings.TrimPrefix(spec, "%"))
+	if err != nil {
+		return nil, fmt.Errorf("especificación de trabajo no válida: %s", spec)
+	}
+	for _, j := range jobTable {
+		if j.ID == id {
+			return j, nil
+		}
+	}
+	return nil, fmt.Errorf("%s: no existe ese trabajo", spec)
+}
+
+// backgroundCommand indica si la línea es un único comando terminado en "&" y devuelve
+// el comando sin él. Las líneas con varios comandos se dejan a bash.
+func backgroundCommand(input string) (string, bool) {
+	file, err := syntax.NewParser(syntax.Variant(syntax.LangBash)).Parse(strings.NewReader(input), "")
+	if err != nil || len(file.Stmts) != 1 || !file.Stmts[0].Background {
+		return "", false
+	}
+	command := strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(input), "&"))
+	if command == "" || strings.HasSuffix(command, "&") {
+		return "", false
+	}
+	return command, true
+}
+
+// startBackgroundJob lanza un comando con & y lo añade a la tabla de trabajos.
+func startBackgroundJob(command string) {
+	j, err := startJob(command, os.Stdout, os.Stderr, false, &limitedBuffer{max: jobStderrMax})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, cError(fmt.Sprintf("Error al lanzar el trabajo: %v", err)))
+		return
+	}
+	addJob(j)
+	fmt.Println(cSystem(fmt.Sprintf("[%d] %d", j.ID, j.Pgid)))
+}
+
+// takeFailedJobs devuelve (y vacía) los trabajos fallidos pendientes de análisis.
+func takeFailedJobs() []*job {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	failed := failedJobs
+	failedJobs = nil
+	return failed
+}
+
+// isJobBuiltin indica si la línea es uno de los builtins de control de trabajos. "kill"
+// sólo se intercepta cuando se refiere a trabajos (%n); si no, lo ejecuta bash.
+func isJobBuiltin(input string) bool {
+	fields := strings.Fields(input)
+	if len(fields) == 0 {
+		return false
+	}
+	switch fields[0] {
+	case "jobs", "fg", "bg":
+		return true
+	case "kill":
+		return strings.Contains(input, "%")
+	}
+	return false
+}
+
+// handleJobBuiltin implementa jobs, fg, bg y kill %n.
+func handleJobBuiltin(input string) {
+	fields := strings.Fields(input)
+	args := fields[1:]
+	switch fields[0] {
+	case "jobs":
+		printJobs()
+	case "fg":
+		j, err := findJob(strings.Join(args, " "))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, cError("fg: "+err.Error()))
+			break
+		}
+		fmt.Println(j.Command)
+		if err := foregroundJob(j); err != nil && err != errJobStopped {
+			fmt.Fprintln(os.Stderr, cError(fmt.Sprintf("[%d] %s: %v", j.ID, j.Command, err)))
+		}
+	case "bg":
+		j, err := findJob(strings.Join(args, " "))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, cError("bg: "+err.Error()))
+			break
+		}
+		syscall.Kill(-j.Pgid, syscall.SIGCONT)
+		fmt.Println(cSystem(fmt.Sprintf("[%d]+ %s &", j.ID, j.Command)))
+	case "kill":
+		if err := killJobs(args); err != nil {
+			fmt.Fprintln(os.Stderr, cError("kill: "+err.Error()))
+		}
+	}
+	fmt.Println()
+}
+
+// printJobs muestra la tabla de trabajos como "jobs" de bash.
+func printJobs() {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	if len(jobTable) == 0 {
+		fmt.Println(cSystem("IA> No hay trabajos."))
+		return
+	}
+	for i, j := range jobTable {
+		mark := " "
+		switch i {
+		case len(jobTable) - 1:
+			mark = "+"
+		case len(jobTable) - 2:
+			mark = "-"
+		}
+		suffix := ""
+		if j.state == jobRunning {
+			suffix = " &"
+		}
+		fmt.Printf("[%d]%s  %-*s %s%s\n", j.ID, mark, jobStatusWide, j.state, j.Command, suffix)
+	}
+}
+
+// foregroundJob trae el trabajo a primer plano (fg) y espera a que termine o se detenga.
+func foregroundJob(j *job) error {
+	sigChan := make(chan os.Signal, 2)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigChan)
+
+	jobsMu.Lock()
+	j.foreground = true
+	jobsMu.Unlock()
+	ttyFd := int(os.Stdin.Fd())
+	if fg, ok := terminalForegroundPgrp(ttyFd); ok && fg == syscall.Getpgrp() {
+		j.tty = ttyFd
+		setTerminalForeground(ttyFd, j.Pgid)
+	}
+	// Vaciar un aviso de cambio anterior para no confundirlo con el de ahora
+	select {
+	case <-j.changed:
+	default:
+	}
+	syscall.Kill(-j.Pgid, syscall.SIGCONT)
+	return waitForeground(j, sigChan, 0, nil)
+}
+
+// killJobs implementa "kill [-SEÑAL | -s SEÑAL] %n|PID...". A un trabajo detenido se le
+// envía además SIGCONT para que reciba la señal.
+func killJobs(args []string) error {
+	sig := syscall.SIGTERM
+	var targets []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "-s" && i+1 < len(args):
+			s, err := parseSignal(args[i+1])
+			if err != nil {
+				return err
+			}
+			sig = s
+			i++
+		case strings.HasPrefix(arg, "-") && len(arg) > 1:
+			s, err := parseSignal(arg[1:])
+			if err != nil {
+				return err
+			}
+			sig = s
+		default:
+			targets = append(targets, arg)
+		}
+	}
+	if len(targets) == 0 {
+		return fmt.Errorf("uso: kill [-SEÑAL] %%n|PID...")
+	}
+
+	var errs []string
+	for _, target := range targets {
+		pid, stopped := 0, false
+		if strings.HasPrefix(target, "%") {
+			j, err := findJob(target)
+			if err != nil {
+				errs = append(errs, err.Error())
+				continue
+			}
+			jobsMu.Lock()
+			stopped = j.state == jobStopped
+			jobsMu.Unlock()
+			pid = -j.Pgid
+		} else {
+			n, err := strconv.Atoi(target)
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("%s: PID no válido", target))
+				continue
+			}
+			pid = n
+		}
+		if err := syscall.Kill(pid, sig); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", target, err))
+			continue
+		}
+		// Un trabajo detenido no recibe la señal hasta que continúa (como hace bash)
+		if stopped && sig != syscall.SIGSTOP && sig != syscall.SIGTSTP && sig != syscall.SIGCONT {
+			syscall.Kill(pid, syscall.SIGCONT)
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// parseSignal interpreta una señal por número o por nombre (KILL, SIGKILL, ...).
+func parseSignal(name string) (syscall.Signal, error) {
+	if n, err := strconv.Atoi(name); err == nil && n > 0 && n < 65 {
+		return syscall.Signal(n), nil
+	}
+	signals := map[string]syscall.Signal{
+		"HUP": syscall.SIGHUP, "INT": syscall.SIGINT, "QUIT": syscall.SIGQUIT, "KILL": syscall.SIGKILL,
+		"USR1": syscall.SIGUSR1, "USR2": syscall.SIGUSR2, "TERM": syscall.SIGTERM, "CONT": syscall.SIGCONT,
+		"STOP": syscall.SIGSTOP, "TSTP": syscall.SIGTSTP,
+	}
+	if sig, ok := signals[strings.TrimPrefix(strings.ToUpper(name), "SIG")]; ok {
+		return sig, nil
+	}
+	return 0, fmt.Errorf("%s: señal no válida", name)
+}
+
+// confirmExitWithJobs avisa, la primera vez, de que quedan trabajos al salir. Devuelve
+// true si se puede salir.
+func confirmExitWithJobs() bool {
+	jobsMu.Lock()
+	pending := len(jobTable)
+	jobsMu.Unlock()
+	if pending == 0 || jobsExitWarned {
+		return true
+	}
+	jobsExitWarned = true
+	fmt.Println(cSystem(fmt.Sprintf("IA> Hay %d trabajos en segundo plano o detenidos (usa 'jobs'). Vuelve a escribir 'exit' para salir y terminarlos.", pending)))
+	fmt.Println()
+	return false
+}
+
+// hangupJobs envía SIGHUP (y SIGCONT) a los trabajos que quedan al salir, como bash.
+func hangupJobs() {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	for _, j := range jobTable {
+		syscall.Kill(-j.Pgid, syscall.SIGHUP)
+		syscall.Kill(-j.Pgid, syscall.SIGCONT)
+	}
+}
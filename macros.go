@@ -0,0 +1,254 @@
+// Copyright (c) 2025 Daniel Serrano Armenta. dani.eus79@gmail.com Todos los derechos reservados.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// --- Alias y Macros de Usuario ---
+//
+// En config.json, "aliases" sustituye la primera palabra de un comando de shell (como
+// los alias de bash) y "macros" define comandos /nombre propios. Una macro tiene una
+// plantilla (text/template) de línea de shell ("shell") o de prompt de /chat ("chat").
+// La línea resultante se procesa como si se hubiera escrito, así que una macro de shell
+// puede usar builtins, "&" o incluso otro comando /. En las macros de chat los
+// argumentos "@archivo" se sustituyen por el contenido del archivo.
+//
+// Datos de la plantilla: {{.Args}} (argumentos tal cual), {{.Argv}} (separados),
+// {{.Text}} (argumentos sin los @archivo), {{.Files}} (contenido de los @archivo) y
+// {{.Cwd}}. La función quote escapa un valor para bash: {{quote .Args}}.
+
+const macroMaxFileBytes = 64 * 1024 // Por archivo incluido con @archivo
+
+// macroDef es una macro tal y como aparece en config.json.
+type macroDef struct {
+	Shell       string `json:"shell,omitempty"` // Plantilla de línea de comandos
+	Chat        string `json:"chat,omitempty"`  // Plantilla de prompt para /chat
+	Usage       string `json:"usage,omitempty"` // Argumentos para /help, ej. "<entorno>"
+	Description string `json:"description,omitempty"`
+
+	name string
+	tmpl *template.Template
+}
+
+// macroData son los datos disponibles en la plantilla.
+type macroData struct {
+	Args  string
+	Argv  []string
+	Text  string
+	Files string
+	Cwd   string
+}
+
+var (
+	userMacros    map[string]*macroDef
+	macroNameRe   = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+	macroTemplate = template.FuncMap{
+		"quote": func(s string) string {
+			quoted, err := syntax.Quote(s, syntax.LangBash)
+			if err != nil {
+				return "''"
+			}
+			return quoted
+		},
+	}
+)
+
+// builtinSlashCommands son los comandos / internos, tal y como se autocompletan.
+var builtinSlashCommands = []string{
+	"/help",
+	"/chat ",
+	"/buscar ",
+	"/importar historial ",
+	"/reindexar",
+	"/deshacer",
+	"/auditoria",
+	"/mcp",
+	"/reset",
+	"/tiempo ",
+	"/traducir ",
+	"/model",
+	"/ask",
+	"/config",
+}
+
+// loadMacros valida y compila las macros de la configuración. Las inválidas se
+// descartan con un aviso.
+func loadMacros() {
+	userMacros = map[string]*macroDef{}
+	reserved := map[string]bool{}
+	for _, cmd := range builtinSlashCommands {
+		reserved[strings.Fields(strings.TrimPrefix(cmd, "/"))[0]] = true
+	}
+	for name, def := range appConfig.Macros {
+		name = strings.TrimPrefix(name, "/")
+		warn := func(format string, args ...interface{}) {
+			fmt.Fprintln(os.Stderr, cError(fmt.Sprintf("Macro /%s descartada: ", name)+fmt.Sprintf(format, args...)))
+		}
+		switch {
+		case !macroNameRe.MatchString(name):
+			warn("el nombre sólo puede tener letras, números, '-' y '_'")
+			continue
+		case reserved[name]:
+			warn("coincide con un comando interno")
+			continue
+		case (def.Shell == "") == (def.Chat == ""):
+			warn("indica una plantilla \"shell\" o \"chat\" (sólo una)")
+			continue
+		}
+		text := def.Shell + def.Chat
+		tmpl, err := template.New(name).Funcs(macroTemplate).Option("missingkey=error").Parse(text)
+		if err != nil {
+			warn("%v", err)
+			continue
+		}
+		m := def
+		m.name = name
+		m.tmpl = tmpl
+		userMacros[name] = &m
+	}
+}
+
+// sortedMacros devuelve las macros ordenadas por nombre.
+func sortedMacros() []*macroDef {
+	macros := make([]*macroDef, 0, len(userMacros))
+	for _, m := range userMacros {
+		macros = append(macros, m)
+	}
+	sort.Slice(macros, func(i, j int) bool { return macros[i].name < macros[j].name })
+	return macros
+}
+
+// slashCompletions devuelve los comandos / internos y los de usuario para autocompletar.
+func slashCompletions() []string {
+	completions := append([]string{}, builtinSlashCommands...)
+	for _, m := range sortedMacros() {
+		completions = append(completions, "/"+m.name+" ")
+	}
+	return completions
+}
+
+// printMacroHelp añade las macros de usuario a /help.
+func printMacroHelp() {
+	if len(userMacros) == 0 {
+		return
+	}
+	fmt.Println(cSystem("--- Comandos Personalizados (config.json) ---"))
+	for _, m := range sortedMacros() {
+		usage := "/" + m.name
+		if m.Usage != "" {
+			usage += " " + m.Usage
+		}
+		description := m.Description
+		if description == "" {
+			kind := "shell"
+			if m.Chat != "" {
+				kind = "chat"
+			}
+			description = fmt.Sprintf("Macro de %s: %s", kind, strings.SplitN(m.Shell+m.Chat, "\n", 2)[0])
+		}
+		fmt.Println(cPrompt("  "+usage+" ") + cIA("- "+description))
+	}
+}
+
+// expandUserInput aplica los alias y las macros a la línea escrita. Devuelve la línea
+// a ejecutar (la original si no hay nada que expandir).
+func expandUserInput(input string) (string, error) {
+	if !strings.HasPrefix(input, "/") {
+		return expandAlias(input), nil
+	}
+	name, args, _ := strings.Cut(strings.TrimPrefix(input, "/"), " ")
+	m, found := userMacros[name]
+	if !found {
+		return input, nil
+	}
+	return m.expand(strings.TrimSpace(args))
+}
+
+// expand genera la línea de la macro con los argumentos dados.
+func (m *macroDef) expand(args string) (string, error) {
+	cwd, _ := os.Getwd()
+	data := macroData{Args: args, Argv: strings.Fields(args), Text: args, Cwd: cwd}
+	if m.Chat != "" {
+		var text []string
+		var files strings.Builder
+		for _, word := range data.Argv {
+			path, isFile := strings.CutPrefix(word, "@")
+			if !isFile || path == "" {
+				text = append(text, word)
+				continue
+			}
+			content, err := readMacroFile(path)
+			if err != nil {
+				return "", err
+			}
+			fmt.Fprintf(&files, "--- %s ---\n%s\n", path, content)
+		}
+		data.Text = strings.Join(text, " ")
+		data.Files = files.String()
+	}
+
+	var out strings.Builder
+	if err := m.tmpl.Execute(&out, data); err != nil {
+		return "", fmt.Errorf("/%s: %v", m.name, err)
+	}
+	line := strings.TrimSpace(out.String())
+	if line == "" {
+		return "", fmt.Errorf("/%s: la plantilla no genera nada", m.name)
+	}
+	if m.Chat != "" {
+		fmt.Println(cSystem(fmt.Sprintf("→ /%s: prompt de chat (%d caracteres)", m.name, len(line))))
+		return "/chat " + line, nil
+	}
+	fmt.Println(cSystem("→ " + line))
+	return line, nil
+}
+
+// readMacroFile lee un archivo incluido con @archivo (con ~ y límite de tamaño).
+func readMacroFile(path string) (string, error) {
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		if home, err := os.UserHomeDir(); err == nil {
+			path = filepath.Join(home, rest)
+		}
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("@%s: %v", path, err)
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, macroMaxFileBytes+1))
+	if err != nil {
+		return "", fmt.Errorf("@%s: %v", path, err)
+	}
+	if len(data) > macroMaxFileBytes {
+		return string(data[:macroMaxFileBytes]) + "\n... (archivo truncado)", nil
+	}
+	return string(data), nil
+}
+
+// expandAlias sustituye la primera palabra si es un alias. El resultado no se vuelve a
+// expandir, así que "ls": "ls --color=auto" no entra en bucle.
+func expandAlias(input string) string {
+	if len(appConfig.Aliases) == 0 {
+		return input
+	}
+	word, rest, _ := strings.Cut(input, " ")
+	value, ok := appConfig.Aliases[word]
+	if !ok {
+		return input
+	}
+	if rest == "" {
+		return value
+	}
+	return value + " " + rest
+}
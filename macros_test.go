@@ -0,0 +1,100 @@
+// Copyright (c) 2025 Daniel Serrano Armenta. dani.eus79@gmail.com Todos los derechos reservados.
+
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+// withMacros carga las macros indicadas durante el test.
+func withMacros(t *testing.T, macros map[string]macroDef) {
+	t.Helper()
+	saved, savedMacros := appConfig.Macros, userMacros
+	t.Cleanup(func() { appConfig.Macros, userMacros = saved, savedMacros })
+	appConfig.Macros = macros
+	loadMacros()
+}
+
+func TestExpandAlias(t *testing.T) {
+	saved := appConfig.Aliases
+	t.Cleanup(func() { appConfig.Aliases = saved })
+	appConfig.Aliases = map[string]string{"ll": "ls -la", "ls": "ls --color=auto", "g": "git"}
+
+	tests := []struct {
+		input, want string
+	}{
+		{"ll", "ls -la"},
+		{"ll /tmp", "ls -la /tmp"},
+		{"ls", "ls --color=auto"}, // No se vuelve a expandir
+		{"g status -s", "git status -s"},
+		{"gg status", "gg status"},
+		{"echo ll", "echo ll"}, // Sólo la primera palabra
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := expandAlias(tt.input); got != tt.want {
+			t.Errorf("expandAlias(%q) = %q, se esperaba %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestExpandMacros(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	if err := os.WriteFile("error.log", []byte("panic: nil map"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	withMacros(t, map[string]macroDef{
+		"deploy":  {Shell: "kubectl --context {{index .Argv 0}} apply -f k8s/"},
+		"buscar2": {Shell: "grep -rn {{quote .Args}} ."},
+		"revisa":  {Chat: "Explica este error: {{.Text}}\n{{.Files}}"},
+		"vacia":   {Shell: "{{if .Args}}echo {{.Args}}{{end}}"},
+		"donde":   {Shell: "echo {{.Cwd}}"},
+	})
+
+	tests := []struct {
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{"/deploy prod", "kubectl --context prod apply -f k8s/", false},
+		{"/deploy", "", true}, // Falta el argumento
+		{"/buscar2 it's here", `grep -rn "it's here" .`, false},
+		{"/revisa por qué falla @error.log", "/chat Explica este error: por qué falla\n--- error.log ---\npanic: nil map", false},
+		{"/revisa @no-existe.log", "", true},
+		{"/vacia", "", true}, // La plantilla no genera nada
+		{"/vacia hola", "echo hola", false},
+		{"/donde", "echo " + dir, false},
+		{"/help", "/help", false}, // No es una macro
+		{"ls", "ls", false},
+	}
+	for _, tt := range tests {
+		got, err := expandUserInput(tt.input)
+		if (err != nil) != tt.wantErr || (!tt.wantErr && got != tt.want) {
+			t.Errorf("expandUserInput(%q) = %q, %v; se esperaba %q (error: %v)", tt.input, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestLoadMacrosRejectsInvalid(t *testing.T) {
+	withMacros(t, map[string]macroDef{
+		"buscar":  {Shell: "echo x"},               // Comando interno
+		"mal nom": {Shell: "echo x"},               // Nombre inválido
+		"ambas":   {Shell: "echo x", Chat: "hola"}, // Sólo una plantilla
+		"ninguna": {},                              // Ninguna plantilla
+		"rota":    {Shell: "echo {{.Args"},         // Plantilla que no compila
+		"/valida": {Shell: "echo {{.Args}}"},       // La barra inicial se ignora
+	})
+	if len(userMacros) != 1 || userMacros["valida"] == nil {
+		var names []string
+		for name := range userMacros {
+			names = append(names, name)
+		}
+		t.Errorf("macros cargadas = %v, se esperaba sólo valida", names)
+	}
+	if got := slashCompletions(); !strings.Contains(strings.Join(got, ","), "/valida ") {
+		t.Errorf("slashCompletions no incluye /valida: %v", got)
+	}
+}
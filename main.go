@@ -3,7 +3,6 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -32,14 +31,16 @@ import (
 
 // --- Constantes del Programa ---
 const (
-	currentVersion       = "v25.0" // Persistencia de Chat + Embeddings Dedicados
-	repoOwner            = "danitxu79"
-	repoName             = "terminal-ia"
-	historyFileName      = ".terminal_ia_history"
-	embeddingHistoryFile = ".terminal_ia_embeddings.json"
-	chatHistoryFile      = ".terminal_ia_chat_history.json"
-	debugSystemPrompt    = "Eres un experto en depuración de comandos de Linux. Analiza el siguiente error de terminal (stderr), explica brevemente por qué ocurrió y proporciona una solución concisa que el usuario pueda copiar/pegar."
-	embeddingModelName   = "nomic-embed-text" // Modelo dedicado para embeddings
+	currentVersion             = "v25.0" // Persistencia de Chat + Embeddings Dedicados
+	repoOwner                  = "danitxu79"
+	repoName                   = "terminal-ia"
+	historyFileName            = ".terminal_ia_history"
+	embeddingHistoryFile       = ".terminal_ia_embeddings.bin"
+	embeddingIndexFile         = ".terminal_ia_embeddings.hnsw"
+	legacyEmbeddingHistoryFile = ".terminal_ia_embeddings.json" // Formato JSON anterior (se migra al arrancar)
+	chatHistoryFile            = ".terminal_ia_chat_history.json"
+	chatSystemPrompt           = "Eres un asistente de IA para terminal. Sé directo, conciso y técnico. Céntrate en la solicitud. Evita saludos largos o florituras innecesarias."
+	debugSystemPrompt          = "Eres un experto en depuración de comandos de Linux. Analiza el siguiente error de terminal (stderr), explica brevemente por qué ocurrió y proporciona una solución concisa que el usuario pueda copiar/pegar."
 )
 
 // --- Estructuras y Variables Globales de Estilo ---
@@ -57,13 +58,20 @@ var (
 
 	updateMessageChannel = make(chan string, 1)
 	githubLatestVersion  = currentVersion
+	backgroundNotices    = make(chan string, 16) // Avisos de tareas en segundo plano
+
+	embeddingModelName = defaultEmbeddingModel // Modelo dedicado para embeddings (configurable en config.json)
 
 	// Historial de Chat y Semántico
 	chatHistory []api.Message
 	chatHistoryLock sync.Mutex // Mutex para proteger el chatHistory
 
-	semanticHistory     []SemanticHistoryEntry
-	semanticHistoryPath string
+	semanticHistory      []SemanticHistoryEntry
+	semanticHistoryIndex map[string]int // Comando -> posición en semanticHistory
+	semanticHistoryPath  string
+	semanticIndex        *hnswIndex // Índice ANN sobre semanticHistory
+	semanticIndexPath    string
+	semanticQueue        *embeddingQueue // Worker que vectoriza los comandos exitosos
 	semanticHistoryLock sync.Mutex // Mutex para proteger el acceso al historial
 
 	// Variables de Ruta Globales (Corregidas)
@@ -73,8 +81,12 @@ var (
 
 // Struct para Historial Semántico
 type SemanticHistoryEntry struct {
-	Command   string    `json:"command"`
-	Embedding []float64 `json:"embedding"`
+	Command   string
+	Embedding []float32
+	Model     string // Modelo de embeddings que generó el vector (la dimensión es len(Embedding))
+	Project   string // Raíz del proyecto donde se ejecutó ("" = global)
+	Request   string // Petición en lenguaje natural que lo generó (sólo comandos sugeridos por la IA)
+	Timestamp int64  // Unix, momento en que se añadió
 }
 
 // Structs para APIs
@@ -190,8 +202,13 @@ func printHelp() {
 	fmt.Println()
 	fmt.Println(cSystem("--- Ayuda: Comandos Disponibles ---"))
 	fmt.Println(cPrompt("  /<petición> ") + cIA("- Pide un comando de shell (ej. /listar archivos .go)"))
-	fmt.Println(cPrompt("  /buscar <intención> ") + cIA("- Busca en tu historial por significado (ej. /buscar reiniciar servidor)"))
+	fmt.Println(cPrompt("  /buscar [--global] <intención> ") + cIA("- Busca en tu historial por significado, priorizando el proyecto actual (ej. /buscar reiniciar servidor)"))
+	fmt.Println(cPrompt("  /importar historial <shell> ") + cIA("- Importa tu historial de bash, zsh, fish o atuin a /buscar"))
+	fmt.Println(cPrompt("  /reindexar [--todo] ") + cIA("- Revectoriza el historial semántico con el modelo de embeddings actual"))
+	fmt.Println(cPrompt("  /deshacer [lista] ") + cIA("- Revierte los cambios en archivos del último comando ejecutado por la IA"))
+	fmt.Println(cPrompt("  /auditoria [filtros|verificar] ") + cIA("- Muestra el registro de comandos ejecutados por la IA"))
 	fmt.Println(cPrompt("  /chat <pregunta> ") + cIA("- Inicia una conversación de chat (ej. /chat ¿qué es Docker?)"))
+	fmt.Println(cPrompt("  /mcp         ") + cIA("- Muestra los servidores MCP y sus herramientas (disponibles en /chat)."))
 	fmt.Println(cPrompt("  /reset       ") + cIA("- Limpia el historial de la conversación de /chat."))
 	fmt.Println(cPrompt("  /tiempo <lugar>  ") + cIA("- Consulta el tiempo (sin API key) (ej. /tiempo Madrid)"))
 	fmt.Println(cPrompt("  /traducir <idioma> <texto> ") + cIA("- Traduce un texto (ej. /traducir fr hola)"))
@@ -199,8 +216,14 @@ func printHelp() {
 	fmt.Println(cPrompt("  /model       ") + cIA("- Acceso directo: Muestra el selector de modelos."))
 	fmt.Println(cPrompt("  /ask         ") + cIA("- Acceso directo: Desactiva el modo 'auto'."))
 	fmt.Println(cPrompt("  /help        ") + cIA("- Muestra este menú de ayuda."))
-	fmt.Println(cPrompt("  cd <dir>     ") + cIA("- Cambia el directorio actual (comando interno)."))
+	fmt.Println(cPrompt("  cd [dir|-]   ") + cIA("- Cambia el directorio actual (comando interno, con ~ y CDPATH)."))
+	fmt.Println(cPrompt("  pushd / popd / dirs ") + cIA("- Pila de directorios, como en bash."))
+	fmt.Println(cPrompt("  z <fragmento> ") + cIA("- Salta al directorio visitado más frecuente y reciente que coincida (z -l lista)."))
+	fmt.Println(cPrompt("  <comando> &  ") + cIA("- Lanza el comando en segundo plano (Ctrl+Z detiene el comando en curso)."))
+	fmt.Println(cPrompt("  jobs / fg / bg [%n] / kill %n ") + cIA("- Control de trabajos, como en bash."))
 	fmt.Println(cPrompt("  exit / quit  ") + cIA("- Cierra la terminal de IA (también Ctrl+D)."))
+	printMacroHelp()
+	printPluginHelp()
 	fmt.Println(cSystem("------------------------------------"))
 	fmt.Println()
 }
@@ -408,6 +431,27 @@ func saveHistory(state *liner.State) {
 	}
 }
 
+// notifyBackground deja un aviso para mostrarlo antes del siguiente prompt, sin
+// interrumpir lo que el usuario esté escribiendo. Si la cola está llena, se descarta.
+func notifyBackground(msg string) {
+	select {
+	case backgroundNotices <- msg:
+	default:
+	}
+}
+
+// printBackgroundNotices muestra los avisos pendientes de tareas en segundo plano.
+func printBackgroundNotices() {
+	for {
+		select {
+		case msg := <-backgroundNotices:
+			fmt.Println(msg)
+		default:
+			return
+		}
+	}
+}
+
 // checkVersion consulta la última versión en GitHub y la almacena.
 func checkVersion() {
 	client := &http.Client{Timeout: 3 * time.Second}
@@ -451,6 +495,28 @@ func checkVersion() {
 
 // --- main ---
 func main() {
+	loadConfig()
+	loadPolicy()
+
+	// Subcomandos no interactivos. Los de la integración con la shell se lanzan a menudo
+	// (record tras cada comando), así que no cargan nada más
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "serve":
+			os.Exit(runServe(os.Args[2:]))
+		case "init":
+			os.Exit(runInit(os.Args[2:]))
+		case "suggest":
+			os.Exit(runSuggest(os.Args[2:]))
+		case "record":
+			os.Exit(runRecord(os.Args[2:]))
+		}
+	}
+
+	// Los plugins y los servidores MCP sólo se usan en el modo interactivo
+	loadPlugins()
+	startMCPServers()
+
 	loadLogos()
 	createColorMap()
 	clearScreen()
@@ -469,27 +535,27 @@ func main() {
 	}
 	// --- Fin Comprobación ---
 
+	// Registrado el primero para ejecutarse el último, tras guardar el historial y
+	// restaurar la terminal: tras un SIGTERM se sale con 143, como bash
+	defer func() {
+		if terminationRequested() {
+			os.Exit(128 + int(syscall.SIGTERM))
+		}
+	}()
+
 	state := liner.NewLiner()
 	defer state.Close()
 	state.SetCtrlCAborts(true)
 
 	// --- LÓGICA DE AUTO-COMPLETADO ---
 	state.SetCompleter(func(line string) (c []string) {
-		commands := []string{
-			"/help",
-			"/chat ",
-			"/buscar ",
-			"/reset",
-			"/tiempo ",
-			"/traducir ",
-			"/model",
-			"/ask",
+		commands := append(append(slashCompletions(), pluginCompletions()...),
 			"exit",
 			"quit",
-			"cd ", "ls ", "cat ", "rm ", "mv ", "cp ", "mkdir ", "rmdir ",
+			"cd ", "pushd ", "popd", "dirs", "z ", "jobs", "fg ", "bg ", "kill %", "ls ", "cat ", "rm ", "mv ", "cp ", "mkdir ", "rmdir ",
 			"grep ", "find ", "chmod ", "chown ", "touch ", "nano ", "vim ",
 			"less ", "go ", "git ", "docker ",
-		}
+		)
 
 		for _, cmd := range commands {
 			if strings.HasPrefix(cmd, line) {
@@ -500,6 +566,7 @@ func main() {
 		// NO autocompletar rutas para estos comandos
 		if strings.HasPrefix(line, "/chat ") ||
 			strings.HasPrefix(line, "/buscar ") ||
+			strings.HasPrefix(line, "/importar ") ||
 			strings.HasPrefix(line, "/tiempo ") ||
 			strings.HasPrefix(line, "/traducir ") {
 				return c
@@ -515,6 +582,11 @@ func main() {
 				pathPrefix = line[:lastSpace+1]
 				partToComplete = line[lastSpace+1:]
 			}
+			// "@archivo" en las macros de chat
+			if strings.HasPrefix(partToComplete, "@") {
+				pathPrefix += "@"
+				partToComplete = partToComplete[1:]
+			}
 
 			globPattern := partToComplete + "*"
 			if strings.HasPrefix(globPattern, "~/") {
@@ -541,6 +613,7 @@ func main() {
 		// Inicializar rutas globales
 		historyPath = filepath.Join(home, historyFileName) // ¡CORREGIDO: Asignación!
 		semanticHistoryPath = filepath.Join(home, embeddingHistoryFile)
+		semanticIndexPath = filepath.Join(home, embeddingIndexFile)
 		chatHistoryPath = filepath.Join(home, chatHistoryFile) // ¡CORREGIDO: Asignación!
 
 		// Cargar historial de liner
@@ -548,9 +621,19 @@ func main() {
 			state.ReadHistory(f)
 			f.Close()
 		}
-		// Cargar historial semántico
-		loadSemanticHistory()
+		// Cargar historial semántico. Sólo lo modifica el proceso que lo posee; si ya hay
+		// otra terminal-ia abierta, los comandos nuevos van a comandos-shell.jsonl
+		if acquireSemanticOwnership() {
+			loadSemanticHistory()
+		} else {
+			loadSemanticHistoryReadOnly()
+			fmt.Println(cSystem("Otro proceso de terminal-ia usa el historial semántico: los comandos nuevos se guardarán a través de él."))
+		}
 		fmt.Printf(cSystem("Cargados %d comandos del historial semántico.\n"), len(semanticHistory))
+		if stale := countStaleSemanticEntries(); stale > 0 {
+			fmt.Println(cError(fmt.Sprintf("Aviso: %d comandos del historial semántico se vectorizaron con otro modelo de embeddings (actual: %s).", stale, embeddingModelName)))
+			fmt.Println(cSystem("  /buscar los ignorará hasta que ejecutes /reindexar."))
+		}
 
 		// Cargar historial de chat
 		loadChatHistory()
@@ -561,6 +644,8 @@ func main() {
 	}
 	defer saveHistory(state)
 
+	semanticQueue = newEmbeddingQueue(client)
+
 	selectedModel := chooseModel(client, state)
 
 	clearScreen()
@@ -575,13 +660,34 @@ func main() {
 
 	var alwaysExecute bool = false
 	var isFirstLoop bool = true
+	var lastProject string = "\x00" // Fuerza la carga de comandos compartidos en la primera vuelta
 
 	for {
+		if terminationRequested() {
+			break // SIGTERM durante un comando: salir guardando el estado
+		}
 		select {
 			case updateMsg := <-updateMessageChannel:
 				fmt.Print(updateMsg)
 			default:
 		}
+		printBackgroundNotices()
+		drainShellSpool()
+		for _, failed := range takeFailedJobs() {
+			failure, ok := newFailureContext(failed.Command, failed.err, "", failed.stderr.String())
+			if !ok || !confirmFailureAnalysis(state, failure) {
+				continue
+			}
+			fmt.Println(cSystem(fmt.Sprintf("--- Análisis de Error del Trabajo [%d]: %s ---", failed.ID, failed.Command)))
+			handleDebugCommand(client, state, selectedModel, failure)
+			fmt.Println()
+		}
+
+		// Al entrar en un proyecto, incorporar sus comandos compartidos (.terminal-ia/comandos)
+		if project := currentProject(); project != lastProject {
+			lastProject = project
+			enqueueSharedProjectCommands(state, project)
+		}
 
 		if isFirstLoop {
 			isFirstLoop = false
@@ -631,23 +737,29 @@ func main() {
 			continue
 		}
 
+		// Alias y macros de usuario (config.json)
+		expanded, err := expandUserInput(input)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, cError(err.Error()))
+			fmt.Println()
+			continue
+		}
+		input = expanded
+
 		if input == "exit" || input == "quit" {
+			if !confirmExitWithJobs() {
+				continue
+			}
 			break
 		}
 
-		if strings.HasPrefix(input, "cd ") {
-			dir := strings.TrimSpace(strings.TrimPrefix(input, "cd "))
-			if dir == "" || dir == "~" {
-				home, err := os.UserHomeDir()
-				if err != nil {
-					fmt.Fprintln(os.Stderr, cError(fmt.Sprintf("Error al encontrar el home dir: %v", err)))
-					continue
-				}
-				dir = home
-			}
-			if err := os.Chdir(dir); err != nil {
-				fmt.Fprintln(os.Stderr, cError(err.Error()))
-			}
+		if isDirBuiltin(input) {
+			// cd, pushd, popd, dirs y z cambian el directorio de terminal-ia
+			handleDirBuiltin(input)
+			continue
+
+		} else if isJobBuiltin(input) {
+			handleJobBuiltin(input)
 			continue
 
 		} else if input == "/model" {
@@ -731,7 +843,7 @@ func main() {
 				fmt.Println()
 				continue
 			}
-			handleChatCommand(client, selectedModel, prompt)
+			handleChatCommand(client, state, selectedModel, prompt)
 
 		} else if strings.HasPrefix(input, "/buscar ") {
 			query := strings.TrimPrefix(input, "/buscar ")
@@ -746,6 +858,26 @@ func main() {
 				alwaysExecute = true
 			}
 
+		} else if input == "/reindexar" || strings.HasPrefix(input, "/reindexar ") {
+			handleReindexCommand(client, strings.TrimSpace(strings.TrimPrefix(input, "/reindexar")))
+
+		} else if strings.HasPrefix(input, "/importar ") {
+			handleImportCommand(client, strings.TrimPrefix(input, "/importar "))
+
+		} else if input == "/mcp" {
+			handleMCPCommand()
+
+		} else if input == "/auditoria" || strings.HasPrefix(input, "/auditoria ") {
+			handleAuditCommand(strings.TrimSpace(strings.TrimPrefix(input, "/auditoria")))
+
+		} else if input == "/deshacer" || strings.HasPrefix(input, "/deshacer ") {
+			handleUndoCommand(state, strings.TrimSpace(strings.TrimPrefix(input, "/deshacer")))
+
+		} else if p, args, ok := lookupPlugin(input); ok {
+			if handlePluginCommand(client, state, selectedModel, p, args, alwaysExecute) {
+				alwaysExecute = true
+			}
+
 		} else if strings.HasPrefix(input, "/") {
 			prompt := strings.TrimPrefix(input, "/")
 			prompt = strings.TrimSpace(prompt)
@@ -755,7 +887,7 @@ func main() {
 				continue
 			}
 			if alwaysExecute {
-				handleIACommandAuto(client, selectedModel, prompt)
+				handleIACommandAuto(client, state, selectedModel, prompt)
 			} else {
 				if handleIACommandConfirm(client, state, selectedModel, prompt) {
 					alwaysExecute = true
@@ -763,6 +895,13 @@ func main() {
 			}
 
 		} else {
+			// "comando &": trabajo en segundo plano (jobs, fg, bg, kill %n)
+			if command, ok := backgroundCommand(input); ok {
+				startBackgroundJob(command)
+				fmt.Println()
+				continue
+			}
+
 			// --- INICIO DE DEPURACIÓN INTELIGENTE DE ERRORES ---
 			finalInput := input
 			if shouldColorOutput(input) {
@@ -776,24 +915,22 @@ func main() {
 				}
 			}
 
-			cmd := exec.Command("bash", "-c", finalInput)
-			var stdoutBuf, stderrBuf bytes.Buffer
-			cmd.Stdout = io.MultiWriter(os.Stdout, &stdoutBuf)
-			cmd.Stderr = io.MultiWriter(os.Stderr, &stderrBuf)
-
+			stdoutTail := &tailBuffer{max: failureTailBytes}
+			stderrTail := &tailBuffer{max: failureTailBytes}
 			fmt.Println()
-			err := cmd.Run()
+			err := runShellCommand(finalInput, io.MultiWriter(os.Stdout, stdoutTail), io.MultiWriter(os.Stderr, stderrTail))
 
 			// Guardar en historial semántico
 			if err == nil {
 				// Solo guardar si el comando fue exitoso
-				go addCommandToSemanticHistory(client, selectedModel, finalInput)
-			} else {
-				// El comando falló, analizar el error
-				errorOutput := stderrBuf.String()
+				semanticQueue.Enqueue(queuedCommand{Command: finalInput, Project: currentProject()})
+			} else if failure, ok := newFailureContext(input, err, stdoutTail.String(), stderrTail.String()); ok {
+				// El comando falló de verdad (no es grep sin resultados, Ctrl+C...): analizarlo
 				fmt.Println()
-				fmt.Println(cSystem("--- Análisis de Error de Shell ---"))
-				handleDebugCommand(client, selectedModel, errorOutput)
+				if confirmFailureAnalysis(state, failure) {
+					fmt.Println(cSystem("--- Análisis de Error de Shell ---"))
+					handleDebugCommand(client, state, selectedModel, failure)
+				}
 			}
 
 			fmt.Println()
@@ -801,7 +938,16 @@ func main() {
 		}
 	}
 
+	shutdown()
+}
+
+// shutdown guarda el estado persistente antes de salir (fin del bucle o SIGTERM).
+func shutdown() {
+	hangupJobs()
+	closeMCPServers()
 	saveChatHistory()
+	semanticQueue.Close(10 * time.Second)
+	saveSemanticIndex()
 	fmt.Println(cSystem("\n¡Adiós!"))
 }
 
@@ -827,6 +973,15 @@ func formatCommandForDisplay(cmd string) string {
 					return strings.TrimSpace(formatted)
 }
 
+// renderProgressBar dibuja una barra de progreso de una línea (ej. [#####-----] 50/100 50%).
+func renderProgressBar(done int, total int, width int) string {
+	if total <= 0 {
+		total = 1
+	}
+	filled := done * width / total
+	bar := strings.Repeat("#", filled) + strings.Repeat("-", width-filled)
+	return cSystem(fmt.Sprintf("[%s] %d/%d %3d%%", bar, done, total, done*100/total))
+}
 
 // sanitizeIACommand limpia y prepara el comando sugerido por la IA para su ejecución.
 func sanitizeIACommand(rawCmd string) string {
@@ -948,13 +1103,14 @@ func handleWeatherCommand(client *api.Client, modelName string, location string)
 }
 
 // handleChatCommand
-func handleChatCommand(client *api.Client, modelName string, userPrompt string) {
+func handleChatCommand(client *api.Client, state *liner.State, modelName string, userPrompt string) {
 	if len(chatHistory) == 0 {
 		chatHistory = append(chatHistory, api.Message{
 			Role:    "system",
-			Content: "Eres un asistente de IA para terminal. Sé directo, conciso y técnico. Céntrate en la solicitud. Evita saludos largos o florituras innecesarias.",
+			Content: chatSystemPrompt,
 		})
 	}
+	historyLen := len(chatHistory)
 	chatHistory = append(chatHistory, api.Message{
 		Role:    "user",
 		Content: userPrompt,
@@ -969,40 +1125,75 @@ func handleChatCommand(client *api.Client, modelName string, userPrompt string)
 	defer signal.Stop(sigChan)
 	fmt.Println(cIA("IA> Pensando...") + cSystem(" (Presiona Ctrl+C para cancelar)"))
 	stream := true
-	req := &api.ChatRequest{
-		Model:    modelName,
-		Messages: chatHistory,
-		Stream:   &stream,
-	}
-	firstChunk := true
-	var fullResponse strings.Builder
-	streamHandler := func(r api.ChatResponse) error {
-		if firstChunk {
-			fmt.Print("\r" + cIA("IA: ") + "    \r")
-			firstChunk = false
+	tools := chatTools(modelName)
+
+	// Cada ronda es una respuesta del modelo; si pide herramientas se ejecutan (con
+	// aprobación) y se le devuelven los resultados en la siguiente
+	for round := 0; ; round++ {
+		req := &api.ChatRequest{
+			Model:    modelName,
+			Messages: chatHistory,
+			Stream:   &stream,
+			Tools:    tools,
 		}
-		fmt.Print(r.Message.Content)
-		fullResponse.WriteString(r.Message.Content)
-		return nil
-	}
-	err := client.Chat(ctx, req, streamHandler)
-	if err != nil {
-		if err == context.Canceled {
-			fmt.Print(cError("\n[Stream cancelado]"))
-			if len(chatHistory) > 0 {
-				chatHistory = chatHistory[:len(chatHistory)-1]
+		firstChunk := true
+		var fullResponse strings.Builder
+		var toolCalls []api.ToolCall
+		streamHandler := func(r api.ChatResponse) error {
+			if firstChunk {
+				fmt.Print("\r" + cIA("IA: ") + "    \r")
+				firstChunk = false
 			}
-		} else {
-			fmt.Println(cError(fmt.Sprintf("\nError al generar respuesta de chat: %v", err)))
-			if len(chatHistory) > 0 {
-				chatHistory = chatHistory[:len(chatHistory)-1]
+			fmt.Print(r.Message.Content)
+			fullResponse.WriteString(r.Message.Content)
+			toolCalls = append(toolCalls, r.Message.ToolCalls...)
+			return nil
+		}
+		err := client.Chat(ctx, req, streamHandler)
+		if err != nil && tools != nil && strings.Contains(err.Error(), "does not support tools") {
+			// Modelo sin tool calling: seguir sin herramientas
+			chatNoToolsModel = modelName
+			tools = nil
+			fmt.Println(cSystem(fmt.Sprintf("IA> El modelo %s no admite herramientas; responderá sin consultar el proyecto.", modelName)))
+			err = client.Chat(ctx, &api.ChatRequest{Model: modelName, Messages: chatHistory, Stream: &stream}, streamHandler)
+		}
+		if err != nil {
+			if err == context.Canceled {
+				fmt.Print(cError("\n[Stream cancelado]"))
+			} else {
+				fmt.Println(cError(fmt.Sprintf("\nError al generar respuesta de chat: %v", err)))
 			}
+			chatHistory = chatHistory[:historyLen]
+			break
 		}
-	} else {
 		chatHistory = append(chatHistory, api.Message{
-			Role:    "assistant",
-			Content: fullResponse.String(),
+			Role:      "assistant",
+			Content:   fullResponse.String(),
+			ToolCalls: toolCalls,
 		})
+		if len(toolCalls) == 0 {
+			break
+		}
+		if round >= chatMaxToolRounds {
+			fmt.Println(cError(fmt.Sprintf("\nIA> Demasiadas llamadas a herramientas seguidas (%d rondas); se detiene aquí.", chatMaxToolRounds)))
+			break
+		}
+
+		fmt.Println()
+		for _, call := range toolCalls {
+			result := runChatToolCall(ctx, state, modelName, userPrompt, call)
+			chatHistory = append(chatHistory, api.Message{
+				Role:     "tool",
+				Content:  result,
+				ToolName: call.Function.Name,
+			})
+		}
+		if ctx.Err() != nil {
+			fmt.Print(cError("\n[Stream cancelado]"))
+			chatHistory = chatHistory[:historyLen]
+			break
+		}
+		fmt.Println(cIA("IA> Pensando...") + cSystem(" (Presiona Ctrl+C para cancelar)"))
 	}
 	fmt.Println()
 }
@@ -1018,7 +1209,7 @@ func handleTranslateCommand(client *api.Client, modelName string, userPrompt str
 	}
 	targetLang := parts[0]
 	textToTranslate := parts[1]
-	systemPrompt := fmt.Sprintf("Eres un traductor experto. Traduce el texto del usuario al idioma '%s'. Responde ÚNICAMENTE con la traducción, sin explicaciones ni frases introductorias.", targetLang)
+	systemPrompt := translationSystemPrompt(targetLang)
 	fullPrompt := textToTranslate
 	fmt.Println(cIA("IA> Traduciendo..."))
 	ctx, cancel := context.WithCancel(context.Background())
@@ -1056,8 +1247,26 @@ func handleTranslateCommand(client *api.Client, modelName string, userPrompt str
 	fmt.Println()
 }
 
-// handleIACommandAuto
-func handleIACommandAuto(client *api.Client, modelName string, userPrompt string) {
+// translationSystemPrompt es el prompt de sistema de /traducir.
+func translationSystemPrompt(targetLang string) string {
+	return fmt.Sprintf("Eres un traductor experto. Traduce el texto del usuario al idioma '%s'. Responde ÚNICAMENTE con la traducción, sin explicaciones ni frases introductorias.", targetLang)
+}
+
+// commandSuggestion es un comando generado por la IA junto con los avisos detectados
+// al revisarlo antes de mostrarlo al usuario.
+type commandSuggestion struct {
+	Command  string
+	Request  string // Petición en lenguaje natural que lo originó
+	Model    string
+	Warnings []string // Avisos informativos (p.ej. flags no documentados)
+	Problems []string // Errores de validación: el comando probablemente no funcionará
+	NoAuto   bool     // No se ofrece 'x': confirmar (p.ej. una corrección) no activa el modo auto
+}
+
+// generateShellCommand pide al modelo un único comando de shell para la petición del
+// usuario, con el contexto de archivos del CWD, ejemplos de su propio historial y
+// extractos de la documentación local de las herramientas implicadas.
+func generateShellCommand(client *api.Client, modelName string, userPrompt string) (commandSuggestion, error) {
 	// 1. Obtener contexto de archivos
 	dirSnippet := getDirectorySnippet()
 	contextLine := ""
@@ -1065,81 +1274,180 @@ func handleIACommandAuto(client *api.Client, modelName string, userPrompt string
 		contextLine = fmt.Sprintf("Contexto de archivos en CWD: %s.", dirSnippet)
 	}
 
-	// 2. Definir System Prompt
-	systemPrompt := fmt.Sprintf(`Eres un experto en terminal de Linux y shell.
+	// 2. Ejemplos (few-shot) de comandos que ya funcionaron para peticiones parecidas
+	examples := buildFewShotExamples(client, userPrompt)
+
+	generate := func(docSnippets string, feedback string) (string, error) {
+		// 3. Definir System Prompt
+		systemPrompt := fmt.Sprintf(`Eres un experto en terminal de Linux y shell.
 	Traduce la siguiente petición de lenguaje natural a un ÚNICO comando de shell.
 	%s
+	%s
+	%s
+	%s
 	Responde SÓLO con el comando y nada más. No uses markdown, ni explicaciones.
-	Petición: `, contextLine)
+	Petición: `, contextLine, examples, docSnippets, feedback)
+
+		// 4. Crear Full Prompt
+		fullPrompt := systemPrompt + userPrompt
+		req := &api.GenerateRequest{
+			Model:  modelName,
+			Prompt: fullPrompt,
+			Stream: new(bool),
+		}
+		ctx := context.Background()
+		var resp api.GenerateResponse
+		responseHandler := func(r api.GenerateResponse) error {
+			resp = r
+			return nil
+		}
+		if err := client.Generate(ctx, req, responseHandler); err != nil {
+			return "", err
+		}
+		return sanitizeIACommand(resp.Response), nil
+	}
+
+	// 5. Documentación local: de las herramientas nombradas en la petición o, si no
+	// nombra ninguna, de las que use un primer borrador del comando.
+	var command, snippets string
+	docs := map[string]*toolDoc{}
+	if docGroundingEnabled() {
+		tools := toolsMentionedIn(userPrompt)
+		if len(tools) == 0 {
+			var err error
+			if command, err = generate("", ""); err != nil {
+				return commandSuggestion{}, err
+			}
+			tools = commandBinaries(command)
+		}
+		docs = loadToolDocs(client, tools)
+		snippets = relevantDocSnippets(client, userPrompt, docs)
+		if snippets != "" {
+			fmt.Println(cSystem(fmt.Sprintf("IA> Consultando la documentación local de: %s", strings.Join(sortedDocTools(docs), ", "))))
+			command = "" // El borrador se regenera con la documentación
+		}
+	}
+	if command == "" {
+		var err error
+		if command, err = generate(snippets, ""); err != nil {
+			return commandSuggestion{}, err
+		}
+	}
 
-	// 3. Crear Full Prompt
-	fullPrompt := systemPrompt + userPrompt
-	fmt.Println(cIA("IA> Procesando (auto)..."))
+	// 6. Validación estática; si hay problemas se pide una corrección al modelo
+	problems := validateShellCommand(command)
+	for retry := 0; retry < validationMaxRetries && len(problems) > 0; retry++ {
+		fmt.Println(cSystem("IA> El comando sugerido tiene problemas, regenerando..."))
+		feedback := fmt.Sprintf("Tu respuesta anterior fue:\n%s\nTenía estos problemas: %s\nCorrígelos.", command, strings.Join(problems, " "))
+		fixed, err := generate(snippets, feedback)
+		if err != nil {
+			break // Nos quedamos con el primero y sus problemas
+		}
+		fixedProblems := validateShellCommand(fixed)
+		if len(fixedProblems) < len(problems) {
+			command, problems = fixed, fixedProblems
+		}
+	}
+	return commandSuggestion{
+		Command:  command,
+		Request:  userPrompt,
+		Model:    modelName,
+		Warnings: checkCommandFlags(command, docs),
+		Problems: problems,
+	}, nil
+}
 
-	req := &api.GenerateRequest{
-		Model:  modelName,
-		Prompt: fullPrompt,
-		Stream: new(bool),
+// printSuggestionWarnings muestra los avisos de un comando sugerido.
+func printSuggestionWarnings(suggestion commandSuggestion) {
+	for _, warning := range suggestion.Warnings {
+		fmt.Println(cError("Aviso: " + warning))
 	}
-	ctx := context.Background()
-	var resp api.GenerateResponse
-	responseHandler := func(r api.GenerateResponse) error {
-		resp = r
-		return nil
+}
+
+// runSuggestedCommand ejecuta un comando sugerido por la IA (registrándolo en la
+// auditoría con el modo indicado) y, si termina bien, lo guarda en el historial
+// semántico junto con la petición que lo originó.
+func runSuggestedCommand(suggestion commandSuggestion, mode string) {
+	if set := snapshotBeforeCommand(suggestion.Command); set != nil {
+		defer func() {
+			finishUndoSnapshot(set)
+			fmt.Println(cSystem("IA> Instantánea guardada: usa /deshacer para revertir los cambios."))
+		}()
+	}
+	if err := runAuditedCommand(suggestion.Command, suggestion.Request, suggestion.Model, mode); err != nil {
+		if err != errJobStopped {
+			fmt.Fprintln(os.Stderr, cError("IA> El comando falló."))
+		}
+		return
 	}
-	if err := client.Generate(ctx, req, responseHandler); err != nil {
+	semanticQueue.Enqueue(queuedCommand{Command: suggestion.Command, Project: currentProject(), Request: suggestion.Request})
+}
+
+// handleIACommandAuto
+func handleIACommandAuto(client *api.Client, state *liner.State, modelName string, userPrompt string) {
+	fmt.Println(cIA("IA> Procesando (auto)..."))
+	suggestion, err := generateShellCommand(client, modelName, userPrompt)
+	if err != nil {
 		fmt.Fprintln(os.Stderr, cError(fmt.Sprintf("Error al contactar con Ollama: %v", err)))
 		return
 	}
-	comandoSugerido := sanitizeIACommand(resp.Response)
+	comandoSugerido := suggestion.Command
+
+	// Las políticas pueden bloquear el comando o exigir confirmación pese al modo auto
+	switch decision := evaluatePolicy(comandoSugerido); decision.Action {
+	case policyDeny:
+		fmt.Println(comandoSugerido)
+		printPolicyDenied(decision)
+		return
+	case policyConfirm:
+		fmt.Println(cSystem(fmt.Sprintf("IA> La política exige confirmación para este comando (%s).", decision.describe())))
+		confirmSuggestion(state, suggestion)
+		return
+	}
 	fmt.Println()
 	fmt.Println(cSystem("ejecutando (auto):"))
 	fmt.Println(comandoSugerido)
-	fmt.Println()
-	cmd := exec.Command("bash", "-c", comandoSugerido)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	if err := cmd.Run(); err != nil {
-		fmt.Fprintln(os.Stderr, cError("IA> El comando falló."))
+	printSuggestionWarnings(suggestion)
+	if len(suggestion.Problems) > 0 {
+		printSuggestionProblems(suggestion)
+		fmt.Println(cError("IA> No se ejecuta automáticamente un comando con problemas. Usa '/ask' para revisarlo antes."))
+		fmt.Println()
+		return
 	}
 	fmt.Println()
+	runSuggestedCommand(suggestion, auditModeAuto)
+	fmt.Println()
 }
 
 // handleIACommandConfirm (Actualizado con formato de display)
 func handleIACommandConfirm(client *api.Client, state *liner.State, modelName string, userPrompt string) bool {
-	// 1. Obtener contexto de archivos
-	dirSnippet := getDirectorySnippet()
-	contextLine := ""
-	if dirSnippet != "" {
-		contextLine = fmt.Sprintf("Contexto de archivos en CWD: %s.", dirSnippet)
-	}
-
-	// 2. Definir System Prompt
-	systemPrompt := fmt.Sprintf(`Eres un experto en terminal de Linux y shell.
-	Traduce la siguiente petición de lenguaje natural a un ÚNICO comando de shell.
-	%s
-	Responde SÓLO con el comando y nada más. No uses markdown, ni explicaciones.
-	Petición: `, contextLine)
-
-	// 3. Crear Full Prompt
-	fullPrompt := systemPrompt + userPrompt
 	fmt.Println(cIA("IA> Procesando..."))
-	req := &api.GenerateRequest{
-		Model:  modelName,
-		Prompt: fullPrompt,
-		Stream: new(bool),
-	}
-	ctx := context.Background()
-	var resp api.GenerateResponse
-	responseHandler := func(r api.GenerateResponse) error {
-		resp = r
-		return nil
-	}
-	if err := client.Generate(ctx, req, responseHandler); err != nil {
+	suggestion, err := generateShellCommand(client, modelName, userPrompt)
+	if err != nil {
 		fmt.Fprintln(os.Stderr, cError(fmt.Sprintf("Error al contactar con Ollama: %v", err)))
 		return false
 	}
-	comandoSugerido := sanitizeIACommand(resp.Response)
+	comandoSugerido := suggestion.Command
+
+	// Una regla allow-auto permite ejecutar sin confirmación aunque no esté el modo auto
+	if decision := evaluatePolicy(comandoSugerido); decision.Action == policyAllowAuto && len(suggestion.Problems) == 0 {
+		fmt.Println()
+		fmt.Println(cSystem(fmt.Sprintf("ejecutando (política: %s):", decision.describe())))
+		fmt.Println(comandoSugerido)
+		printSuggestionWarnings(suggestion)
+		fmt.Println()
+		runSuggestedCommand(suggestion, auditModePolicy)
+		fmt.Println()
+		return false
+	}
+	return confirmSuggestion(state, suggestion)
+}
+
+// confirmSuggestion muestra el comando sugerido con sus avisos y vista previa, y pide
+// confirmación ("e" permite editarlo antes y vuelve a mostrarlo). Devuelve true si el
+// usuario activa el modo auto.
+func confirmSuggestion(state *liner.State, suggestion commandSuggestion) bool {
+	comandoSugerido := suggestion.Command
 
 	// --- Visualización Formateada ---
 	formattedCommand := formatCommandForDisplay(comandoSugerido)
@@ -1148,9 +1456,22 @@ func handleIACommandConfirm(client *api.Client, state *liner.State, modelName st
 		fmt.Println(cSystem("---"))
 		fmt.Println(cIA("IA> Comando sugerido:"))
 		fmt.Printf("\n%s\n\n", formattedCommand) // Mostrar versión legible
+		printSuggestionWarnings(suggestion)
+		printSuggestionProblems(suggestion)
+
+		// La política va antes de la vista previa: un comando denegado no se analiza
+		if decision := evaluatePolicy(comandoSugerido); decision.Action == policyDeny {
+			fmt.Println(cSystem("---"))
+			printPolicyDenied(decision)
+			return false
+		}
+		printCommandPreview(buildCommandPreview(comandoSugerido))
 		fmt.Println(cSystem("---"))
 
-		prompt := "IA> ¿Ejecutar? [s/N/x (Siempre)]: "
+		prompt := "IA> ¿Ejecutar? [s/N/e (Editar)/x (Siempre)/p (Probar)]: "
+		if suggestion.NoAuto {
+			prompt = "IA> ¿Ejecutar? [s/N/e (Editar)/p (Probar)]: "
+		}
 		confirmacion, err := state.Prompt(prompt)
 		if err != nil {
 			if err == io.EOF || err == liner.ErrPromptAborted {
@@ -1162,6 +1483,9 @@ func handleIACommandConfirm(client *api.Client, state *liner.State, modelName st
 		}
 		state.AppendHistory(confirmacion)
 		confirmacion = strings.TrimSpace(strings.ToLower(confirmacion))
+		if suggestion.NoAuto && confirmacion == "x" {
+			confirmacion = "" // No se ofreció: se trata como una respuesta no reconocida
+		}
 		switch confirmacion {
 			case "s":
 				fmt.Println(cSystem("IA> Ejecutando..."))
@@ -1169,29 +1493,55 @@ func handleIACommandConfirm(client *api.Client, state *liner.State, modelName st
 				fmt.Println(cSystem("ejecutando:"))
 				fmt.Println(comandoSugerido) // Ejecutar versión sin formatear
 				fmt.Println()
-				cmd := exec.Command("bash", "-c", comandoSugerido)
-				cmd.Stdout = os.Stdout
-				cmd.Stderr = os.Stderr
-				if err := cmd.Run(); err != nil {
-					fmt.Fprintln(os.Stderr, cError("IA> El comando falló."))
-				}
+				runSuggestedCommand(suggestion, auditModeConfirmed)
 				fmt.Println()
 				return false
+			case "e":
+				edited, err := state.PromptWithSuggestion("Editar> ", comandoSugerido, -1)
+				edited = strings.TrimSpace(edited)
+				if err != nil || edited == "" {
+					fmt.Println(cSystem("IA> Cancelado."))
+					fmt.Println()
+					return false
+				}
+				if edited != comandoSugerido {
+					// El comando editado se valida y se vuelve a confirmar como uno nuevo
+					suggestion.Command = edited
+					suggestion.Warnings = nil
+					suggestion.Problems = validateShellCommand(edited)
+				}
+				return confirmSuggestion(state, suggestion)
 			case "x":
 				fmt.Println(cSystem("IA> Ejecutando y activando modo 'auto'..."))
 				fmt.Println()
 				fmt.Println(cSystem("ejecutando:"))
 				fmt.Println(comandoSugerido) // Ejecutar versión sin formatear
 				fmt.Println()
-				cmd := exec.Command("bash", "-c", comandoSugerido)
-				cmd.Stdout = os.Stdout
-				cmd.Stderr = os.Stderr
-				if err := cmd.Run(); err != nil {
-					fmt.Fprintln(os.Stderr, cError("IA> El comando falló."))
-				}
+				runSuggestedCommand(suggestion, auditModeConfirmed)
 				fmt.Println()
 				fmt.Println(cSystem("IA> Modo auto-ejecución activado. Escribe '/ask' para desactivarlo."))
 				return true
+			case "p":
+				fmt.Println(cSystem("IA> Probando en sandbox...") + cSystem(" (Presiona Ctrl+C para cancelar)"))
+				result, err := runInSandbox(comandoSugerido)
+				if err != nil {
+					fmt.Println(cError(fmt.Sprintf("IA> No se pudo probar: %v", err)))
+				} else {
+					printSandboxResult(result)
+				}
+				answer, err := state.Prompt("IA> ¿Ejecutar de verdad? [s/N]: ")
+				if err != nil || strings.TrimSpace(strings.ToLower(answer)) != "s" {
+					fmt.Println(cSystem("IA> Cancelado."))
+					fmt.Println()
+					return false
+				}
+				fmt.Println()
+				fmt.Println(cSystem("ejecutando:"))
+				fmt.Println(comandoSugerido)
+				fmt.Println()
+				runSuggestedCommand(suggestion, auditModeConfirmed)
+				fmt.Println()
+				return false
 			default:
 				fmt.Println(cSystem("IA> Cancelado."))
 				fmt.Println()
@@ -1335,12 +1685,11 @@ func handleConfigCommand(client *api.Client, state *liner.State, currentModel st
 			case "4":
 				// Limpiar Historial Semántico
 				// (Limpiar el archivo y el slice en memoria)
-				semanticHistoryLock.Lock()
-				semanticHistory = make([]SemanticHistoryEntry, 0)
-				semanticHistoryLock.Unlock()
-
-				// Sobreescribir el archivo con un array vacío
-				os.WriteFile(semanticHistoryPath, []byte("[]"), 0644)
+				if err := clearSemanticHistory(); err != nil {
+					fmt.Println(cError(fmt.Sprintf("Error al limpiar historial semántico: %v", err)))
+					fmt.Println()
+					continue
+				}
 
 				fmt.Println(cIA("IA> Historial Semántico limpiado."))
 				fmt.Println()
@@ -1359,11 +1708,9 @@ func handleConfigCommand(client *api.Client, state *liner.State, currentModel st
 }
 
 // handleDebugCommand
-func handleDebugCommand(client *api.Client, modelName string, errorOutput string) {
-	if len(errorOutput) > 2048 {
-		errorOutput = errorOutput[:2048] + "\n... (Error truncado)"
-	}
-	fullPrompt := fmt.Sprintf("%s\n\nError de Stderr:\n```\n%s\n```", debugSystemPrompt, errorOutput)
+// handleDebugCommand analiza un fallo (explicación y corrección en una sola llamada) y
+// ofrece la corrección.
+func handleDebugCommand(client *api.Client, state *liner.State, modelName string, failure failureContext) {
 	fmt.Println(cIA("IA> Analizando error...") + cSystem(" (Presiona Ctrl+C para cancelar)"))
 	ctx, cancel := context.WithCancel(context.Background())
 	sigChan := make(chan os.Signal, 1)
@@ -1373,28 +1720,20 @@ func handleDebugCommand(client *api.Client, modelName string, errorOutput string
 		cancel()
 	}()
 	defer signal.Stop(sigChan)
-	stream := true
-	req := &api.GenerateRequest{
-		Model:  modelName,
-		Prompt: fullPrompt,
-		Stream: &stream,
-	}
-	firstChunk := true
-	streamHandler := func(r api.GenerateResponse) error {
-		if firstChunk {
-			fmt.Print("\r" + cIA("IA: ") + "    \r")
-			firstChunk = false
+	analysis, err := analyzeFailure(ctx, client, modelName, failure)
+	signal.Stop(sigChan)
+	if err != nil {
+		if ctx.Err() == context.Canceled {
+			fmt.Println(cError("[Análisis cancelado]"))
+		} else {
+			fmt.Println(cError(fmt.Sprintf("Error al generar análisis: %v", err)))
 		}
-		fmt.Print(r.Response)
-		return nil
+		return
 	}
-	err := client.Generate(ctx, req, streamHandler)
-	if err != nil && err != context.Canceled {
-		fmt.Println(cError(fmt.Sprintf("\nError al generar análisis: %v", err)))
-	} else if err == context.Canceled {
-		fmt.Print(cError("\n[Análisis cancelado]"))
+	if analysis.Analysis != "" {
+		fmt.Println(cIA("IA: ") + analysis.Analysis)
 	}
-	fmt.Println()
+	suggestFix(state, modelName, failure, analysis)
 }
 
 // shouldColorOutput
@@ -1417,23 +1756,23 @@ func shouldColorOutput(cmd string) bool {
 
 // --- Funciones Matemáticas para Similitud de Coseno ---
 
-func dotProduct(a, b []float64) float64 {
+func dotProduct(a, b []float32) float64 {
 	var sum float64
 	for i := 0; i < len(a); i++ {
-		sum += a[i] * b[i]
+		sum += float64(a[i]) * float64(b[i])
 	}
 	return sum
 }
 
-func magnitude(v []float64) float64 {
+func magnitude(v []float32) float64 {
 	var sumSq float64
 	for _, val := range v {
-		sumSq += val * val
+		sumSq += float64(val) * float64(val)
 	}
 	return math.Sqrt(sumSq)
 }
 
-func cosineSimilarity(a, b []float64) float64 {
+func cosineSimilarity(a, b []float32) float64 {
 	if len(a) != len(b) || len(a) == 0 {
 		return 0.0
 	}
@@ -1446,7 +1785,7 @@ func cosineSimilarity(a, b []float64) float64 {
 }
 
 // getEmbedding llama a la API de Ollama para un texto dado usando el modelo DEDICADO.
-func getEmbedding(client *api.Client, text string, model string) ([]float64, error) {
+func getEmbedding(client *api.Client, text string, model string) ([]float32, error) {
 	req := &api.EmbeddingRequest{
 		Model:  embeddingModelName, // <-- ¡USAR MODELO DEDICADO!
 		Prompt: text,
@@ -1456,7 +1795,27 @@ func getEmbedding(client *api.Client, text string, model string) ([]float64, err
 	if err != nil {
 		return nil, err
 	}
-	return resp.Embedding, nil
+	embedding := make([]float32, len(resp.Embedding))
+	for i, v := range resp.Embedding {
+		embedding[i] = float32(v)
+	}
+	return embedding, nil
+}
+
+// getEmbeddings vectoriza varios textos en una sola llamada al endpoint /api/embed.
+func getEmbeddings(ctx context.Context, client *api.Client, texts []string) ([][]float32, error) {
+	req := &api.EmbedRequest{
+		Model: embeddingModelName,
+		Input: texts,
+	}
+	resp, err := client.Embed(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Embeddings) != len(texts) {
+		return nil, fmt.Errorf("se esperaban %d embeddings y se recibieron %d", len(texts), len(resp.Embeddings))
+	}
+	return resp.Embeddings, nil
 }
 
 // --- Funciones de Persistencia del Historial de Chat ---
@@ -1510,84 +1869,25 @@ func saveChatHistory() {
 	}
 }
 
-// loadSemanticHistory carga los embeddings desde el archivo JSON
-func loadSemanticHistory() {
-	semanticHistoryLock.Lock()
-	defer semanticHistoryLock.Unlock()
-
-	if _, err := os.Stat(semanticHistoryPath); os.IsNotExist(err) {
-		semanticHistory = make([]SemanticHistoryEntry, 0)
-		return
-	}
-
-	data, err := os.ReadFile(semanticHistoryPath)
-	if err != nil {
-		fmt.Fprintln(os.Stderr, cError(fmt.Sprintf("Error al leer historial semántico: %v", err)))
-		semanticHistory = make([]SemanticHistoryEntry, 0)
-		return
-	}
-
-	if err := json.Unmarshal(data, &semanticHistory); err != nil {
-		fmt.Fprintln(os.Stderr, cError(fmt.Sprintf("Error al parsear historial semántico (se reiniciará): %v", err)))
-		semanticHistory = make([]SemanticHistoryEntry, 0)
-	}
+// isIndexableCommand indica si un comando merece entrar en el historial semántico.
+func isIndexableCommand(command string) bool {
+	return command != "" && !strings.HasPrefix(command, "/") && !strings.HasPrefix(command, "cd ")
 }
 
-// saveSemanticHistory guarda el historial actual en el archivo JSON
-func saveSemanticHistory() {
-	semanticHistoryLock.Lock()
-	defer semanticHistoryLock.Unlock()
-
-	data, err := json.Marshal(semanticHistory)
-	if err != nil {
-		fmt.Fprintln(os.Stderr, cError(fmt.Sprintf("Error al serializar historial semántico: %v", err)))
-		return
-	}
-	if err := os.WriteFile(semanticHistoryPath, data, 0644); err != nil {
-		fmt.Fprintln(os.Stderr, cError(fmt.Sprintf("Error al guardar historial semántico: %v", err)))
-	}
-}
-
-// addCommandToSemanticHistory
-func addCommandToSemanticHistory(client *api.Client, model string, command string) {
-	// No guardar comandos vacíos, de historial, o el propio 'buscar'
-	if command == "" || strings.HasPrefix(command, "/") || strings.HasPrefix(command, "cd ") {
-		return
-	}
-
-	// Evitar duplicados exactos
-	semanticHistoryLock.Lock()
-	for _, entry := range semanticHistory {
-		if entry.Command == command {
-			semanticHistoryLock.Unlock()
-			return
+// handleSearchCommand (Muestra y permite seleccionar el Top 3)
+func handleSearchCommand(client *api.Client, state *liner.State, model string, query string) bool {
+	// "--global" desactiva la preferencia por los comandos del proyecto actual
+	global := false
+	if fields := strings.Fields(query); len(fields) > 0 && fields[0] == globalSearchFlag {
+		global = true
+		query = strings.TrimSpace(strings.TrimPrefix(query, globalSearchFlag))
+		if query == "" {
+			fmt.Println(cError("IA> Petición de búsqueda vacía. Escribe /buscar [--global] <intención>."))
+			fmt.Println()
+			return false
 		}
 	}
-	semanticHistoryLock.Unlock() // Desbloquear antes de la llamada de red
-
-	// 1. Generar el Embedding
-	embedding, err := getEmbedding(client, command, model)
-	if err != nil {
-		fmt.Fprintln(os.Stderr, cError(fmt.Sprintf("\n[Error de Embedding: %v]", err)))
-		return
-	}
-
-	// 2. Crear la entrada
-	entry := SemanticHistoryEntry{
-		Command:   command,
-		Embedding: embedding,
-	}
-
-	// 3. Añadir al historial y guardar (con Lock)
-	semanticHistoryLock.Lock()
-	semanticHistory = append(semanticHistory, entry)
-	semanticHistoryLock.Unlock()
-
-	saveSemanticHistory() // Guardar el archivo
-}
 
-// handleSearchCommand (Muestra y permite seleccionar el Top 3)
-func handleSearchCommand(client *api.Client, state *liner.State, model string, query string) bool {
 	fmt.Println(cIA("IA> Buscando en historial semántico...") + cSystem(" (Presiona Ctrl+C para cancelar)"))
 
 	if len(semanticHistory) == 0 {
@@ -1603,39 +1903,15 @@ func handleSearchCommand(client *api.Client, state *liner.State, model string, q
 		return false
 	}
 
-	// Estructura para almacenar los resultados del Top 3
-	type result struct {
-		Command string
-		Score   float64
-	}
-	// Inicializar Top 3 con un score muy bajo
-	topResults := make([]result, 3)
-	for i := range topResults {
-		topResults[i] = result{Score: -1.0}
-	}
-
-	// 2. Buscar por similitud y gestionar el Top 3
-	semanticHistoryLock.Lock()
-	for _, entry := range semanticHistory {
-		score := cosineSimilarity(queryEmbedding, entry.Embedding)
-
-		// Lógica simple de inserción ordenada para mantener el Top 3
-		for i := 0; i < 3; i++ {
-			if score > topResults[i].Score {
-				// Mover los elementos inferiores
-				for j := 2; j > i; j-- {
-					topResults[j] = topResults[j-1]
-				}
-				// Insertar el nuevo resultado
-				topResults[i] = result{Command: entry.Command, Score: score}
-				break
-			}
-		}
+	// 2. Buscar los 3 vecinos más cercanos en el índice ANN (priorizando el proyecto actual)
+	project := ""
+	if !global {
+		project = currentProject()
 	}
-	semanticHistoryLock.Unlock()
+	topResults := searchSemanticHistory(queryEmbedding, 3, project)
 
-	// 3. Filtrar resultados no válidos (score -1.0)
-	var validResults []result
+	// 3. Filtrar resultados poco relevantes
+	var validResults []semanticSearchResult
 	for _, res := range topResults {
 		if res.Score > 0.1 { // Un umbral mínimo para evitar comandos irrelevantes
 			validResults = append(validResults, res)
@@ -1655,7 +1931,7 @@ func handleSearchCommand(client *api.Client, state *liner.State, model string, q
 	for i, res := range validResults {
 		// --- APLICAR FORMATO DE DISPLAY AQUÍ ---
 		formattedCommand := formatCommandForDisplay(res.Command)
-			fmt.Printf(cPrompt("  [%d]: ")+"%s %s\n", i+1, formattedCommand, cSystem(fmt.Sprintf("(Similitud: %.2f%%)", res.Score*100)))
+			fmt.Printf(cPrompt("  [%d]: ")+"%s %s\n", i+1, formattedCommand, cSystem(fmt.Sprintf("(Similitud: %.2f%% · %s)", res.Score*100, projectDisplayName(res.Project))))
 	}
 	fmt.Println(cSystem("---"))
 
@@ -1695,7 +1971,13 @@ func handleSearchCommand(client *api.Client, state *liner.State, model string, q
 		fmt.Println(cError("Selección inválida. Introduce el número de la opción, 'N' o 'X'."))
 	}
 
-	// 6. Ejecutar el comando seleccionado
+	// 6. Ejecutar el comando seleccionado (la selección cuenta como confirmación, pero
+	// las reglas deny se respetan)
+	if decision := evaluatePolicy(selectedCommand); decision.Action == policyDeny {
+		fmt.Println(selectedCommand)
+		printPolicyDenied(decision)
+		return false
+	}
 	fmt.Println(cSystem("IA> Ejecutando..."))
 
 	// Aquí usamos el mismo switch de ejecución de handleIACommandConfirm
@@ -1708,10 +1990,7 @@ func handleSearchCommand(client *api.Client, state *liner.State, model string, q
 	fmt.Println(cSystem("ejecutando:"))
 	fmt.Println(selectedCommand) // Usamos el comando sin formato
 
-	cmd := exec.Command("bash", "-c", selectedCommand)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	if err := cmd.Run(); err != nil {
+	if err := runAuditedCommand(selectedCommand, query, "", auditModeSearch); err != nil && err != errJobStopped {
 		fmt.Fprintln(os.Stderr, cError("IA> El comando falló."))
 	}
 	fmt.Println()
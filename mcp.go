@@ -0,0 +1,705 @@
+// Copyright (c) 2025 Daniel Serrano Armenta. dani.eus79@gmail.com Todos los derechos reservados.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ollama/ollama/api"
+)
+
+// --- Cliente MCP (Model Context Protocol) ---
+//
+// Los servidores declarados en "mcp_servers" de config.json se conectan en segundo
+// plano al arrancar, por stdio (un proceso local que habla JSON-RPC por líneas) o por
+// HTTP "streamable" (POST con respuesta JSON o SSE). Sus herramientas se ofrecen al
+// modelo en /chat mediante el tool calling de Ollama con el nombre <servidor>__<tool>,
+// junto a las herramientas internas (chattools.go), y cada llamada se muestra y se
+// confirma antes de hacerse. Las llamadas aprobadas quedan en el registro de auditoría
+// con el modo "mcp".
+
+const (
+	mcpProtocolVersion = "2025-03-26"
+	mcpConnectTimeout  = 30 * time.Second
+	mcpCallTimeout     = 2 * time.Minute
+	mcpWaitOnChat      = 5 * time.Second // Espera máxima a servidores aún conectando
+	mcpToolSeparator   = "__"
+	mcpStderrMax       = 8 * 1024
+)
+
+// mcpServerConfig es un servidor tal y como aparece en config.json: "command" (stdio)
+// o "url" (HTTP).
+type mcpServerConfig struct {
+	Command  string            `json:"command,omitempty"`
+	Args     []string          `json:"args,omitempty"`
+	Env      map[string]string `json:"env,omitempty"`
+	URL      string            `json:"url,omitempty"`
+	Headers  map[string]string `json:"headers,omitempty"`
+	Disabled bool              `json:"disabled,omitempty"`
+}
+
+// jsonrpcMessage cubre peticiones, respuestas y notificaciones de JSON-RPC 2.0.
+type jsonrpcMessage struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *jsonrpcError   `json:"error,omitempty"`
+}
+
+type jsonrpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *jsonrpcError) Error() string { return fmt.Sprintf("%s (código %d)", e.Message, e.Code) }
+
+// mcpTransport envía peticiones JSON-RPC a un servidor.
+type mcpTransport interface {
+	call(ctx context.Context, method string, params any) (json.RawMessage, error)
+	notify(ctx context.Context, method string, params any) error
+	close()
+}
+
+// mcpTool es una herramienta publicada por un servidor.
+type mcpTool struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	InputSchema json.RawMessage `json:"inputSchema,omitempty"`
+}
+
+// mcpServer es un servidor configurado y su estado de conexión.
+type mcpServer struct {
+	Name   string
+	config mcpServerConfig
+
+	ready     chan struct{} // Se cierra al terminar de conectar (con o sin éxito)
+	transport mcpTransport
+	info      string
+	tools     []mcpTool
+	err       error
+}
+
+var mcpServers []*mcpServer
+
+// startMCPServers conecta en segundo plano con los servidores configurados.
+func startMCPServers() {
+	names := make([]string, 0, len(appConfig.MCPServers))
+	for name := range appConfig.MCPServers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		cfg := appConfig.MCPServers[name]
+		if cfg.Disabled {
+			continue
+		}
+		s := &mcpServer{Name: name, config: cfg, ready: make(chan struct{})}
+		mcpServers = append(mcpServers, s)
+		go func() {
+			defer close(s.ready)
+			if err := s.connect(); err != nil {
+				s.err = err
+				notifyBackground(cError(fmt.Sprintf("MCP: no se pudo conectar con \"%s\": %v", s.Name, err)))
+			}
+		}()
+	}
+}
+
+// connect abre el transporte, negocia el protocolo y lista las herramientas.
+func (s *mcpServer) connect() error {
+	ctx, cancel := context.WithTimeout(context.Background(), mcpConnectTimeout)
+	defer cancel()
+
+	var err error
+	switch {
+	case s.config.URL != "" && s.config.Command != "":
+		return fmt.Errorf("indica \"command\" o \"url\", no ambos")
+	case s.config.URL != "":
+		s.transport = newMCPHTTPTransport(s.config.URL, s.config.Headers)
+	case s.config.Command != "":
+		if s.transport, err = newMCPStdioTransport(s.config); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("falta \"command\" o \"url\"")
+	}
+
+	result, err := s.transport.call(ctx, "initialize", map[string]any{
+		"protocolVersion": mcpProtocolVersion,
+		"capabilities":    map[string]any{"roots": map[string]any{}},
+		"clientInfo":      map[string]any{"name": "terminal-ia", "version": currentVersion},
+	})
+	if err != nil {
+		s.transport.close()
+		return err
+	}
+	var init struct {
+		ProtocolVersion string `json:"protocolVersion"`
+		ServerInfo      struct {
+			Name    string `json:"name"`
+			Version string `json:"version"`
+		} `json:"serverInfo"`
+	}
+	json.Unmarshal(result, &init)
+	s.info = strings.TrimSpace(init.ServerInfo.Name + " " + init.ServerInfo.Version)
+	if t, ok := s.transport.(*mcpHTTPTransport); ok {
+		t.protocolVersion = init.ProtocolVersion
+	}
+	if err := s.transport.notify(ctx, "notifications/initialized", nil); err != nil {
+		s.transport.close()
+		return err
+	}
+
+	cursor := ""
+	for {
+		params := map[string]any{}
+		if cursor != "" {
+			params["cursor"] = cursor
+		}
+		result, err := s.transport.call(ctx, "tools/list", params)
+		if err != nil {
+			s.transport.close()
+			return fmt.Errorf("tools/list: %v", err)
+		}
+		var page struct {
+			Tools      []mcpTool `json:"tools"`
+			NextCursor string    `json:"nextCursor"`
+		}
+		if err := json.Unmarshal(result, &page); err != nil {
+			s.transport.close()
+			return fmt.Errorf("tools/list: %v", err)
+		}
+		s.tools = append(s.tools, page.Tools...)
+		if page.NextCursor == "" {
+			break
+		}
+		cursor = page.NextCursor
+	}
+	return nil
+}
+
+// connectedMCPServers devuelve los servidores conectados, esperando como mucho wait a
+// los que aún están conectando.
+func connectedMCPServers(wait time.Duration) []*mcpServer {
+	deadline := time.After(wait)
+	var connected []*mcpServer
+	for _, s := range mcpServers {
+		select {
+		case <-s.ready:
+		default:
+			select {
+			case <-s.ready:
+			case <-deadline:
+				continue
+			}
+		}
+		if s.err == nil {
+			connected = append(connected, s)
+		}
+	}
+	return connected
+}
+
+// mcpChatTools devuelve las herramientas MCP en el formato de Ollama.
+func mcpChatTools() api.Tools {
+	if len(mcpServers) == 0 {
+		return nil
+	}
+	var tools api.Tools
+	for _, s := range connectedMCPServers(mcpWaitOnChat) {
+		for _, t := range s.tools {
+			params := api.ToolFunctionParameters{}
+			if len(t.InputSchema) > 0 {
+				json.Unmarshal(t.InputSchema, &params)
+			}
+			params.Type = "object"
+			if params.Properties == nil {
+				params.Properties = map[string]api.ToolProperty{}
+			}
+			if params.Required == nil {
+				params.Required = []string{}
+			}
+			tools = append(tools, api.Tool{
+				Type: "function",
+				Function: api.ToolFunction{
+					Name:        s.Name + mcpToolSeparator + t.Name,
+					Description: t.Description,
+					Parameters:  params,
+				},
+			})
+		}
+	}
+	return tools
+}
+
+// findMCPTool localiza el servidor y la herramienta de un nombre <servidor>__<tool>.
+func findMCPTool(fullName string) (*mcpServer, string, bool) {
+	for _, s := range connectedMCPServers(0) {
+		tool, ok := strings.CutPrefix(fullName, s.Name+mcpToolSeparator)
+		if !ok {
+			continue
+		}
+		for _, t := range s.tools {
+			if t.Name == tool {
+				return s, tool, true
+			}
+		}
+	}
+	return nil, "", false
+}
+
+// callTool llama a una herramienta y devuelve su contenido como texto.
+func (s *mcpServer) callTool(ctx context.Context, tool string, args map[string]any) (string, bool, error) {
+	if args == nil {
+		args = map[string]any{}
+	}
+	ctx, cancel := context.WithTimeout(ctx, mcpCallTimeout)
+	defer cancel()
+	result, err := s.transport.call(ctx, "tools/call", map[string]any{"name": tool, "arguments": args})
+	if err != nil {
+		return "", false, err
+	}
+	var res struct {
+		Content []struct {
+			Type     string `json:"type"`
+			Text     string `json:"text"`
+			MimeType string `json:"mimeType"`
+			Resource struct {
+				URI  string `json:"uri"`
+				Text string `json:"text"`
+			} `json:"resource"`
+		} `json:"content"`
+		StructuredContent json.RawMessage `json:"structuredContent"`
+		IsError           bool            `json:"isError"`
+	}
+	if err := json.Unmarshal(result, &res); err != nil {
+		return "", false, err
+	}
+	var parts []string
+	for _, c := range res.Content {
+		switch c.Type {
+		case "text":
+			parts = append(parts, c.Text)
+		case "resource":
+			parts = append(parts, fmt.Sprintf("[recurso %s]\n%s", c.Resource.URI, c.Resource.Text))
+		default:
+			parts = append(parts, fmt.Sprintf("[contenido %s %s omitido]", c.Type, c.MimeType))
+		}
+	}
+	if len(parts) == 0 && len(res.StructuredContent) > 0 {
+		parts = append(parts, string(res.StructuredContent))
+	}
+	return strings.Join(parts, "\n"), res.IsError, nil
+}
+
+// handleMCPCommand implementa /mcp: estado de los servidores y sus herramientas.
+func handleMCPCommand() {
+	if len(mcpServers) == 0 {
+		fmt.Println(cSystem("IA> No hay servidores MCP configurados (\"mcp_servers\" en config.json)."))
+		fmt.Println()
+		return
+	}
+	for _, s := range mcpServers {
+		kind := "stdio"
+		if s.config.URL != "" {
+			kind = "http"
+		}
+		select {
+		case <-s.ready:
+		default:
+			fmt.Println(cPrompt(s.Name) + cSystem(fmt.Sprintf(" (%s) conectando...", kind)))
+			continue
+		}
+		if s.err != nil {
+			fmt.Println(cPrompt(s.Name) + cError(fmt.Sprintf(" (%s) error: %v", kind, s.err)))
+			continue
+		}
+		fmt.Println(cPrompt(s.Name) + cSystem(fmt.Sprintf(" (%s, %s) %d herramientas", kind, s.info, len(s.tools))))
+		for _, t := range s.tools {
+			description := strings.SplitN(t.Description, "\n", 2)[0]
+			fmt.Println("    " + cIA(t.Name) + cSystem(" "+description))
+		}
+	}
+	fmt.Println()
+}
+
+// closeMCPServers cierra las conexiones al salir.
+func closeMCPServers() {
+	for _, s := range mcpServers {
+		select {
+		case <-s.ready:
+			if s.err == nil {
+				s.transport.close()
+			}
+		default:
+		}
+	}
+}
+
+// --- Transporte stdio ---
+
+// mcpStdioTransport habla con un proceso local por stdin/stdout, un mensaje por línea.
+type mcpStdioTransport struct {
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	stderr  *limitedBuffer
+	writeMu sync.Mutex
+	nextID  atomic.Int64
+
+	mu      sync.Mutex
+	pending map[string]chan jsonrpcMessage
+	closed  error
+}
+
+func newMCPStdioTransport(cfg mcpServerConfig) (*mcpStdioTransport, error) {
+	cmd := exec.Command(cfg.Command, cfg.Args...)
+	cmd.Env = os.Environ()
+	for k, v := range cfg.Env {
+		cmd.Env = append(cmd.Env, k+"="+os.ExpandEnv(v))
+	}
+	t := &mcpStdioTransport{cmd: cmd, stderr: &limitedBuffer{max: mcpStderrMax}, pending: map[string]chan jsonrpcMessage{}}
+	cmd.Stderr = t.stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	t.stdin = stdin
+	go t.readLoop(stdout)
+	return t, nil
+}
+
+// readLoop reparte las respuestas y contesta a las peticiones del servidor.
+func (t *mcpStdioTransport) readLoop(stdout io.Reader) {
+	reader := bufio.NewReaderSize(stdout, 64*1024)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			var msg jsonrpcMessage
+			if json.Unmarshal(line, &msg) == nil {
+				t.handle(msg)
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	t.cmd.Wait()
+	reason := "el servidor MCP terminó"
+	if errText := strings.TrimSpace(t.stderr.String()); errText != "" {
+		lines := strings.Split(errText, "\n")
+		reason += ": " + lines[len(lines)-1]
+	}
+	t.mu.Lock()
+	t.closed = errors.New(reason)
+	for id, ch := range t.pending {
+		close(ch)
+		delete(t.pending, id)
+	}
+	t.mu.Unlock()
+}
+
+func (t *mcpStdioTransport) handle(msg jsonrpcMessage) {
+	switch {
+	case msg.Method != "" && len(msg.ID) > 0:
+		t.write(mcpServerRequestReply(msg))
+	case msg.Method == "" && len(msg.ID) > 0:
+		t.mu.Lock()
+		ch := t.pending[string(msg.ID)]
+		delete(t.pending, string(msg.ID))
+		t.mu.Unlock()
+		if ch != nil {
+			ch <- msg
+		}
+	}
+	// Notificaciones del servidor (logs, progreso, cambios de lista): se ignoran
+}
+
+func (t *mcpStdioTransport) write(msg jsonrpcMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+	_, err = t.stdin.Write(append(data, '\n'))
+	return err
+}
+
+func (t *mcpStdioTransport) call(ctx context.Context, method string, params any) (json.RawMessage, error) {
+	id := json.RawMessage(strconv.FormatInt(t.nextID.Add(1), 10))
+	msg, err := newJSONRPCMessage(id, method, params)
+	if err != nil {
+		return nil, err
+	}
+	ch := make(chan jsonrpcMessage, 1)
+	t.mu.Lock()
+	if t.closed != nil {
+		t.mu.Unlock()
+		return nil, t.closed
+	}
+	t.pending[string(id)] = ch
+	t.mu.Unlock()
+
+	if err := t.write(msg); err != nil {
+		return nil, err
+	}
+	select {
+	case resp, ok := <-ch:
+		if !ok {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			return nil, t.closed
+		}
+		if resp.Error != nil {
+			return nil, resp.Error
+		}
+		return resp.Result, nil
+	case <-ctx.Done():
+		t.mu.Lock()
+		delete(t.pending, string(id))
+		t.mu.Unlock()
+		// Avisar al servidor de que ya no esperamos la respuesta
+		cancel, _ := newJSONRPCMessage(nil, "notifications/cancelled", map[string]any{"requestId": id, "reason": "cancelado por el usuario"})
+		t.write(cancel)
+		return nil, ctx.Err()
+	}
+}
+
+func (t *mcpStdioTransport) notify(ctx context.Context, method string, params any) error {
+	msg, err := newJSONRPCMessage(nil, method, params)
+	if err != nil {
+		return err
+	}
+	return t.write(msg)
+}
+
+// close cierra stdin (la forma estándar de pedir al servidor que termine) y, si no
+// termina a tiempo, lo mata.
+func (t *mcpStdioTransport) close() {
+	t.stdin.Close()
+	done := make(chan struct{})
+	go func() {
+		for {
+			t.mu.Lock()
+			closed := t.closed != nil
+			t.mu.Unlock()
+			if closed {
+				close(done)
+				return
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.cmd.Process.Kill()
+	}
+}
+
+// --- Transporte HTTP (streamable) ---
+
+// mcpHTTPTransport envía cada mensaje con un POST. La respuesta puede ser JSON o un
+// flujo SSE del que se toma el mensaje con el id de la petición.
+type mcpHTTPTransport struct {
+	url             string
+	headers         map[string]string
+	client          *http.Client
+	nextID          atomic.Int64
+	sessionID       string
+	protocolVersion string
+}
+
+func newMCPHTTPTransport(url string, headers map[string]string) *mcpHTTPTransport {
+	expanded := map[string]string{}
+	for k, v := range headers {
+		expanded[k] = os.ExpandEnv(v) // Permite "Bearer ${TOKEN}" sin guardar el token
+	}
+	return &mcpHTTPTransport{url: url, headers: expanded, client: &http.Client{}}
+}
+
+func (t *mcpHTTPTransport) post(ctx context.Context, msg jsonrpcMessage) (*http.Response, error) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.url, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json, text/event-stream")
+	t.setSessionHeaders(req)
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if id := resp.Header.Get("Mcp-Session-Id"); id != "" {
+		t.sessionID = id
+	}
+	if resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusNotFound && t.sessionID != "" {
+			return nil, fmt.Errorf("la sesión MCP ha caducado (reinicia terminal-ia)")
+		}
+		return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return resp, nil
+}
+
+func (t *mcpHTTPTransport) setSessionHeaders(req *http.Request) {
+	for k, v := range t.headers {
+		req.Header.Set(k, v)
+	}
+	if t.sessionID != "" {
+		req.Header.Set("Mcp-Session-Id", t.sessionID)
+	}
+	if t.protocolVersion != "" {
+		req.Header.Set("MCP-Protocol-Version", t.protocolVersion)
+	}
+}
+
+func (t *mcpHTTPTransport) call(ctx context.Context, method string, params any) (json.RawMessage, error) {
+	id := json.RawMessage(strconv.FormatInt(t.nextID.Add(1), 10))
+	msg, err := newJSONRPCMessage(id, method, params)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.post(ctx, msg)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var reply *jsonrpcMessage
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		reply, err = t.readSSE(ctx, resp.Body, id)
+	} else {
+		reply = &jsonrpcMessage{}
+		err = json.NewDecoder(resp.Body).Decode(reply)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if reply.Error != nil {
+		return nil, reply.Error
+	}
+	return reply.Result, nil
+}
+
+// readSSE lee eventos hasta encontrar la respuesta con el id dado. Las peticiones del
+// servidor que lleguen por el flujo (ping, roots/list) se contestan con otro POST.
+func (t *mcpHTTPTransport) readSSE(ctx context.Context, body io.Reader, id json.RawMessage) (*jsonrpcMessage, error) {
+	reader := bufio.NewReaderSize(body, 64*1024)
+	var data strings.Builder
+	for {
+		line, err := reader.ReadString('\n')
+		line = strings.TrimRight(line, "\r\n")
+		switch {
+		case strings.HasPrefix(line, "data:"):
+			data.WriteString(strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		case line == "" && data.Len() > 0:
+			var msg jsonrpcMessage
+			if json.Unmarshal([]byte(data.String()), &msg) == nil {
+				switch {
+				case msg.Method == "" && string(msg.ID) == string(id):
+					return &msg, nil
+				case msg.Method != "" && len(msg.ID) > 0:
+					if resp, err := t.post(ctx, mcpServerRequestReply(msg)); err == nil {
+						resp.Body.Close()
+					}
+				}
+			}
+			data.Reset()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("el servidor cerró el flujo sin responder")
+		}
+	}
+}
+
+func (t *mcpHTTPTransport) notify(ctx context.Context, method string, params any) error {
+	msg, err := newJSONRPCMessage(nil, method, params)
+	if err != nil {
+		return err
+	}
+	resp, err := t.post(ctx, msg)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// close termina la sesión en el servidor (DELETE), si la hay.
+func (t *mcpHTTPTransport) close() {
+	if t.sessionID == "" {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, t.url, nil)
+	if err != nil {
+		return
+	}
+	t.setSessionHeaders(req)
+	if resp, err := t.client.Do(req); err == nil {
+		resp.Body.Close()
+	}
+}
+
+// --- Utilidades JSON-RPC ---
+
+func newJSONRPCMessage(id json.RawMessage, method string, params any) (jsonrpcMessage, error) {
+	msg := jsonrpcMessage{JSONRPC: "2.0", ID: id, Method: method}
+	if params != nil {
+		data, err := json.Marshal(params)
+		if err != nil {
+			return msg, err
+		}
+		msg.Params = data
+	}
+	return msg, nil
+}
+
+// mcpServerRequestReply contesta a las peticiones que puede hacer el servidor: ping y
+// roots/list (el directorio actual). El resto no se admite.
+func mcpServerRequestReply(req jsonrpcMessage) jsonrpcMessage {
+	reply := jsonrpcMessage{JSONRPC: "2.0", ID: req.ID}
+	switch req.Method {
+	case "ping":
+		reply.Result = json.RawMessage("{}")
+	case "roots/list":
+		cwd, _ := os.Getwd()
+		data, _ := json.Marshal(map[string]any{"roots": []map[string]string{{"uri": "file://" + cwd, "name": "cwd"}}})
+		reply.Result = data
+	default:
+		reply.Error = &jsonrpcError{Code: -32601, Message: "método no admitido: " + req.Method}
+	}
+	return reply
+}
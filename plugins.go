@@ -0,0 +1,298 @@
+// Copyright (c) 2025 Daniel Serrano Armenta. dani.eus79@gmail.com Todos los derechos reservados.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/ollama/ollama/api"
+	"github.com/peterh/liner"
+)
+
+// --- Plugins: comandos / externos ---
+//
+// Cualquier ejecutable llamado terminal-ia-<nombre> en ~/.config/terminal-ia/plugins o
+// en el PATH se registra como /<nombre>. Al invocarlo recibe por stdin una petición
+// JSON (pluginRequest) y devuelve por stdout una respuesta JSON (pluginResponse) con un
+// texto a mostrar, un comando a sugerir (que siempre pide confirmación) y/o un prompt
+// para el modelo. Una salida que no es JSON se muestra tal cual. Con la acción
+// "describe" el plugin puede devolver la descripción y el uso que aparecen en /help.
+// Los comandos internos y las macros tienen prioridad sobre los plugins.
+
+const (
+	pluginPrefix          = "terminal-ia-"
+	pluginDirName         = "plugins"
+	pluginProtocolVersion = 1
+	pluginTimeout         = 2 * time.Minute
+	pluginDescribeTimeout = 2 * time.Second
+	pluginMaxOutput       = 1024 * 1024
+)
+
+// plugin es un ejecutable registrado como comando /.
+type plugin struct {
+	Name string
+	Path string
+
+	describeOnce sync.Once
+	usage        string
+	description  string
+}
+
+// pluginContext es información del entorno para el plugin.
+type pluginContext struct {
+	OS        string `json:"os"`
+	Arch      string `json:"arch"`
+	Shell     string `json:"shell,omitempty"`
+	Home      string `json:"home,omitempty"`
+	Project   string `json:"project,omitempty"`
+	AutoMode  bool   `json:"auto_mode"`
+	Ollama    string `json:"ollama_host,omitempty"`
+	ConfigDir string `json:"config_dir,omitempty"`
+}
+
+// pluginRequest es lo que recibe el plugin por stdin.
+type pluginRequest struct {
+	Version int           `json:"version"`
+	Action  string        `json:"action"` // "run" o "describe"
+	Name    string        `json:"name"`
+	Args    string        `json:"args"`
+	Argv    []string      `json:"argv"`
+	Cwd     string        `json:"cwd"`
+	Model   string        `json:"model"`
+	Context pluginContext `json:"context"`
+}
+
+// pluginResponse es lo que devuelve el plugin por stdout. Todos los campos son
+// opcionales.
+type pluginResponse struct {
+	Text        string `json:"text,omitempty"`        // Texto a mostrar
+	Command     string `json:"command,omitempty"`     // Comando de shell a sugerir
+	Prompt      string `json:"prompt,omitempty"`      // Prompt para el modelo (vía /chat)
+	Error       string `json:"error,omitempty"`       // Error a mostrar
+	Usage       string `json:"usage,omitempty"`       // Sólo en "describe"
+	Description string `json:"description,omitempty"` // Sólo en "describe"
+}
+
+var plugins map[string]*plugin
+
+// pluginDir devuelve el directorio de plugins junto a config.json.
+func pluginDir() string {
+	if configPath == "" {
+		return ""
+	}
+	return filepath.Join(filepath.Dir(configPath), pluginDirName)
+}
+
+// loadPlugins busca los ejecutables terminal-ia-<nombre>. El directorio de plugins
+// tiene prioridad sobre el PATH y, dentro del PATH, gana el primero como en la shell.
+func loadPlugins() {
+	plugins = map[string]*plugin{}
+	reserved := map[string]bool{}
+	for _, cmd := range builtinSlashCommands {
+		reserved[strings.Fields(strings.TrimPrefix(cmd, "/"))[0]] = true
+	}
+
+	dirs := filepath.SplitList(os.Getenv("PATH"))
+	if dir := pluginDir(); dir != "" {
+		dirs = append([]string{dir}, dirs...)
+	}
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			name, ok := strings.CutPrefix(entry.Name(), pluginPrefix)
+			if !ok || !macroNameRe.MatchString(name) || reserved[name] || userMacros[name] != nil || plugins[name] != nil {
+				continue
+			}
+			path := filepath.Join(dir, entry.Name())
+			info, err := os.Stat(path) // Sigue los enlaces simbólicos
+			if err != nil || !info.Mode().IsRegular() || info.Mode().Perm()&0111 == 0 {
+				continue
+			}
+			plugins[name] = &plugin{Name: name, Path: path}
+		}
+	}
+}
+
+// sortedPlugins devuelve los plugins ordenados por nombre.
+func sortedPlugins() []*plugin {
+	list := make([]*plugin, 0, len(plugins))
+	for _, p := range plugins {
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// lookupPlugin indica si la línea invoca un plugin y devuelve sus argumentos.
+func lookupPlugin(input string) (*plugin, string, bool) {
+	if !strings.HasPrefix(input, "/") {
+		return nil, "", false
+	}
+	name, args, _ := strings.Cut(strings.TrimPrefix(input, "/"), " ")
+	p, ok := plugins[name]
+	return p, strings.TrimSpace(args), ok
+}
+
+// newPluginRequest prepara la petición para el plugin.
+func newPluginRequest(p *plugin, action string, args string, modelName string, autoMode bool) pluginRequest {
+	cwd, _ := os.Getwd()
+	home, _ := os.UserHomeDir()
+	return pluginRequest{
+		Version: pluginProtocolVersion,
+		Action:  action,
+		Name:    p.Name,
+		Args:    args,
+		Argv:    strings.Fields(args),
+		Cwd:     cwd,
+		Model:   modelName,
+		Context: pluginContext{
+			OS:        runtime.GOOS,
+			Arch:      runtime.GOARCH,
+			Shell:     os.Getenv("SHELL"),
+			Home:      home,
+			Project:   currentProject(),
+			AutoMode:  autoMode,
+			Ollama:    os.Getenv("OLLAMA_HOST"),
+			ConfigDir: filepath.Dir(configPath),
+		},
+	}
+}
+
+// call ejecuta el plugin con la petición. stderr del plugin va directo a la terminal.
+func (p *plugin) call(ctx context.Context, req pluginRequest) (pluginResponse, error) {
+	input, err := json.Marshal(req)
+	if err != nil {
+		return pluginResponse{}, err
+	}
+	cmd := exec.CommandContext(ctx, p.Path)
+	cmd.Stdin = bytes.NewReader(input)
+	output := &limitedBuffer{max: pluginMaxOutput}
+	cmd.Stdout = output
+	if req.Action == "run" {
+		cmd.Stderr = os.Stderr
+	}
+	runErr := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return pluginResponse{}, fmt.Errorf("el plugin no respondió a tiempo")
+	} else if ctx.Err() != nil {
+		return pluginResponse{}, fmt.Errorf("cancelado")
+	}
+
+	var resp pluginResponse
+	raw := bytes.TrimSpace(output.buf.Bytes())
+	if len(raw) > 0 && raw[0] == '{' && json.Unmarshal(raw, &resp) == nil {
+		if runErr != nil && resp.Error == "" {
+			resp.Error = runErr.Error()
+		}
+		return resp, nil
+	}
+	// Salida que no es JSON: se muestra tal cual
+	resp.Text = string(raw)
+	if runErr != nil {
+		resp.Error = runErr.Error()
+	}
+	return resp, nil
+}
+
+// describe pide al plugin su uso y descripción (una sola vez, con un tiempo límite
+// corto). Si no responde, /help muestra la ruta.
+func (p *plugin) describe() (string, string) {
+	p.describeOnce.Do(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), pluginDescribeTimeout)
+		defer cancel()
+		resp, err := p.call(ctx, newPluginRequest(p, "describe", "", "", false))
+		if err == nil && resp.Error == "" {
+			p.usage, p.description = resp.Usage, resp.Description
+		}
+		if p.description == "" {
+			p.description = "Plugin: " + p.Path
+		}
+	})
+	return p.usage, p.description
+}
+
+// pluginCompletions devuelve los plugins para el autocompletado.
+func pluginCompletions() []string {
+	var completions []string
+	for _, p := range sortedPlugins() {
+		completions = append(completions, "/"+p.Name+" ")
+	}
+	return completions
+}
+
+// printPluginHelp añade los plugins a /help.
+func printPluginHelp() {
+	if len(plugins) == 0 {
+		return
+	}
+	fmt.Println(cSystem("--- Plugins (terminal-ia-<nombre>) ---"))
+	for _, p := range sortedPlugins() {
+		usage, description := p.describe()
+		label := "/" + p.Name
+		if usage != "" {
+			label += " " + usage
+		}
+		fmt.Println(cPrompt("  "+label+" ") + cIA("- "+description))
+	}
+}
+
+// handlePluginCommand ejecuta un plugin y actúa según su respuesta. Devuelve true si el
+// usuario eligió "x (Siempre)" al confirmar el comando sugerido.
+func handlePluginCommand(client *api.Client, state *liner.State, modelName string, p *plugin, args string, autoMode bool) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), pluginTimeout)
+	defer cancel()
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT)
+	go func() {
+		select {
+		case <-sigChan:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	resp, err := p.call(ctx, newPluginRequest(p, "run", args, modelName, autoMode))
+	signal.Stop(sigChan)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, cError(fmt.Sprintf("IA> /%s: %v", p.Name, err)))
+		fmt.Println()
+		return false
+	}
+
+	if text := strings.TrimRight(resp.Text, "\n"); text != "" {
+		fmt.Println(text)
+	}
+	if resp.Error != "" {
+		fmt.Fprintln(os.Stderr, cError(fmt.Sprintf("IA> /%s: %s", p.Name, resp.Error)))
+	}
+	if resp.Prompt != "" {
+		handleChatCommand(client, state, modelName, resp.Prompt)
+	}
+	if command := strings.TrimSpace(resp.Command); command != "" && resp.Error == "" {
+		// Un comando de un plugin siempre se confirma, aunque esté el modo auto
+		suggestion := commandSuggestion{
+			Command:  command,
+			Request:  strings.TrimSpace("/" + p.Name + " " + args),
+			Model:    "plugin:" + p.Name,
+			Problems: validateShellCommand(command),
+		}
+		return confirmSuggestion(state, suggestion)
+	}
+	fmt.Println()
+	return false
+}
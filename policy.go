@@ -0,0 +1,332 @@
+// Copyright (c) 2025 Daniel Serrano Armenta. dani.eus79@gmail.com Todos los derechos reservados.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// --- Políticas de Ejecución (allow-auto / confirm / deny) ---
+//
+// Archivo opcional en ~/.config/terminal-ia/politicas.json con una lista de reglas.
+// Cada regla puede filtrar por el comando (glob o regex), por el programa invocado,
+// por el CWD y por el contexto actual de kubectl; una regla se cumple si se cumplen
+// todas sus condiciones. Las reglas se evalúan para cada comando simple de la línea
+// (separados por |, ;, &&, ||), también los que van dentro de bash -c '...' o eval.
+// Las reglas deny y confirm comparan command/command_regex con el comando tal cual y
+// sin asignaciones ni envoltorios (sudo, env, xargs...), que no deben esquivarlas:
+//
+//   - Si alguna regla "deny" se cumple, el comando no se ejecuta.
+//   - Si alguna regla "confirm" se cumple, se pide confirmación aunque esté el modo auto.
+//   - Si todos los comandos simples cumplen alguna regla "allow-auto" (y no hay
+//     sustituciones `...`/$(...) ni redirecciones >), se ejecuta sin confirmación
+//     aunque no esté el modo auto.
+//   - En otro caso decide el modo actual (/ask o auto).
+
+const (
+	policyFileName = "politicas.json"
+	// policyMaxNesting es cuántos bash -c/eval anidados se examinan; más allá se pide
+	// confirmación porque no se sabe qué comando se ejecuta.
+	policyMaxNesting = 4
+)
+
+// Acciones de las reglas.
+const (
+	policyAllowAuto = "allow-auto"
+	policyConfirm   = "confirm"
+	policyDeny      = "deny"
+)
+
+// policyRule es una regla tal y como aparece en politicas.json.
+type policyRule struct {
+	Command      string `json:"command,omitempty"`       // Glob sobre el comando (* y ?)
+	CommandRegex string `json:"command_regex,omitempty"` // Regex sobre el comando
+	Binary       string `json:"binary,omitempty"`        // Glob sobre el programa invocado
+	CWD          string `json:"cwd,omitempty"`           // Glob sobre el directorio actual (~ = home)
+	KubeContext  string `json:"kube_context,omitempty"`  // Glob sobre `kubectl config current-context`
+	Action       string `json:"action"`
+	Reason       string `json:"reason,omitempty"`
+
+	command, commandRegex, binary, cwd, kubeContext *regexp.Regexp
+}
+
+// policyFile es el formato del archivo de políticas.
+type policyFile struct {
+	Rules []policyRule `json:"rules"`
+}
+
+// policyDecision es el resultado de evaluar un comando.
+type policyDecision struct {
+	Action string      // "" si ninguna regla decide
+	Rule   *policyRule // Regla que decidió (la primera deny/confirm, o la primera allow-auto)
+}
+
+var (
+	policyRules []*policyRule
+	policyPath  string
+
+	// policyShells son los intérpretes cuyo -c ejecuta otra línea de comandos.
+	policyShells = map[string]bool{"sh": true, "bash": true, "dash": true, "zsh": true, "ksh": true}
+	// policyNestingRule es la decisión cuando el anidamiento supera policyMaxNesting.
+	policyNestingRule = &policyRule{Action: policyConfirm, Reason: "demasiados bash -c/eval anidados para comprobar la política"}
+)
+
+// loadPolicy lee el archivo de políticas. Las reglas inválidas se descartan con un aviso.
+func loadPolicy() {
+	if configPath == "" {
+		return
+	}
+	policyPath = filepath.Join(filepath.Dir(configPath), policyFileName)
+	data, err := os.ReadFile(policyPath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			fmt.Fprintln(os.Stderr, cError(fmt.Sprintf("Error al leer %s: %v", policyPath, err)))
+		}
+		return
+	}
+	var file policyFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		fmt.Fprintln(os.Stderr, cError(fmt.Sprintf("Error al parsear %s (no se aplicará ninguna política): %v", policyPath, err)))
+		return
+	}
+
+	home, _ := os.UserHomeDir()
+	for i := range file.Rules {
+		rule := &file.Rules[i]
+		if err := rule.compile(home); err != nil {
+			fmt.Fprintln(os.Stderr, cError(fmt.Sprintf("%s: regla %d descartada: %v", policyPath, i+1, err)))
+			continue
+		}
+		policyRules = append(policyRules, rule)
+	}
+}
+
+// compile valida la regla y prepara sus expresiones regulares.
+func (r *policyRule) compile(home string) error {
+	switch r.Action {
+	case policyAllowAuto, policyConfirm, policyDeny:
+	default:
+		return fmt.Errorf("acción desconocida %q (usa allow-auto, confirm o deny)", r.Action)
+	}
+	if r.Command == "" && r.CommandRegex == "" && r.Binary == "" && r.CWD == "" && r.KubeContext == "" {
+		return fmt.Errorf("la regla no tiene ninguna condición")
+	}
+	var err error
+	if r.CommandRegex != "" {
+		if r.commandRegex, err = regexp.Compile(r.CommandRegex); err != nil {
+			return fmt.Errorf("command_regex inválida: %v", err)
+		}
+	}
+	r.command = globToRegexp(r.Command)
+	r.binary = globToRegexp(r.Binary)
+	if cwd, ok := strings.CutPrefix(r.CWD, "~"); ok && home != "" {
+		r.CWD = home + cwd
+	}
+	r.cwd = globToRegexp(r.CWD)
+	r.kubeContext = globToRegexp(r.KubeContext)
+	return nil
+}
+
+// globToRegexp convierte un glob (* y ?, que aquí también cruzan '/') en una regex
+// anclada. Devuelve nil para un patrón vacío.
+func globToRegexp(glob string) *regexp.Regexp {
+	if glob == "" {
+		return nil
+	}
+	var b strings.Builder
+	b.WriteString("^")
+	for _, r := range glob {
+		switch r {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.MustCompile(b.String())
+}
+
+// policyEnv son los datos del entorno con los que se evalúan las reglas.
+type policyEnv struct {
+	cwd         string
+	kubeContext func() string
+}
+
+// matches indica si la regla se cumple para un comando simple de la línea. Las reglas
+// deny y confirm también se comparan con el comando sin envoltorios (stripped) y con la
+// línea completa; las allow-auto sólo con el comando simple tal cual, para que
+// "git status*" no autorice "git status; rm -rf ~" ni "sudo git status".
+func (r *policyRule) matches(line, segment, stripped, binary string, env *policyEnv) bool {
+	matchText := func(re *regexp.Regexp) bool {
+		if re.MatchString(segment) {
+			return true
+		}
+		return r.Action != policyAllowAuto && (re.MatchString(stripped) || re.MatchString(line))
+	}
+	if r.command != nil && !matchText(r.command) {
+		return false
+	}
+	if r.commandRegex != nil && !matchText(r.commandRegex) {
+		return false
+	}
+	if r.binary != nil && !r.binary.MatchString(binary) {
+		return false
+	}
+	if r.cwd != nil && !r.cwd.MatchString(env.cwd) {
+		return false
+	}
+	if r.kubeContext != nil && !r.kubeContext.MatchString(env.kubeContext()) {
+		return false
+	}
+	return true
+}
+
+// evaluatePolicy decide qué hacer con un comando según las reglas cargadas.
+func evaluatePolicy(command string) policyDecision {
+	if len(policyRules) == 0 {
+		return policyDecision{}
+	}
+	cwd, _ := os.Getwd()
+	var kubeOnce sync.Once
+	var kubeCtx string
+	env := &policyEnv{
+		cwd: cwd,
+		kubeContext: func() string {
+			kubeOnce.Do(func() {
+				if out, err := exec.Command("kubectl", "config", "current-context").Output(); err == nil {
+					kubeCtx = strings.TrimSpace(string(out))
+				}
+			})
+			return kubeCtx
+		},
+	}
+
+	line := strings.TrimSpace(command)
+	segments, tooDeep := policySegments(line, 0)
+	if len(segments) == 0 {
+		return policyDecision{}
+	}
+
+	var confirm, firstAllow *policyRule
+	if tooDeep {
+		confirm = policyNestingRule
+	}
+	allCovered := true
+	for _, words := range segments {
+		segment := strings.Join(words, " ")
+		stripped := strings.Join(segmentCommand(words), " ")
+		binary := segmentBinary(words)
+		var allow *policyRule
+		for _, rule := range policyRules {
+			if !rule.matches(line, segment, stripped, binary, env) {
+				continue
+			}
+			switch rule.Action {
+			case policyDeny:
+				return policyDecision{Action: policyDeny, Rule: rule}
+			case policyConfirm:
+				if confirm == nil {
+					confirm = rule
+				}
+			case policyAllowAuto:
+				if allow == nil {
+					allow = rule
+				}
+			}
+		}
+		if allow == nil {
+			allCovered = false
+		} else if firstAllow == nil {
+			firstAllow = allow
+		}
+	}
+
+	switch {
+	case confirm != nil:
+		return policyDecision{Action: policyConfirm, Rule: confirm}
+	case allCovered && !strings.ContainsAny(line, "`>") && !strings.Contains(line, "$("):
+		// Sustituciones de comandos y redirecciones pueden esconder otro comando o
+		// sobrescribir archivos: nunca se autorizan sin confirmación
+		return policyDecision{Action: policyAllowAuto, Rule: firstAllow}
+	}
+	return policyDecision{}
+}
+
+// policySegments trocea la línea en comandos simples y añade los que se ejecutan dentro
+// de bash -c '...' o eval, que de otro modo esconderían el comando a las reglas. Indica
+// también si el anidamiento supera policyMaxNesting.
+func policySegments(line string, depth int) ([][]string, bool) {
+	var segments [][]string
+	tooDeep := false
+	for _, words := range splitCommandSegments(line) {
+		segments = append(segments, words)
+		payload, ok := nestedCommand(segmentCommand(words))
+		if !ok {
+			continue
+		}
+		if depth >= policyMaxNesting {
+			tooDeep = true
+			continue
+		}
+		nested, deeper := policySegments(payload, depth+1)
+		segments = append(segments, nested...)
+		tooDeep = tooDeep || deeper
+	}
+	return segments, tooDeep
+}
+
+// nestedCommand devuelve la línea que ejecuta un "bash -c línea" o un "eval línea".
+func nestedCommand(command []string) (string, bool) {
+	if len(command) < 2 {
+		return "", false
+	}
+	name := filepath.Base(command[0])
+	if name == "eval" {
+		return strings.Join(command[1:], " "), true
+	}
+	if !policyShells[name] {
+		return "", false
+	}
+	for i, arg := range command[1 : len(command)-1] {
+		if strings.HasPrefix(arg, "-") && !strings.HasPrefix(arg, "--") && strings.Contains(arg, "c") {
+			return command[i+2], true // bash -c, bash -ec, bash -lc...
+		}
+	}
+	return "", false
+}
+
+// describe devuelve una descripción corta de la regla para mostrarla al usuario.
+func (d policyDecision) describe() string {
+	if d.Rule == nil {
+		return ""
+	}
+	if d.Rule.Reason != "" {
+		return d.Rule.Reason
+	}
+	var conds []string
+	for _, c := range []struct{ name, value string }{
+		{"command", d.Rule.Command}, {"command_regex", d.Rule.CommandRegex}, {"binary", d.Rule.Binary},
+		{"cwd", d.Rule.CWD}, {"kube_context", d.Rule.KubeContext},
+	} {
+		if c.value != "" {
+			conds = append(conds, fmt.Sprintf("%s=%q", c.name, c.value))
+		}
+	}
+	return fmt.Sprintf("%s %s", d.Action, strings.Join(conds, " "))
+}
+
+// printPolicyDenied informa de que una regla deny ha bloqueado el comando.
+func printPolicyDenied(d policyDecision) {
+	fmt.Println(cError(fmt.Sprintf("IA> Bloqueado por la política (%s). No se ejecuta.", d.describe())))
+	fmt.Println()
+}
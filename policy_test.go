@@ -0,0 +1,182 @@
+// Copyright (c) 2025 Daniel Serrano Armenta. dani.eus79@gmail.com Todos los derechos reservados.
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// withPolicyRules sustituye las reglas cargadas durante el test.
+func withPolicyRules(t *testing.T, rules ...policyRule) {
+	t.Helper()
+	saved := policyRules
+	t.Cleanup(func() { policyRules = saved })
+	policyRules = nil
+	for i := range rules {
+		rule := rules[i]
+		if err := rule.compile("/home/test"); err != nil {
+			t.Fatalf("regla %d: %v", i, err)
+		}
+		policyRules = append(policyRules, &rule)
+	}
+}
+
+func TestEvaluatePolicy(t *testing.T) {
+	withPolicyRules(t,
+		policyRule{Command: "rm -rf /*", Action: policyDeny},
+		policyRule{Binary: "dd", Action: policyDeny, Reason: "dd está prohibido"},
+		policyRule{CommandRegex: `^git push .*--force`, Action: policyConfirm},
+		policyRule{Command: "git status*", Action: policyAllowAuto},
+		policyRule{Binary: "ls", Action: policyAllowAuto},
+		policyRule{Binary: "kubectl", Action: policyConfirm},
+	)
+
+	tests := []struct {
+		command string
+		want    string
+	}{
+		{"rm -rf /tmp/x", policyDeny},
+		{"echo hola && rm -rf /var", policyDeny}, // deny también mira la línea completa
+		{"dd if=/dev/zero of=disco", policyDeny},
+		{"sudo dd if=a of=b", policyDeny}, // sudo no oculta el programa
+		{"ls | dd of=x", policyDeny},
+		{"git push origin main --force", policyConfirm},
+		{"kubectl get pods", policyConfirm},
+		{"git status", policyAllowAuto},
+		{"git status -s | ls", policyAllowAuto},
+		{"git status; rm -rf ~", ""}, // allow-auto sólo cubre su comando simple
+		{"ls > lista.txt", ""},       // las redirecciones nunca se autorizan solas
+		{"ls $(cat lista)", ""},
+		{"ls `pwd`", ""},
+		{"cat archivo", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := evaluatePolicy(tt.command).Action; got != tt.want {
+			t.Errorf("evaluatePolicy(%q) = %q, se esperaba %q", tt.command, got, tt.want)
+		}
+	}
+}
+
+func TestEvaluatePolicyWrappers(t *testing.T) {
+	withPolicyRules(t,
+		policyRule{Command: "kubectl delete*", Action: policyDeny},
+		policyRule{CommandRegex: `^terraform destroy`, Action: policyConfirm},
+		policyRule{Command: "git status*", Action: policyAllowAuto},
+	)
+
+	tests := []struct {
+		command string
+		want    string
+	}{
+		{"kubectl delete pod web", policyDeny},
+		{"env kubectl delete pod web", policyDeny},
+		{"KUBECONFIG=x kubectl delete pod web", policyDeny},
+		{"command kubectl delete pod web", policyDeny},
+		{"sudo kubectl delete pod web", policyDeny},
+		{"sudo -u admin kubectl delete pod web", policyDeny},
+		{"/usr/bin/sudo kubectl delete pod web", policyDeny},
+		{"echo web | xargs -n 1 kubectl delete pod", policyDeny},
+		{"bash -c 'kubectl delete pod web'", policyDeny},
+		{"sh -ec 'echo hola; kubectl delete pod web'", policyDeny},
+		{`bash -c "sh -c 'kubectl delete pod web'"`, policyDeny},
+		{"eval kubectl delete pod web", policyDeny},
+		{`eval "kubectl delete pod web"`, policyDeny},
+		{"timeout 5 terraform destroy", policyConfirm},
+		{"kubectl get pods", ""},
+		{"bash -c 'kubectl get pods'", ""},
+		{"git status", policyAllowAuto},
+		{"sudo git status", ""},                        // allow-auto no cubre el comando con envoltorios
+		{"bash -c 'git status'", ""},                   // ni dentro de otro intérprete
+		{"eval eval eval eval eval ls", policyConfirm}, // Demasiado anidado para comprobarlo
+	}
+	for _, tt := range tests {
+		if got := evaluatePolicy(tt.command).Action; got != tt.want {
+			t.Errorf("evaluatePolicy(%q) = %q, se esperaba %q", tt.command, got, tt.want)
+		}
+	}
+}
+
+func TestSegmentCommand(t *testing.T) {
+	tests := []struct {
+		command, want string
+	}{
+		{"ls -la", "ls -la"},
+		{"FOO=1 BAR=2 make test", "make test"},
+		{"sudo -u root -E systemctl restart nginx", "systemctl restart nginx"},
+		{"env -u HOME git status", "git status"},
+		{"timeout -s KILL 5 curl x", "curl x"},
+		{"xargs -I {} rm {}", "rm {}"},
+		{"nice -n 10 ionice -c 3 tar czf a.tgz .", "tar czf a.tgz ."},
+		{"sudo", ""},
+	}
+	for _, tt := range tests {
+		segments := splitCommandSegments(tt.command)
+		if got := strings.Join(segmentCommand(segments[0]), " "); got != tt.want {
+			t.Errorf("segmentCommand(%q) = %q, se esperaba %q", tt.command, got, tt.want)
+		}
+	}
+}
+
+func TestEvaluatePolicyCWD(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	withPolicyRules(t,
+		policyRule{CWD: dir + "*", Binary: "rm", Action: policyDeny},
+		policyRule{CWD: "/otro/*", Binary: "ls", Action: policyDeny},
+	)
+	if got := evaluatePolicy("rm archivo").Action; got != policyDeny {
+		t.Errorf("rm en %s = %q, se esperaba deny", dir, got)
+	}
+	if got := evaluatePolicy("ls").Action; got != "" {
+		t.Errorf("ls fuera de /otro = %q, se esperaba sin decisión", got)
+	}
+}
+
+func TestPolicyRuleCompile(t *testing.T) {
+	tests := []struct {
+		rule    policyRule
+		wantErr bool
+	}{
+		{policyRule{Command: "ls", Action: policyAllowAuto}, false},
+		{policyRule{Command: "ls", Action: "permitir"}, true},
+		{policyRule{Action: policyDeny}, true}, // Sin condiciones bloquearía todo
+		{policyRule{CommandRegex: "(", Action: policyDeny}, true},
+	}
+	for _, tt := range tests {
+		if err := tt.rule.compile("/home/test"); (err != nil) != tt.wantErr {
+			t.Errorf("compile(%+v) = %v, se esperaba error: %v", tt.rule, err, tt.wantErr)
+		}
+	}
+
+	rule := policyRule{CWD: "~/trabajo/*", Action: policyConfirm}
+	if err := rule.compile("/home/test"); err != nil {
+		t.Fatal(err)
+	}
+	if !rule.cwd.MatchString("/home/test/trabajo/api") {
+		t.Errorf("~ no se expandió al home: %s", rule.CWD)
+	}
+}
+
+func TestGlobToRegexp(t *testing.T) {
+	tests := []struct {
+		glob, text string
+		want       bool
+	}{
+		{"git *", "git status", true},
+		{"git *", "git", false},
+		{"rm -rf /*", "rm -rf /var/lib", true}, // * cruza '/'
+		{"a?c", "abc", true},
+		{"a.c", "abc", false}, // El punto es literal
+		{"ls", "ls -la", false},
+	}
+	for _, tt := range tests {
+		if got := globToRegexp(tt.glob).MatchString(tt.text); got != tt.want {
+			t.Errorf("globToRegexp(%q) sobre %q = %v, se esperaba %v", tt.glob, tt.text, got, tt.want)
+		}
+	}
+	if globToRegexp("") != nil {
+		t.Error("un glob vacío debe devolver nil")
+	}
+}
@@ -0,0 +1,445 @@
+// Copyright (c) 2025 Daniel Serrano Armenta. dani.eus79@gmail.com Todos los derechos reservados.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"mvdan.cc/sh/v3/expand"
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// --- Vista Previa de Operaciones Destructivas ---
+//
+// Para los comandos sugeridos que borran, mueven o sobrescriben archivos (rm, mv,
+// find -delete, sed -i, redirecciones con >) se calcula, sin ejecutar la acción, qué
+// archivos se verían afectados: se expanden los globs, se ejecuta el find sin -delete
+// y se aplica el sed sobre una copia en memoria para mostrar el diff.
+
+const (
+	previewMaxListed    = 10    // Rutas mostradas por acción
+	previewMaxDiffLines = 40    // Líneas de diff mostradas por archivo
+	previewMaxWalk      = 50000 // Archivos recorridos como mucho al sumar directorios
+	previewTimeout      = 5 * time.Second
+)
+
+// previewAction resume el impacto de una operación destructiva.
+type previewAction struct {
+	Title   string   // "rm -r", "mv", "find -delete", "sed -i", "> (sobrescribir)"
+	Paths   []string // Rutas afectadas tal y como se mostrarán
+	Targets []string // Rutas que la operación crea o sobrescribe (p.ej. el destino de mv)
+	Files   int      // Archivos afectados (contando el contenido de directorios)
+	Bytes   int64
+	Partial bool // Se alcanzó previewMaxWalk y el recuento es un mínimo
+	Diffs   []string
+	Note    string // Motivo por el que no se pudo previsualizar, si lo hay
+}
+
+// buildCommandPreview analiza el comando y devuelve las operaciones destructivas que
+// contiene. Devuelve nil si el comando no borra, mueve ni sobrescribe nada.
+func buildCommandPreview(command string) []previewAction {
+	file, err := syntax.NewParser(syntax.Variant(syntax.LangBash)).Parse(strings.NewReader(command), "")
+	if err != nil {
+		return nil // validateShellCommand ya informa del error de sintaxis
+	}
+	cfg := &expand.Config{
+		Env:      expand.ListEnviron(os.Environ()...),
+		ReadDir2: os.ReadDir, // Globs sin coincidencias quedan literales y salen como "No existen"
+	}
+
+	var actions []previewAction
+	syntax.Walk(file, func(node syntax.Node) bool {
+		switch n := node.(type) {
+		case *syntax.Stmt:
+			for _, redir := range n.Redirs {
+				if action, ok := previewRedirect(cfg, redir); ok {
+					actions = append(actions, action)
+				}
+			}
+		case *syntax.CallExpr:
+			if action, ok := previewCall(cfg, n); ok {
+				actions = append(actions, action)
+			}
+		}
+		return true
+	})
+	return actions
+}
+
+// previewRedirect detecta "> archivo" sobre un archivo que ya existe.
+func previewRedirect(cfg *expand.Config, redir *syntax.Redirect) (previewAction, bool) {
+	if redir.Op != syntax.RdrOut && redir.Op != syntax.ClbOut && redir.Op != syntax.RdrAll {
+		return previewAction{}, false
+	}
+	target, err := expand.Literal(cfg, redir.Word)
+	if err != nil || strings.HasPrefix(target, "/dev/") || (redir.N != nil && redir.N.Value != "1" && redir.N.Value != "2") {
+		return previewAction{}, false
+	}
+	info, err := os.Stat(target)
+	if os.IsNotExist(err) {
+		// Crear un archivo nuevo no es destructivo, pero /deshacer debe poder borrarlo
+		return previewAction{Title: "> (crear)", Targets: []string{target}}, true
+	}
+	if err != nil || !info.Mode().IsRegular() {
+		return previewAction{}, false
+	}
+	return previewAction{Title: "> (sobrescribir)", Paths: []string{target}, Files: 1, Bytes: info.Size()}, true
+}
+
+// previewCall calcula el impacto de rm, mv, find -delete y sed -i.
+func previewCall(cfg *expand.Config, call *syntax.CallExpr) (previewAction, bool) {
+	fields, err := expand.Fields(cfg, call.Args...)
+	if err != nil || len(fields) == 0 {
+		return previewAction{}, false
+	}
+	for len(fields) > 1 && commandWrappers[filepath.Base(fields[0])] {
+		fields = fields[1:]
+		for len(fields) > 1 && (strings.HasPrefix(fields[0], "-") || strings.Contains(fields[0], "=") || isNumericWord(fields[0])) {
+			fields = fields[1:]
+		}
+	}
+	name, args := filepath.Base(fields[0]), fields[1:]
+
+	switch name {
+	case "rm", "shred", "unlink", "rmdir":
+		flags, operands := splitFlagsAndOperands(args)
+		recursive := hasShortFlag(flags, 'r') || hasShortFlag(flags, 'R') || containsString(flags, "--recursive")
+		title := name
+		if recursive {
+			title += " -r"
+		}
+		return summarizePaths(title, operands, recursive), true
+
+	case "mv":
+		flags, operands := splitFlagsAndOperands(args)
+		var sources []string
+		var dest string
+		switch {
+		case hasShortFlag(flags, 't') && len(operands) >= 2:
+			sources, dest = operands[1:], operands[0] // mv -t destino origen...
+		case len(operands) >= 2:
+			sources, dest = operands[:len(operands)-1], operands[len(operands)-1]
+		}
+		for _, f := range flags {
+			if v, ok := strings.CutPrefix(f, "--target-directory="); ok {
+				sources, dest = operands, v
+			}
+		}
+		if dest == "" || len(sources) == 0 {
+			return previewAction{}, false
+		}
+		action := summarizePaths("mv", sources, true)
+		info, err := os.Stat(dest)
+		if err == nil && info.IsDir() {
+			for _, src := range sources {
+				action.Targets = append(action.Targets, filepath.Join(dest, filepath.Base(src)))
+			}
+		} else {
+			action.Targets = append(action.Targets, dest)
+		}
+		if err == nil && info.Mode().IsRegular() && len(sources) == 1 {
+			action.Note = fmt.Sprintf("%s ya existe y se sobrescribirá (%s)", dest, formatBytes(info.Size()))
+		}
+		return action, true
+
+	case "find":
+		return previewFind(args)
+
+	case "sed":
+		return previewSed(args)
+	}
+	return previewAction{}, false
+}
+
+// splitFlagsAndOperands separa las opciones (-x, --xx) de los operandos; tras "--" todo
+// son operandos.
+func splitFlagsAndOperands(args []string) ([]string, []string) {
+	var flags, operands []string
+	for i, arg := range args {
+		if arg == "--" {
+			return flags, append(operands, args[i+1:]...)
+		}
+		if strings.HasPrefix(arg, "-") && arg != "-" {
+			flags = append(flags, arg)
+		} else {
+			operands = append(operands, arg)
+		}
+	}
+	return flags, operands
+}
+
+// hasShortFlag indica si alguno de los flags cortos (posiblemente agrupados) contiene c.
+func hasShortFlag(flags []string, c rune) bool {
+	for _, f := range flags {
+		if !strings.HasPrefix(f, "--") && strings.ContainsRune(f[1:], c) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// summarizePaths cuenta los archivos y bytes de las rutas (recorriendo directorios si
+// recursive es true).
+func summarizePaths(title string, paths []string, recursive bool) previewAction {
+	action := previewAction{Title: title}
+	var missing []string
+	for _, path := range paths {
+		info, err := os.Lstat(path)
+		if err != nil {
+			missing = append(missing, path)
+			continue
+		}
+		if !info.IsDir() || !recursive {
+			action.Paths = append(action.Paths, path)
+			action.Files++
+			action.Bytes += info.Size()
+			continue
+		}
+		action.Paths = append(action.Paths, path+"/")
+		filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return nil
+			}
+			if action.Files >= previewMaxWalk {
+				action.Partial = true
+				return filepath.SkipAll
+			}
+			action.Files++
+			if fi, err := d.Info(); err == nil {
+				action.Bytes += fi.Size()
+			}
+			return nil
+		})
+	}
+	if len(missing) > 0 {
+		action.Note = "No existen: " + strings.Join(missing, ", ")
+	}
+	return action
+}
+
+// findActionArgs son las acciones de find que ejecutan programas o escriben archivos, con
+// el número de argumentos que consumen (-1: hasta ";" o "+").
+var findActionArgs = map[string]int{
+	"-exec": -1, "-execdir": -1, "-ok": -1, "-okdir": -1,
+	"-fprint": 1, "-fprint0": 1, "-fls": 1, "-fprintf": 2, "-delete": 0,
+}
+
+// previewFind ejecuta el find sustituyendo cada acción que ejecuta o escribe (-delete,
+// -exec, -ok, -fprint...) por -print, para que la vista previa no tenga efectos.
+func previewFind(args []string) (previewAction, bool) {
+	var safeArgs []string
+	title := ""
+	for i := 0; i < len(args); i++ {
+		n, isAction := findActionArgs[args[i]]
+		if !isAction {
+			safeArgs = append(safeArgs, args[i])
+			continue
+		}
+		if title == "" {
+			title = "find " + args[i]
+		}
+		if n < 0 {
+			for i+1 < len(args) && args[i+1] != ";" && args[i+1] != "+" {
+				i++
+			}
+			i++ // El ";" o "+" final
+		} else {
+			i += n
+		}
+		safeArgs = append(safeArgs, "-print")
+	}
+	if title == "" {
+		return previewAction{}, false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), previewTimeout)
+	defer cancel()
+	output, err := exec.CommandContext(ctx, "find", safeArgs...).Output()
+	if err != nil && len(output) == 0 {
+		return previewAction{Title: title, Note: fmt.Sprintf("No se pudo previsualizar: %v", err)}, true
+	}
+	var paths []string
+	for _, line := range strings.Split(strings.TrimRight(string(output), "\n"), "\n") {
+		if line != "" {
+			paths = append(paths, line)
+		}
+	}
+	return summarizePaths(title, paths, false), true
+}
+
+// previewSed aplica el sed sin -i sobre cada archivo y muestra el diff resultante.
+func previewSed(args []string) (previewAction, bool) {
+	var sedArgs, files []string
+	inPlace, explicitScript := false, false
+	scriptSeen := false
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "-i" || strings.HasPrefix(arg, "--in-place") || (strings.HasPrefix(arg, "-i") && !strings.HasPrefix(arg, "--")):
+			inPlace = true
+		case arg == "-e" || arg == "-f" || arg == "--expression" || arg == "--file":
+			explicitScript = true
+			sedArgs = append(sedArgs, arg)
+			if i+1 < len(args) {
+				i++
+				sedArgs = append(sedArgs, args[i])
+			}
+		case arg == "-l" || arg == "--line-length":
+			sedArgs = append(sedArgs, arg)
+			if i+1 < len(args) {
+				i++
+				sedArgs = append(sedArgs, args[i])
+			}
+		case strings.HasPrefix(arg, "-") && arg != "-":
+			if strings.HasPrefix(arg, "-e") || strings.HasPrefix(arg, "--expression=") {
+				explicitScript = true
+			}
+			sedArgs = append(sedArgs, arg)
+		case !explicitScript && !scriptSeen:
+			scriptSeen = true
+			sedArgs = append(sedArgs, arg)
+		default:
+			files = append(files, arg)
+		}
+	}
+	if !inPlace {
+		return previewAction{}, false
+	}
+
+	action := summarizePaths("sed -i", files, false)
+	for _, file := range action.Paths {
+		original, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), previewTimeout)
+		// --sandbox impide que el script escriba archivos o ejecute comandos (e, w, r)
+		cmdArgs := append(append([]string{"--sandbox"}, sedArgs...), file)
+		edited, err := exec.CommandContext(ctx, "sed", cmdArgs...).Output()
+		cancel()
+		if err != nil {
+			action.Note = fmt.Sprintf("No se pudo previsualizar el sed: %v", err)
+			continue
+		}
+		if diff := unifiedDiff(file, original, edited); diff != "" {
+			action.Diffs = append(action.Diffs, diff)
+		} else {
+			action.Diffs = append(action.Diffs, cSystem(fmt.Sprintf("%s: sin cambios", file)))
+		}
+	}
+	return action, true
+}
+
+// unifiedDiff compara dos versiones de un archivo con diff -u, o línea a línea si no
+// está disponible. Devuelve "" si son iguales.
+func unifiedDiff(name string, original, edited []byte) string {
+	if bytes.Equal(original, edited) {
+		return ""
+	}
+	var lines []string
+	if _, err := exec.LookPath("diff"); err == nil {
+		cmd := exec.Command("diff", "-u", "--label", name, "--label", name+" (después)", name, "-")
+		cmd.Stdin = bytes.NewReader(edited)
+		output, _ := cmd.Output() // diff sale con 1 cuando hay diferencias
+		lines = strings.Split(strings.TrimRight(string(output), "\n"), "\n")
+	} else {
+		before := strings.Split(string(original), "\n")
+		after := strings.Split(string(edited), "\n")
+		lines = append(lines, "--- "+name, "+++ "+name+" (después)")
+		for i := 0; i < max(len(before), len(after)); i++ {
+			var b, a string
+			if i < len(before) {
+				b = before[i]
+			}
+			if i < len(after) {
+				a = after[i]
+			}
+			if a != b {
+				lines = append(lines, fmt.Sprintf("@@ línea %d @@", i+1), "-"+b, "+"+a)
+			}
+		}
+	}
+
+	var out strings.Builder
+	for i, line := range lines {
+		if i >= previewMaxDiffLines {
+			out.WriteString(cSystem(fmt.Sprintf("    ... (%d líneas más)\n", len(lines)-i)))
+			break
+		}
+		switch {
+		case strings.HasPrefix(line, "+") && !strings.HasPrefix(line, "+++"):
+			out.WriteString("    " + cIA(line) + "\n")
+		case strings.HasPrefix(line, "-") && !strings.HasPrefix(line, "---"):
+			out.WriteString("    " + cError(line) + "\n")
+		default:
+			out.WriteString("    " + cSystem(line) + "\n")
+		}
+	}
+	return strings.TrimRight(out.String(), "\n")
+}
+
+// formatBytes formatea un tamaño en unidades legibles.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// printCommandPreview muestra la vista previa en la pantalla de confirmación.
+func printCommandPreview(actions []previewAction) {
+	if len(actions) == 0 {
+		return
+	}
+	header := false
+	for _, action := range actions {
+		if len(action.Paths) == 0 && len(action.Diffs) == 0 && action.Note == "" {
+			continue // Sólo crea archivos nuevos: no hay nada que avisar
+		}
+		if !header {
+			fmt.Println(cIA("IA> Vista previa (sin ejecutar):"))
+			header = true
+		}
+		count := fmt.Sprintf("%d archivos, %s", action.Files, formatBytes(action.Bytes))
+		if action.Partial {
+			count = "más de " + count
+		}
+		fmt.Printf("  %s %s\n", cError(action.Title+":"), count)
+		for i, path := range action.Paths {
+			if i >= previewMaxListed {
+				fmt.Println(cSystem(fmt.Sprintf("    ... y %d más", len(action.Paths)-i)))
+				break
+			}
+			fmt.Println("    " + path)
+		}
+		for _, diff := range action.Diffs {
+			fmt.Println(diff)
+		}
+		if action.Note != "" {
+			fmt.Println(cSystem("    " + action.Note))
+		}
+	}
+}
@@ -0,0 +1,50 @@
+// Copyright (c) 2025 Daniel Serrano Armenta. dani.eus79@gmail.com Todos los derechos reservados.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPreviewFindHasNoSideEffects(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	if err := os.WriteFile("a.log", []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		command string
+		title   string
+	}{
+		{"find . -name '*.log' -delete", "find -delete"},
+		{"find . -name '*.log' -exec rm {} \\;", "find -exec"},
+		{"find . -name '*.log' -exec /bin/rm {} +", "find -exec"},
+		{"find . -name '*.log' -exec sudo rm {} \\;", "find -exec"},
+		{"find . -name '*.log' -exec sh -c 'touch hecho' \\;", "find -exec"},
+		{"find . -name '*.log' -execdir mv {} movido \\;", "find -execdir"},
+		{"find . -name '*.log' -ok rm {} \\;", "find -ok"},
+		{"find . -name '*.log' -fprint salida", "find -fprint"},
+		{"find . -name '*.log' -fprintf salida '%p\\n'", "find -fprintf"},
+		{"find . -name '*.log' -fls salida", "find -fls"},
+	}
+	for _, tt := range tests {
+		actions := buildCommandPreview(tt.command)
+		if len(actions) != 1 || actions[0].Title != tt.title || actions[0].Files != 1 {
+			t.Errorf("buildCommandPreview(%q) = %+v, se esperaba %q con un archivo", tt.command, actions, tt.title)
+		}
+	}
+	for _, name := range []string{"hecho", "movido", "salida"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			t.Errorf("la vista previa creó %s", name)
+		}
+	}
+	if _, err := os.Stat("a.log"); err != nil {
+		t.Error("la vista previa borró a.log")
+	}
+	if actions := buildCommandPreview("find . -name '*.log'"); len(actions) != 0 {
+		t.Errorf("un find sin acciones no necesita vista previa: %+v", actions)
+	}
+}
@@ -0,0 +1,205 @@
+// Copyright (c) 2025 Daniel Serrano Armenta. dani.eus79@gmail.com Todos los derechos reservados.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/peterh/liner"
+)
+
+// --- Proyectos: Ámbito del Historial Semántico ---
+//
+// Cada comando del historial semántico se etiqueta con el proyecto en el que se ejecutó:
+// el directorio más cercano (hacia arriba desde el CWD) que contenga alguno de los
+// marcadores de projectMarkers. Fuera de un proyecto la etiqueta queda vacía (global).
+//
+// Un proyecto puede compartir comandos con el equipo en .terminal-ia/comandos (uno por
+// línea, '#' para comentarios). Ese archivo se puede versionar: al entrar en el proyecto
+// sus comandos se añaden al historial semántico local con la etiqueta del proyecto.
+// Como acaban en /buscar y en los ejemplos del modelo, un repositorio clonado no puede
+// colarlos sin más: la primera vez (y cada vez que cambia el archivo) se muestran y se
+// pide permiso. La decisión se recuerda por ruta y hash del contenido.
+
+const (
+	projectConfigDir     = ".terminal-ia"
+	sharedCommandsFile   = "comandos"
+	projectScoreBoost    = 0.05 // Ventaja de los resultados del proyecto actual en /buscar
+	projectSearchFanout  = 10   // Candidatos extra que se piden al índice para re-ordenar
+	globalSearchFlag     = "--global"
+	projectNameGlobalTag = "global"
+	projectTrustFileName = "proyectos-confianza.json"
+	projectTrustMaxShown = 10 // Comandos que se muestran al pedir permiso
+)
+
+// projectTrust es la decisión del usuario sobre los comandos compartidos de un proyecto.
+type projectTrust struct {
+	Hash    string `json:"hash"` // sha256 de .terminal-ia/comandos cuando se decidió
+	Trusted bool   `json:"trusted"`
+}
+
+// projectMarkers son los archivos/directorios que delimitan la raíz de un proyecto.
+var projectMarkers = []string{projectConfigDir, ".git", ".hg", ".svn"}
+
+// findProjectRoot devuelve la raíz del proyecto que contiene dir, o "" si no hay ninguno.
+func findProjectRoot(dir string) string {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return ""
+	}
+	home, _ := os.UserHomeDir()
+	for {
+		if dir == home {
+			return "" // El propio home no cuenta como proyecto (p.ej. dotfiles con .git)
+		}
+		for _, marker := range projectMarkers {
+			if _, err := os.Stat(filepath.Join(dir, marker)); err == nil {
+				return dir
+			}
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// currentProject devuelve la raíz del proyecto del CWD.
+func currentProject() string {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return ""
+	}
+	return findProjectRoot(cwd)
+}
+
+// projectDisplayName devuelve un nombre corto para mostrar junto a los resultados.
+func projectDisplayName(project string) string {
+	if project == "" {
+		return projectNameGlobalTag
+	}
+	return filepath.Base(project)
+}
+
+// semanticKey identifica una entrada del historial: el mismo comando puede existir
+// una vez por proyecto.
+func semanticKey(project string, command string) string {
+	return project + "\x00" + command
+}
+
+// readSharedProjectCommands lee .terminal-ia/comandos de la raíz de un proyecto y
+// devuelve sus comandos y el hash del archivo.
+func readSharedProjectCommands(project string) ([]string, string) {
+	data, err := os.ReadFile(filepath.Join(project, projectConfigDir, sharedCommandsFile))
+	if err != nil {
+		return nil, ""
+	}
+	sum := sha256.Sum256(data)
+
+	var commands []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		commands = append(commands, line)
+	}
+	return commands, hex.EncodeToString(sum[:])
+}
+
+// projectTrustPath devuelve la ruta del archivo con las decisiones de confianza.
+func projectTrustPath() (string, error) {
+	dataHome := os.Getenv("XDG_DATA_HOME")
+	if dataHome == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dataHome = filepath.Join(home, ".local", "share")
+	}
+	return filepath.Join(dataHome, configDirName, projectTrustFileName), nil
+}
+
+// loadProjectTrust lee las decisiones de confianza por proyecto (vacío si no existen).
+func loadProjectTrust() map[string]projectTrust {
+	trust := make(map[string]projectTrust)
+	path, err := projectTrustPath()
+	if err != nil {
+		return trust
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return trust
+	}
+	if err := json.Unmarshal(data, &trust); err != nil {
+		fmt.Fprintln(os.Stderr, cError(fmt.Sprintf("Error al leer %s: %v", path, err)))
+		return make(map[string]projectTrust)
+	}
+	return trust
+}
+
+// saveProjectTrust guarda las decisiones de confianza de forma atómica.
+func saveProjectTrust(trust map[string]projectTrust) {
+	path, err := projectTrustPath()
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return
+	}
+	data, err := json.Marshal(trust)
+	if err != nil {
+		return
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return
+	}
+	os.Rename(tmp, path)
+}
+
+// enqueueSharedProjectCommands encola los comandos compartidos del proyecto si el
+// usuario confía en esa versión del archivo; si no ha decidido todavía, se los muestra y
+// le pregunta. La cola descarta los que ya están en el historial, así que es seguro
+// llamarla cada vez que se entra en el proyecto.
+func enqueueSharedProjectCommands(state *liner.State, project string) {
+	if project == "" || semanticQueue == nil {
+		return
+	}
+	commands, hash := readSharedProjectCommands(project)
+	if len(commands) == 0 {
+		return
+	}
+	trust := loadProjectTrust()
+	decision, known := trust[project]
+	if !known || decision.Hash != hash {
+		fmt.Println(cIA(fmt.Sprintf("IA> %s comparte %d comandos en %s/%s:", projectDisplayName(project), len(commands), projectConfigDir, sharedCommandsFile)))
+		for i, command := range commands {
+			if i >= projectTrustMaxShown {
+				fmt.Println(cSystem(fmt.Sprintf("    ... y %d más", len(commands)-i)))
+				break
+			}
+			fmt.Println("    " + command)
+		}
+		answer, err := state.Prompt("IA> ¿Añadirlos a tu historial (aparecerán en /buscar y como ejemplos)? [s/N]: ")
+		if err != nil {
+			return // Sin respuesta no se recuerda nada: se volverá a preguntar
+		}
+		decision = projectTrust{Hash: hash, Trusted: strings.TrimSpace(strings.ToLower(answer)) == "s"}
+		trust[project] = decision
+		saveProjectTrust(trust)
+	}
+	if !decision.Trusted {
+		return
+	}
+	for _, command := range commands {
+		semanticQueue.Enqueue(queuedCommand{Command: command, Project: project})
+	}
+}
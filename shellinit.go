@@ -0,0 +1,403 @@
+// Copyright (c) 2025 Daniel Serrano Armenta. dani.eus79@gmail.com Todos los derechos reservados.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/ollama/ollama/api"
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// --- Integración con la Shell (bash, zsh, fish) ---
+//
+// "terminal-ia init bash|zsh|fish" imprime un fragmento para el rc de la shell con:
+//   - un atajo (Ctrl+G) que manda la línea actual, en lenguaje natural, a
+//     "terminal-ia suggest" y la sustituye por el comando sugerido para editarlo y
+//     ejecutarlo en la propia shell (nunca se ejecuta solo);
+//   - un hook tras cada comando que llama a "terminal-ia record" con el comando y su
+//     código de salida, para que los que terminan bien entren en el historial semántico.
+//
+// record no escribe el historial ni habla con Ollama: sólo deja el comando en
+// comandos-shell.jsonl. El proceso que "posee" el historial (la REPL o "terminal-ia
+// serve" que tomó el bloqueo al arrancar) vacía ese archivo en su cola de embeddings;
+// si no hay ninguno abierto, los comandos esperan al siguiente. Una segunda REPL (o un
+// serve) sin el bloqueo sólo lee el historial y manda también sus comandos a ese
+// archivo, y lo toma en cuanto el dueño se cierra. Así nunca hay dos procesos añadiendo
+// al historial y al índice a la vez. Mientras nadie lo vacía, el archivo no pasa de
+// shellSpoolMaxBytes: al llegar se descartan los comandos más antiguos.
+
+const (
+	shellSpoolFileName = "comandos-shell.jsonl"
+	semanticLockName   = "historial.lock"
+	shellSuggestModel  = "TERMINAL_IA_MODEL" // Variable con el modelo para suggest
+	shellSpoolMaxBytes = 4 * 1024 * 1024     // Al superarlo se conserva la mitad más reciente
+)
+
+// shellRecord es un comando ejecutado en la shell del usuario.
+type shellRecord struct {
+	Command  string `json:"command"`
+	ExitCode int    `json:"exit_code"`
+	Cwd      string `json:"cwd,omitempty"`
+	Project  string `json:"project,omitempty"`
+	Request  string `json:"request,omitempty"` // Petición de la IA, si vino de otra terminal-ia
+	Time     int64  `json:"ts"`
+}
+
+var (
+	semanticOwnerLock *os.File    // Abierto (y bloqueado) mientras este proceso posee el historial
+	semanticOwned     atomic.Bool // Se lee desde la cola de embeddings y los handlers de serve
+)
+
+// errSemanticNotOwner indica que otro proceso posee el historial semántico.
+var errSemanticNotOwner = errors.New("otro proceso de terminal-ia (otra terminal o serve) está usando el historial semántico")
+
+// ownsSemanticHistory indica si este proceso puede modificar el historial semántico.
+func ownsSemanticHistory() bool {
+	return semanticOwned.Load()
+}
+
+// shellIntegrationPath devuelve la ruta de un archivo de la integración con la shell.
+func shellIntegrationPath(name string) (string, error) {
+	dataHome := os.Getenv("XDG_DATA_HOME")
+	if dataHome == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dataHome = filepath.Join(home, ".local", "share")
+	}
+	return filepath.Join(dataHome, configDirName, name), nil
+}
+
+// acquireSemanticOwnership intenta (sin esperar) tomar el bloqueo del historial
+// semántico. Devuelve true si este proceso lo posee.
+func acquireSemanticOwnership() bool {
+	if semanticOwnerLock != nil {
+		return true
+	}
+	path, err := shellIntegrationPath(semanticLockName)
+	if err != nil {
+		return false
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return false
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return false
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		return false
+	}
+	semanticOwnerLock = f
+	semanticOwned.Store(true)
+	return true
+}
+
+// claimSemanticHistory toma el historial si todavía no es de este proceso. Al
+// conseguirlo lo vuelve a cargar: mientras era de otro, este pudo añadir comandos.
+func claimSemanticHistory() error {
+	if ownsSemanticHistory() {
+		return nil
+	}
+	if !acquireSemanticOwnership() {
+		return errSemanticNotOwner
+	}
+	if semanticHistoryPath != "" {
+		loadSemanticHistory()
+	}
+	return nil
+}
+
+// appendShellSpool añade un comando al archivo de pendientes.
+func appendShellSpool(rec shellRecord) error {
+	path, err := shellIntegrationPath(shellSpoolFileName)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		return err
+	}
+	if info, err := f.Stat(); err == nil && info.Size()+int64(len(line)) >= shellSpoolMaxBytes {
+		if err := trimShellSpool(f); err != nil {
+			return err
+		}
+	}
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
+
+// trimShellSpool deja en el archivo (ya bloqueado) sólo las líneas más recientes que
+// caben en la mitad de shellSpoolMaxBytes.
+func trimShellSpool(f *os.File) error {
+	data, err := io.ReadAll(io.NewSectionReader(f, 0, 1<<62))
+	if err != nil {
+		return err
+	}
+	keep := data[max(0, len(data)-shellSpoolMaxBytes/2):]
+	if i := bytes.IndexByte(keep, '\n'); i >= 0 && len(keep) < len(data) {
+		keep = keep[i+1:] // No conservar una línea a medias
+	}
+	if err := f.Truncate(0); err != nil {
+		return err
+	}
+	_, err = f.Write(keep)
+	return err
+}
+
+// drainShellSpool pasa los comandos pendientes de la shell a la cola de embeddings. Sólo
+// lo hace el proceso que posee el historial; los demás intentan tomarlo en cada llamada.
+func drainShellSpool() {
+	if semanticQueue == nil {
+		return
+	}
+	path, err := shellIntegrationPath(shellSpoolFileName)
+	if err != nil {
+		return
+	}
+	if info, err := os.Stat(path); err != nil || info.Size() == 0 || claimSemanticHistory() != nil {
+		return
+	}
+	f, err := os.OpenFile(path, os.O_RDWR, 0600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		return
+	}
+	data, err := io.ReadAll(f)
+	if err != nil || f.Truncate(0) != nil {
+		return
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var rec shellRecord
+		if json.Unmarshal(scanner.Bytes(), &rec) != nil || rec.ExitCode != 0 {
+			continue
+		}
+		semanticQueue.Enqueue(queuedCommand{Command: rec.Command, Project: rec.Project, Request: rec.Request})
+	}
+}
+
+// runInit implementa "terminal-ia init bash|zsh|fish".
+func runInit(args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, cError("Uso: terminal-ia init bash|zsh|fish"))
+		return 2
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		exe = "terminal-ia"
+	}
+	quoted, err := syntax.Quote(exe, syntax.LangBash)
+	if err != nil {
+		quoted = "terminal-ia"
+	}
+	var snippet string
+	switch args[0] {
+	case "bash":
+		snippet = bashInitSnippet
+	case "zsh":
+		snippet = zshInitSnippet
+	case "fish":
+		snippet = fishInitSnippet
+	default:
+		fmt.Fprintln(os.Stderr, cError(fmt.Sprintf("Shell no soportada: %s (usa bash, zsh o fish)", args[0])))
+		return 2
+	}
+	fmt.Print(strings.ReplaceAll(snippet, "@TERMINAL_IA@", quoted))
+	return 0
+}
+
+const bashInitSnippet = `# terminal-ia: añade a ~/.bashrc:  eval "$(terminal-ia init bash)"
+# Ctrl+G sustituye la línea (lenguaje natural) por el comando sugerido.
+__terminal_ia_suggest() {
+    local cmd
+    cmd=$(@TERMINAL_IA@ suggest -- "$READLINE_LINE" </dev/tty) || return
+    READLINE_LINE=$cmd
+    READLINE_POINT=${#cmd}
+}
+bind -x '"\C-g": __terminal_ia_suggest'
+
+# Tras cada comando, lo registra con su código de salida en el historial semántico. La
+# primera vez sólo toma el número de la última entrada del historial: es de la sesión
+# anterior (o el propio eval) y no acaba de ejecutarse.
+__terminal_ia_record() {
+    local ret=$? entry num cmd
+    entry=$(HISTTIMEFORMAT= builtin history 1)
+    entry=${entry#"${entry%%[![:space:]]*}"}
+    num=${entry%%[[:space:]]*}
+    cmd=${entry#"$num"}
+    cmd=${cmd#"${cmd%%[![:space:]]*}"}
+    if [[ -z ${__terminal_ia_last+x} ]]; then
+        __terminal_ia_last=$num
+    elif [[ -n $cmd && $num != "$__terminal_ia_last" ]]; then
+        __terminal_ia_last=$num
+        (@TERMINAL_IA@ record --exit "$ret" --cwd "$PWD" -- "$cmd" >/dev/null 2>&1 &)
+    fi
+    return $ret
+}
+if [[ ";${PROMPT_COMMAND[*]};" != *";__terminal_ia_record;"* ]]; then
+    PROMPT_COMMAND="__terminal_ia_record${PROMPT_COMMAND:+;$PROMPT_COMMAND}"
+fi
+`
+
+const zshInitSnippet = `# terminal-ia: añade a ~/.zshrc:  eval "$(terminal-ia init zsh)"
+# Ctrl+G sustituye la línea (lenguaje natural) por el comando sugerido.
+__terminal_ia_suggest() {
+    local cmd
+    zle -I
+    if cmd=$(@TERMINAL_IA@ suggest -- "$BUFFER" </dev/tty); then
+        BUFFER=$cmd
+        CURSOR=${#BUFFER}
+    fi
+    zle reset-prompt
+}
+zle -N __terminal_ia_suggest
+bindkey '^G' __terminal_ia_suggest
+
+# Tras cada comando, lo registra con su código de salida en el historial semántico.
+__terminal_ia_preexec() { __terminal_ia_cmd=$1 }
+__terminal_ia_precmd() {
+    local ret=$?
+    [[ -n $__terminal_ia_cmd ]] || return
+    (@TERMINAL_IA@ record --exit "$ret" --cwd "$PWD" -- "$__terminal_ia_cmd" >/dev/null 2>&1 &)
+    __terminal_ia_cmd=
+}
+autoload -Uz add-zsh-hook
+add-zsh-hook preexec __terminal_ia_preexec
+add-zsh-hook precmd __terminal_ia_precmd
+`
+
+const fishInitSnippet = `# terminal-ia: añade a ~/.config/fish/config.fish:  @TERMINAL_IA@ init fish | source
+# Ctrl+G sustituye la línea (lenguaje natural) por el comando sugerido.
+function __terminal_ia_suggest
+    set -l cmd (@TERMINAL_IA@ suggest -- (commandline | string collect) </dev/tty | string collect)
+    and commandline -r -- $cmd
+    commandline -f repaint
+end
+bind \cg __terminal_ia_suggest
+bind -M insert \cg __terminal_ia_suggest 2>/dev/null
+
+# Tras cada comando, lo registra con su código de salida en el historial semántico.
+function __terminal_ia_record --on-event fish_postexec
+    set -l ret $status
+    test -n "$argv[1]"; or return
+    @TERMINAL_IA@ record --exit $ret --cwd $PWD -- $argv[1] >/dev/null 2>&1 &
+    disown 2>/dev/null
+end
+`
+
+// runSuggest implementa "terminal-ia suggest <petición>": imprime sólo el comando
+// sugerido en stdout (los avisos van a stderr) y nunca lo ejecuta. Sale con 1 si no hay
+// sugerencia o si una política lo bloquea.
+func runSuggest(args []string) int {
+	flags := flag.NewFlagSet("suggest", flag.ContinueOnError)
+	model := flags.String("model", os.Getenv(shellSuggestModel), "modelo de Ollama")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	request := strings.TrimSpace(strings.Join(flags.Args(), " "))
+	if request == "" {
+		fmt.Fprintln(os.Stderr, cError("terminal-ia: escribe primero lo que quieres hacer"))
+		return 1
+	}
+
+	// Los mensajes de progreso de la generación no deben acabar en la línea de comandos
+	stdout := os.Stdout
+	if devNull, err := os.Open(os.DevNull); err == nil {
+		os.Stdout = devNull
+		defer devNull.Close()
+	}
+	defer func() { os.Stdout = stdout }()
+
+	client, err := api.ClientFromEnvironment()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, cError(fmt.Sprintf("terminal-ia: %v", err)))
+		return 1
+	}
+	if *model == "" {
+		if *model, err = defaultChatModel(client); err != nil {
+			fmt.Fprintln(os.Stderr, cError("terminal-ia: "+err.Error()))
+			return 1
+		}
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		semanticHistoryPath = filepath.Join(home, embeddingHistoryFile)
+		semanticIndexPath = filepath.Join(home, embeddingIndexFile)
+		loadSemanticHistoryReadOnly() // Para los ejemplos few-shot
+	}
+
+	fmt.Fprintln(os.Stderr, cSystem("terminal-ia: pensando..."))
+	suggestion, err := generateShellCommand(client, *model, request)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, cError(fmt.Sprintf("terminal-ia: error al contactar con Ollama: %v", err)))
+		return 1
+	}
+	if decision := evaluatePolicy(suggestion.Command); decision.Action == policyDeny {
+		fmt.Fprintln(os.Stderr, cError(fmt.Sprintf("terminal-ia: %s bloqueado por la política (%s)", suggestion.Command, decision.describe())))
+		return 1
+	}
+	for _, warning := range suggestion.Warnings {
+		fmt.Fprintln(os.Stderr, cError("Aviso: "+warning))
+	}
+	for _, problem := range suggestion.Problems {
+		fmt.Fprintln(os.Stderr, cError("Problema: "+problem))
+	}
+	fmt.Fprint(stdout, suggestion.Command)
+	return 0
+}
+
+// runRecord implementa "terminal-ia record --exit N --cwd DIR -- <comando>". Lo llama el
+// hook de la shell en segundo plano, así que no imprime nada salvo errores.
+func runRecord(args []string) int {
+	flags := flag.NewFlagSet("record", flag.ContinueOnError)
+	exitCode := flags.Int("exit", 0, "código de salida del comando")
+	cwd := flags.String("cwd", "", "directorio donde se ejecutó")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	command := strings.TrimSpace(strings.Join(flags.Args(), " "))
+	if *exitCode != 0 || !isIndexableCommand(command) || strings.HasPrefix(command, "terminal-ia") {
+		return 0 // Sólo los comandos que terminaron bien enseñan algo
+	}
+	if *cwd != "" {
+		os.Chdir(*cwd)
+	}
+	// Vectorizarlo e indexarlo le toca al proceso que posee el historial
+	rec := shellRecord{Command: command, ExitCode: *exitCode, Cwd: *cwd, Project: currentProject(), Time: time.Now().Unix()}
+	if err := appendShellSpool(rec); err != nil {
+		fmt.Fprintln(os.Stderr, cError(fmt.Sprintf("terminal-ia record: %v", err)))
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,49 @@
+// Copyright (c) 2025 Daniel Serrano Armenta. dani.eus79@gmail.com Todos los derechos reservados.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestAppendShellSpoolCapped(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+	long := strings.Repeat("x", 1000)
+	n := 2 * shellSpoolMaxBytes / len(long)
+	for i := range n {
+		if err := appendShellSpool(shellRecord{Command: fmt.Sprintf("echo %d %s", i, long)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	path, _ := shellIntegrationPath(shellSpoolFileName)
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() > shellSpoolMaxBytes {
+		t.Errorf("el spool ocupa %d bytes, más que el máximo %d", info.Size(), shellSpoolMaxBytes)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var last shellRecord
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if err := json.Unmarshal(scanner.Bytes(), &last); err != nil {
+			t.Fatalf("línea dañada tras recortar: %v", err)
+		}
+	}
+	if want := fmt.Sprintf("echo %d ", n-1); !strings.HasPrefix(last.Command, want) {
+		t.Errorf("el último comando es %.20q, se esperaba el más reciente (%q)", last.Command, want)
+	}
+}
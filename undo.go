@@ -0,0 +1,558 @@
+// Copyright (c) 2025 Daniel Serrano Armenta. dani.eus79@gmail.com Todos los derechos reservados.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/peterh/liner"
+)
+
+// --- Deshacer Cambios de Comandos de la IA (/deshacer) ---
+//
+// Antes de ejecutar un comando sugerido por la IA que modifica archivos del árbol del
+// CWD, las rutas afectadas (las mismas que calcula la vista previa) se copian a un
+// almacén direccionado por contenido en ~/.local/share/terminal-ia/undo:
+//
+//	objects/<sha256>    contenido de cada archivo (compartido entre instantáneas)
+//	sets/<id>.json      un "change set" por comando: ruta, tipo, modo y hash
+//
+// /deshacer restaura el último change set. Las rutas que no existían antes (destino de
+// un mv, archivo creado con >) se registran como ausentes y se borran al deshacer.
+// Al terminar el comando se guarda también cómo quedó cada ruta; antes de restaurar se
+// muestra el plan, se pide confirmación y las rutas que han cambiado desde entonces
+// (por ejemplo, un archivo que el usuario creó después) se dejan como están.
+
+const (
+	undoDirName         = "undo"
+	undoObjectsDir      = "objects"
+	undoSetsDir         = "sets"
+	defaultUndoMaxMB    = 200 // Tamaño máximo de una instantánea
+	defaultUndoKeep     = 20  // Change sets que se conservan
+	undoRetentionPeriod = 14 * 24 * time.Hour
+	undoMaxListed       = 10 // Rutas que se muestran de cada grupo en el plan de /deshacer
+)
+
+// undoEntry describe el estado previo de una ruta.
+type undoEntry struct {
+	Path   string      `json:"path"`
+	Kind   string      `json:"kind"` // "file", "dir", "symlink", "absent" u "other"
+	Mode   fs.FileMode `json:"mode,omitempty"`
+	Hash   string      `json:"hash,omitempty"`
+	Target string      `json:"target,omitempty"` // Destino del enlace simbólico
+	After  string      `json:"after,omitempty"`  // Huella de la ruta al terminar el comando
+}
+
+// undoSet es el conjunto de cambios de un comando.
+type undoSet struct {
+	ID        string      `json:"id"`
+	Command   string      `json:"command"`
+	Dir       string      `json:"dir"`
+	Timestamp int64       `json:"ts"`
+	Entries   []undoEntry `json:"entries"`
+}
+
+// undoStoreDir devuelve el directorio del almacén de instantáneas.
+func undoStoreDir() (string, error) {
+	dataHome := os.Getenv("XDG_DATA_HOME")
+	if dataHome == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dataHome = filepath.Join(home, ".local", "share")
+	}
+	return filepath.Join(dataHome, configDirName, undoDirName), nil
+}
+
+// undoCandidatePaths devuelve las rutas absolutas dentro del CWD que el comando puede
+// modificar, y las que quedan fuera (no se guardan).
+func undoCandidatePaths(command string) ([]string, []string) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return nil, nil
+	}
+	var inside, outside []string
+	seen := make(map[string]bool)
+	for _, action := range buildCommandPreview(command) {
+		for _, path := range append(append([]string{}, action.Paths...), action.Targets...) {
+			path = strings.TrimSuffix(path, "/")
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(cwd, path)
+			}
+			path = filepath.Clean(path)
+			if seen[path] {
+				continue
+			}
+			seen[path] = true
+			if path == cwd || !strings.HasPrefix(path, cwd+string(filepath.Separator)) {
+				outside = append(outside, path)
+			} else {
+				inside = append(inside, path)
+			}
+		}
+	}
+	return inside, outside
+}
+
+// snapshotBeforeCommand guarda una instantánea de las rutas que el comando va a
+// modificar. Devuelve nil si no hay nada que guardar o si la instantánea no es posible
+// (en ese caso avisa de que no se podrá deshacer).
+func snapshotBeforeCommand(command string) *undoSet {
+	paths, outside := undoCandidatePaths(command)
+	if len(outside) > 0 && len(paths) > 0 {
+		fmt.Println(cSystem(fmt.Sprintf("IA> Fuera del directorio actual, no se podrá deshacer: %s", strings.Join(outside, ", "))))
+	}
+	if len(paths) == 0 {
+		return nil
+	}
+
+	storeDir, err := undoStoreDir()
+	if err != nil {
+		return nil
+	}
+
+	// 1. Recorrer las rutas y comprobar el límite de tamaño antes de copiar nada
+	var entries []undoEntry
+	var total int64
+	limit := int64(appConfig.UndoMaxMB) * 1024 * 1024
+	for _, path := range paths {
+		info, err := os.Lstat(path)
+		if os.IsNotExist(err) {
+			entries = append(entries, undoEntry{Path: path, Kind: "absent"})
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if !info.IsDir() {
+			entries = append(entries, undoEntryFor(path, info))
+			total += info.Size()
+			continue
+		}
+		filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			fi, err := d.Info()
+			if err != nil {
+				return nil
+			}
+			entries = append(entries, undoEntryFor(p, fi))
+			if fi.Mode().IsRegular() {
+				total += fi.Size()
+			}
+			if total > limit {
+				return filepath.SkipAll
+			}
+			return nil
+		})
+	}
+	if total > limit {
+		fmt.Println(cError(fmt.Sprintf("IA> Los archivos afectados superan %d MB: no se guarda instantánea y no se podrá deshacer.", appConfig.UndoMaxMB)))
+		return nil
+	}
+
+	// 2. Copiar el contenido al almacén
+	objectsDir := filepath.Join(storeDir, undoObjectsDir)
+	if err := os.MkdirAll(objectsDir, 0700); err != nil {
+		fmt.Fprintln(os.Stderr, cError(fmt.Sprintf("Error al crear el almacén de instantáneas: %v", err)))
+		return nil
+	}
+	for i := range entries {
+		if entries[i].Kind != "file" {
+			continue
+		}
+		hash, err := storeUndoObject(objectsDir, entries[i].Path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, cError(fmt.Sprintf("Error al guardar instantánea de %s: %v", entries[i].Path, err)))
+			return nil
+		}
+		entries[i].Hash = hash
+	}
+
+	// 3. Guardar el change set
+	cwd, _ := os.Getwd()
+	now := time.Now()
+	set := &undoSet{
+		ID:        fmt.Sprintf("%d", now.UnixNano()),
+		Command:   command,
+		Dir:       cwd,
+		Timestamp: now.Unix(),
+		Entries:   entries,
+	}
+	if err := writeUndoSet(storeDir, set); err != nil {
+		fmt.Fprintln(os.Stderr, cError(fmt.Sprintf("Error al guardar instantánea: %v", err)))
+		return nil
+	}
+	pruneUndoStore(storeDir)
+	return set
+}
+
+// writeUndoSet guarda el change set en el almacén.
+func writeUndoSet(storeDir string, set *undoSet) error {
+	setsDir := filepath.Join(storeDir, undoSetsDir)
+	data, err := json.Marshal(set)
+	if err == nil {
+		err = os.MkdirAll(setsDir, 0700)
+	}
+	if err == nil {
+		err = os.WriteFile(filepath.Join(setsDir, set.ID+".json"), data, 0600)
+	}
+	return err
+}
+
+// finishUndoSnapshot guarda cómo quedó cada ruta al terminar el comando, para que
+// /deshacer no pise cambios posteriores.
+func finishUndoSnapshot(set *undoSet) {
+	storeDir, err := undoStoreDir()
+	if err != nil {
+		return
+	}
+	for i := range set.Entries {
+		set.Entries[i].After = undoFingerprint(set.Entries[i].Path, set.Entries[i].Kind == "absent")
+	}
+	if err := writeUndoSet(storeDir, set); err != nil {
+		fmt.Fprintln(os.Stderr, cError(fmt.Sprintf("Error al guardar instantánea: %v", err)))
+	}
+}
+
+// undoFingerprint resume el estado actual de una ruta: tipo, tamaño, modo y fecha de
+// modificación. Con deep, un directorio incluye la huella de todo su contenido (es lo
+// que se borraría al deshacer una ruta ausente).
+func undoFingerprint(path string, deep bool) string {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return "absent"
+	}
+	if err != nil {
+		return "error"
+	}
+	switch {
+	case info.Mode()&fs.ModeSymlink != 0:
+		target, _ := os.Readlink(path)
+		return "symlink:" + target
+	case info.Mode().IsRegular():
+		return fmt.Sprintf("file:%d:%o:%d", info.Size(), info.Mode().Perm(), info.ModTime().UnixNano())
+	case !info.IsDir():
+		return "other"
+	case !deep:
+		return "dir"
+	}
+	sum := sha256.New()
+	filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		rel, _ := filepath.Rel(path, p)
+		fmt.Fprintf(sum, "%s\x00%s\x00", rel, undoFingerprint(p, false))
+		return nil
+	})
+	return "dir:" + hex.EncodeToString(sum.Sum(nil))
+}
+
+// undoEntryFor construye la entrada de una ruta existente (sin copiar su contenido).
+func undoEntryFor(path string, info fs.FileInfo) undoEntry {
+	switch {
+	case info.Mode()&fs.ModeSymlink != 0:
+		target, _ := os.Readlink(path)
+		return undoEntry{Path: path, Kind: "symlink", Target: target}
+	case info.IsDir():
+		return undoEntry{Path: path, Kind: "dir", Mode: info.Mode().Perm()}
+	case info.Mode().IsRegular():
+		return undoEntry{Path: path, Kind: "file", Mode: info.Mode().Perm()}
+	default:
+		return undoEntry{Path: path, Kind: "other"} // FIFOs, sockets, dispositivos: no se guardan
+	}
+}
+
+// storeUndoObject copia un archivo al almacén con su hash como nombre.
+func storeUndoObject(objectsDir string, path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	objectPath := filepath.Join(objectsDir, hash)
+	if _, err := os.Stat(objectPath); err == nil {
+		return hash, nil // Ya guardado por otra instantánea
+	}
+	tmp, err := os.CreateTemp(objectsDir, ".tmp-*")
+	if err != nil {
+		return "", err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	tmp.Close()
+	return hash, os.Rename(tmp.Name(), objectPath)
+}
+
+// listUndoSets devuelve los change sets guardados, del más reciente al más antiguo.
+func listUndoSets(storeDir string) []undoSet {
+	files, err := os.ReadDir(filepath.Join(storeDir, undoSetsDir))
+	if err != nil {
+		return nil
+	}
+	var sets []undoSet
+	for _, f := range files {
+		if !strings.HasSuffix(f.Name(), ".json") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(storeDir, undoSetsDir, f.Name()))
+		if err != nil {
+			continue
+		}
+		var set undoSet
+		if json.Unmarshal(data, &set) == nil {
+			sets = append(sets, set)
+		}
+	}
+	sort.Slice(sets, func(i, j int) bool { return sets[i].ID > sets[j].ID })
+	return sets
+}
+
+// pruneUndoStore aplica la política de retención: conserva los últimos UndoKeep change
+// sets de menos de undoRetentionPeriod y borra los objetos que ya nadie referencia.
+func pruneUndoStore(storeDir string) {
+	sets := listUndoSets(storeDir)
+	cutoff := time.Now().Add(-undoRetentionPeriod).Unix()
+	referenced := make(map[string]bool)
+	for i, set := range sets {
+		if i >= appConfig.UndoKeep || set.Timestamp < cutoff {
+			os.Remove(filepath.Join(storeDir, undoSetsDir, set.ID+".json"))
+			continue
+		}
+		for _, e := range set.Entries {
+			if e.Hash != "" {
+				referenced[e.Hash] = true
+			}
+		}
+	}
+	objects, _ := os.ReadDir(filepath.Join(storeDir, undoObjectsDir))
+	for _, obj := range objects {
+		if !referenced[obj.Name()] {
+			os.Remove(filepath.Join(storeDir, undoObjectsDir, obj.Name()))
+		}
+	}
+}
+
+// undoPlan separa las entradas de un change set según lo que hará /deshacer.
+type undoPlan struct {
+	Remove  []undoEntry // No existían antes: se borran
+	Restore []undoEntry // Se devuelven a su estado previo
+	Stale   []undoEntry // Han cambiado desde el comando: se dejan como están
+	Checked bool        // false si el change set no guardó el estado posterior
+}
+
+// planUndo compara cada ruta con cómo la dejó el comando. Las que ya no coinciden se
+// apartan en Stale para no borrar ni pisar cambios posteriores.
+func planUndo(set undoSet) undoPlan {
+	plan := undoPlan{Checked: true}
+	for _, e := range set.Entries {
+		switch {
+		case e.After == "":
+			plan.Checked = false
+		case undoFingerprint(e.Path, e.Kind == "absent") != e.After:
+			plan.Stale = append(plan.Stale, e)
+			continue
+		}
+		switch e.Kind {
+		case "absent":
+			if _, err := os.Lstat(e.Path); err == nil {
+				plan.Remove = append(plan.Remove, e)
+			}
+		case "dir", "file", "symlink":
+			plan.Restore = append(plan.Restore, e)
+		}
+	}
+	return plan
+}
+
+// entries devuelve las entradas que se van a aplicar, en el orden del change set.
+func (p undoPlan) entries(set undoSet) []undoEntry {
+	apply := make(map[string]bool)
+	for _, e := range append(append([]undoEntry{}, p.Remove...), p.Restore...) {
+		apply[e.Path] = true
+	}
+	var entries []undoEntry
+	for _, e := range set.Entries {
+		if apply[e.Path] {
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
+
+// printUndoPlan muestra qué rutas se borrarán, restaurarán o dejarán como están.
+func printUndoPlan(set undoSet, plan undoPlan) {
+	when := time.Unix(set.Timestamp, 0).Format("2006-01-02 15:04")
+	fmt.Println(cIA(fmt.Sprintf("IA> Deshacer: %s", set.Command)))
+	fmt.Println(cSystem(fmt.Sprintf("    Ejecutado el %s en %s", when, set.Dir)))
+	printUndoPaths := func(title string, entries []undoEntry, color func(...interface{}) string) {
+		if len(entries) == 0 {
+			return
+		}
+		fmt.Println(color(fmt.Sprintf("  %s (%d):", title, len(entries))))
+		for i, e := range entries {
+			if i >= undoMaxListed {
+				fmt.Println(cSystem(fmt.Sprintf("    ... y %d más", len(entries)-i)))
+				break
+			}
+			path := e.Path
+			if rel, err := filepath.Rel(set.Dir, e.Path); err == nil && !strings.HasPrefix(rel, "..") {
+				path = rel
+			}
+			fmt.Println("    " + path)
+		}
+	}
+	printUndoPaths("Se borrarán (no existían antes del comando)", plan.Remove, cError)
+	printUndoPaths("Se restaurarán", plan.Restore, cSystem)
+	printUndoPaths("Han cambiado después del comando, se dejan como están", plan.Stale, cError)
+	if !plan.Checked {
+		fmt.Println(cError("  No se guardó cómo quedaron los archivos tras el comando: no se puede comprobar si han cambiado después."))
+	}
+}
+
+// restoreUndoSet devuelve las rutas del change set a su estado previo.
+func restoreUndoSet(storeDir string, set undoSet) error {
+	// 1. Borrar lo que no existía antes (de lo más profundo a lo más superficial)
+	for i := len(set.Entries) - 1; i >= 0; i-- {
+		if e := set.Entries[i]; e.Kind == "absent" {
+			if err := os.RemoveAll(e.Path); err != nil {
+				return err
+			}
+		}
+	}
+	// 2. Recrear directorios, archivos y enlaces en orden (padres antes que hijos)
+	for _, e := range set.Entries {
+		switch e.Kind {
+		case "dir":
+			if info, err := os.Lstat(e.Path); err == nil && !info.IsDir() {
+				os.Remove(e.Path)
+			}
+			if err := os.MkdirAll(e.Path, 0700); err != nil {
+				return err
+			}
+		case "file":
+			if err := restoreUndoObject(storeDir, e); err != nil {
+				return err
+			}
+		case "symlink":
+			os.RemoveAll(e.Path)
+			if err := os.MkdirAll(filepath.Dir(e.Path), 0755); err != nil {
+				return err
+			}
+			if err := os.Symlink(e.Target, e.Path); err != nil {
+				return err
+			}
+		}
+	}
+	// 3. Permisos de los directorios al final, por si alguno no admite escritura
+	for i := len(set.Entries) - 1; i >= 0; i-- {
+		if e := set.Entries[i]; e.Kind == "dir" {
+			os.Chmod(e.Path, e.Mode)
+		}
+	}
+	return nil
+}
+
+// restoreUndoObject escribe el contenido guardado de un archivo en su ruta original.
+func restoreUndoObject(storeDir string, e undoEntry) error {
+	src, err := os.Open(filepath.Join(storeDir, undoObjectsDir, e.Hash))
+	if err != nil {
+		return fmt.Errorf("falta la copia de %s: %v", e.Path, err)
+	}
+	defer src.Close()
+	if info, err := os.Lstat(e.Path); err == nil && (info.IsDir() || info.Mode()&fs.ModeSymlink != 0) {
+		os.RemoveAll(e.Path)
+	}
+	if err := os.MkdirAll(filepath.Dir(e.Path), 0755); err != nil {
+		return err
+	}
+	dst, err := os.OpenFile(e.Path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, e.Mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	return os.Chmod(e.Path, e.Mode)
+}
+
+// handleUndoCommand implementa "/deshacer" (restaura el último change set tras mostrar
+// el plan y pedir confirmación) y "/deshacer lista".
+func handleUndoCommand(state *liner.State, args string) {
+	storeDir, err := undoStoreDir()
+	if err != nil {
+		fmt.Println(cError(fmt.Sprintf("Error al localizar el almacén de instantáneas: %v", err)))
+		fmt.Println()
+		return
+	}
+	sets := listUndoSets(storeDir)
+
+	switch args {
+	case "lista":
+		if len(sets) == 0 {
+			fmt.Println(cSystem("IA> No hay cambios que deshacer."))
+		}
+		for i, set := range sets {
+			when := time.Unix(set.Timestamp, 0).Format("2006-01-02 15:04")
+			fmt.Printf("%s %s %s\n", cPrompt(fmt.Sprintf("  %2d.", i+1)), cSystem(when), set.Command)
+		}
+		fmt.Println()
+		return
+	case "":
+	default:
+		fmt.Println(cError("Uso: /deshacer [lista]"))
+		fmt.Println()
+		return
+	}
+
+	if len(sets) == 0 {
+		fmt.Println(cSystem("IA> No hay cambios que deshacer."))
+		fmt.Println()
+		return
+	}
+	set := sets[0]
+	plan := planUndo(set)
+	printUndoPlan(set, plan)
+	if len(plan.Remove) == 0 && len(plan.Restore) == 0 {
+		fmt.Println(cSystem("IA> No queda nada que deshacer."))
+		fmt.Println()
+		return
+	}
+	answer, err := state.Prompt("IA> ¿Deshacer? [s/N]: ")
+	if err != nil || strings.TrimSpace(strings.ToLower(answer)) != "s" {
+		fmt.Println(cSystem("IA> Cancelado."))
+		fmt.Println()
+		return
+	}
+	applied := set
+	applied.Entries = plan.entries(set)
+	if err := restoreUndoSet(storeDir, applied); err != nil {
+		fmt.Println(cError(fmt.Sprintf("Error al deshacer '%s': %v", set.Command, err)))
+		fmt.Println()
+		return
+	}
+	os.Remove(filepath.Join(storeDir, undoSetsDir, set.ID+".json"))
+	pruneUndoStore(storeDir)
+
+	fmt.Println(cIA(fmt.Sprintf("IA> Deshecho: %s", set.Command)))
+	fmt.Println(cSystem(fmt.Sprintf("    %d rutas restauradas en %s", len(applied.Entries), set.Dir)))
+	fmt.Println()
+}
@@ -0,0 +1,98 @@
+// Copyright (c) 2025 Daniel Serrano Armenta. dani.eus79@gmail.com Todos los derechos reservados.
+
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+// withUndoStore usa un almacén de instantáneas y un CWD temporales durante el test.
+func withUndoStore(t *testing.T) {
+	t.Helper()
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+	t.Chdir(t.TempDir())
+	saved := appConfig
+	t.Cleanup(func() { appConfig = saved })
+	appConfig.UndoMaxMB = 10
+	appConfig.UndoKeep = 5
+}
+
+func TestUndoRestoresMove(t *testing.T) {
+	withUndoStore(t)
+	if err := os.WriteFile("a.txt", []byte("original"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	set := snapshotBeforeCommand("mv a.txt b.txt")
+	if set == nil {
+		t.Fatal("no se guardó la instantánea")
+	}
+	if err := os.Rename("a.txt", "b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	finishUndoSnapshot(set)
+
+	plan := planUndo(*set)
+	if len(plan.Stale) != 0 || len(plan.Remove) != 1 || len(plan.Restore) != 1 || !plan.Checked {
+		t.Fatalf("plan = %+v", plan)
+	}
+	storeDir, _ := undoStoreDir()
+	applied := *set
+	applied.Entries = plan.entries(*set)
+	if err := restoreUndoSet(storeDir, applied); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile("a.txt"); err != nil || string(data) != "original" {
+		t.Errorf("a.txt = %q, %v", data, err)
+	}
+	if _, err := os.Stat("b.txt"); !os.IsNotExist(err) {
+		t.Error("b.txt debería haberse borrado")
+	}
+}
+
+func TestUndoSkipsLaterChanges(t *testing.T) {
+	withUndoStore(t)
+	if err := os.WriteFile("a.txt", []byte("original"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	set := snapshotBeforeCommand("mv a.txt b.txt")
+	if set == nil {
+		t.Fatal("no se guardó la instantánea")
+	}
+	if err := os.Rename("a.txt", "b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	finishUndoSnapshot(set)
+
+	// Después del comando el usuario edita b.txt y crea otro a.txt
+	if err := os.WriteFile("b.txt", []byte("trabajo nuevo"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile("a.txt", []byte("otro"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	plan := planUndo(*set)
+	if len(plan.Stale) != 2 || len(plan.Remove) != 0 || len(plan.Restore) != 0 {
+		t.Fatalf("plan = %+v, se esperaban dos rutas cambiadas y nada que aplicar", plan)
+	}
+}
+
+func TestUndoFingerprintDeep(t *testing.T) {
+	withUndoStore(t)
+	if err := os.MkdirAll("dir/sub", 0755); err != nil {
+		t.Fatal(err)
+	}
+	before := undoFingerprint("dir", true)
+	if undoFingerprint("dir", false) != "dir" {
+		t.Error("sin deep un directorio sólo indica su tipo")
+	}
+	if err := os.WriteFile("dir/sub/nuevo", []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if undoFingerprint("dir", true) == before {
+		t.Error("un archivo nuevo dentro del directorio debería cambiar la huella")
+	}
+	if undoFingerprint("no-existe", true) != "absent" {
+		t.Error("una ruta inexistente debería ser absent")
+	}
+}
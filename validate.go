@@ -0,0 +1,157 @@
+// Copyright (c) 2025 Daniel Serrano Armenta. dani.eus79@gmail.com Todos los derechos reservados.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// --- Validación Estática de Comandos Generados ---
+//
+// Antes de mostrar (o ejecutar en modo auto) un comando de la IA se analiza con un
+// parser de shell real: errores de sintaxis, comillas sin cerrar, líneas de texto que
+// el modelo dejó junto al comando, marcadores de plantilla (<archivo>, /path/to/) y
+// programas que no existen en el PATH.
+
+const validationMaxRetries = 1 // Regeneraciones automáticas si el comando tiene problemas
+
+var (
+	// placeholderPatterns detectan valores de ejemplo que el modelo no ha sustituido. Si
+	// tienen un grupo, el marcador es el grupo. "<archivo>" no admite espacios ni puntos
+	// ni puede ir pegado a otra palabra, para no confundirlo con las redirecciones
+	// ("sort <entrada.txt >salida.txt", "cat <a>b").
+	placeholderPatterns = []*regexp.Regexp{
+		regexp.MustCompile(`(<[A-Za-zÁÉÍÓÚáéíóúñÑ_][A-Za-zÁÉÍÓÚáéíóúñÑ0-9_-]*>)(?:[^\pL0-9_]|$)`),
+		regexp.MustCompile(`\{\{[^}]*\}\}`),
+		regexp.MustCompile(`(?i)/(?:path|ruta)/(?:to|a|al|hacia)/`),
+		regexp.MustCompile(`\b(?:YOUR|your|TU|tu)_[A-Za-z_]+\b`),
+	}
+	// proseLinePattern reconoce líneas que parecen una frase y no un comando.
+	proseLinePattern = regexp.MustCompile(`^[A-ZÁÉÍÓÚ¿¡][a-záéíóúñ]+(\s+[\p{L}0-9,'()]+){2,}[.:!?]?$`)
+)
+
+// shellBuiltins son las órdenes internas de bash: no están (o no tienen por qué estar)
+// en el PATH.
+var shellBuiltins = map[string]bool{
+	".": true, ":": true, "[": true, "alias": true, "bg": true, "bind": true, "break": true,
+	"builtin": true, "caller": true, "cd": true, "command": true, "compgen": true,
+	"complete": true, "continue": true, "declare": true, "dirs": true, "disown": true,
+	"echo": true, "enable": true, "eval": true, "exec": true, "exit": true, "export": true,
+	"false": true, "fc": true, "fg": true, "getopts": true, "hash": true, "help": true,
+	"history": true, "jobs": true, "kill": true, "let": true, "local": true, "logout": true,
+	"mapfile": true, "popd": true, "printf": true, "pushd": true, "pwd": true, "read": true,
+	"readarray": true, "readonly": true, "return": true, "set": true, "shift": true,
+	"shopt": true, "source": true, "suspend": true, "test": true, "times": true, "trap": true,
+	"true": true, "type": true, "typeset": true, "ulimit": true, "umask": true, "unalias": true,
+	"unset": true, "wait": true,
+}
+
+// validateShellCommand devuelve la lista de problemas encontrados en el comando.
+func validateShellCommand(command string) []string {
+	var problems []string
+	if strings.TrimSpace(command) == "" {
+		return []string{"El modelo no ha devuelto ningún comando."}
+	}
+
+	for i, line := range strings.Split(command, "\n") {
+		line = strings.TrimSpace(line)
+		if proseLinePattern.MatchString(line) {
+			if first, _, _ := strings.Cut(line, " "); !commandExists(first) {
+				problems = append(problems, fmt.Sprintf("La línea %d parece texto, no un comando: %q", i+1, line))
+			}
+		}
+	}
+	for _, pattern := range placeholderPatterns {
+		if m := pattern.FindStringSubmatch(command); m != nil {
+			problems = append(problems, fmt.Sprintf("Contiene un marcador de ejemplo sin sustituir: %s", m[len(m)-1]))
+		}
+	}
+
+	file, err := syntax.NewParser(syntax.Variant(syntax.LangBash)).Parse(strings.NewReader(command), "")
+	if err != nil {
+		return append(problems, fmt.Sprintf("Error de sintaxis: %v", err))
+	}
+
+	for _, name := range missingCommands(file) {
+		problems = append(problems, fmt.Sprintf("No se encuentra el programa '%s' en el PATH.", name))
+	}
+	return problems
+}
+
+// missingCommands recorre el árbol sintáctico y devuelve los programas invocados que no
+// son órdenes internas, funciones definidas en el propio comando ni ejecutables del PATH.
+func missingCommands(file *syntax.File) []string {
+	defined := make(map[string]bool)
+	syntax.Walk(file, func(node syntax.Node) bool {
+		if fn, ok := node.(*syntax.FuncDecl); ok {
+			defined[fn.Name.Value] = true
+		}
+		return true
+	})
+
+	var missing []string
+	seen := make(map[string]bool)
+	syntax.Walk(file, func(node syntax.Node) bool {
+		call, ok := node.(*syntax.CallExpr)
+		if !ok {
+			return true
+		}
+		for _, arg := range call.Args {
+			name := arg.Lit()
+			if name == "" {
+				break // Expansiones ($CMD, $(...)): no se puede saber estáticamente
+			}
+			if strings.Contains(name, "=") || isNumericWord(name) {
+				continue // env VAR=x cmd, timeout 5 cmd
+			}
+			if strings.HasPrefix(name, "-") {
+				break // sudo -u usuario cmd: no adivinamos qué opciones llevan valor
+			}
+			if !seen[name] && !defined[name] && !commandExists(name) {
+				seen[name] = true
+				missing = append(missing, name)
+			}
+			if !commandWrappers[name] {
+				break
+			}
+		}
+		return true
+	})
+	return missing
+}
+
+// commandExists comprueba si un nombre es una orden interna o un ejecutable.
+func commandExists(name string) bool {
+	if shellBuiltins[name] {
+		return true
+	}
+	if strings.Contains(name, "/") {
+		info, err := os.Stat(name)
+		return err == nil && !info.IsDir() && info.Mode()&0111 != 0
+	}
+	_, err := exec.LookPath(name)
+	return err == nil
+}
+
+// isNumericWord indica si una palabra es un número (p.ej. el plazo de "timeout 5").
+func isNumericWord(word string) bool {
+	for _, r := range word {
+		if (r < '0' || r > '9') && r != '.' {
+			return false
+		}
+	}
+	return word != ""
+}
+
+// printSuggestionProblems muestra los problemas de validación de un comando sugerido.
+func printSuggestionProblems(suggestion commandSuggestion) {
+	for _, problem := range suggestion.Problems {
+		fmt.Println(cError("Problema: " + problem))
+	}
+}
@@ -0,0 +1,46 @@
+// Copyright (c) 2025 Daniel Serrano Armenta. dani.eus79@gmail.com Todos los derechos reservados.
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidatePlaceholders(t *testing.T) {
+	tests := []struct {
+		command     string
+		placeholder string // "" si no debe detectarse ninguno
+	}{
+		// Redirecciones, no marcadores
+		{"sort <input.txt >out.txt", ""},
+		{"cat <a.txt >b.txt", ""},
+		{"cat <a.txt>b.txt", ""},
+		{"sort <entrada >salida", ""},
+		{"cat <a>b", ""},
+		{"wc -l < datos.csv", ""},
+		{"diff <(ls a) <(ls b)", ""},
+
+		// Marcadores reales
+		{"cp <origen> <destino>", "<origen>"},
+		{"ssh <usuario>@servidor", "<usuario>"},
+		{"cat /home/<usuario>/notas", "<usuario>"},
+		{"git checkout <nombre-rama>", "<nombre-rama>"},
+		{"rm <archivo>", "<archivo>"},
+		{"kubectl logs {{pod}}", "{{pod}}"},
+		{"cd /path/to/dir", "/path/to/"},
+		{"export TOKEN=YOUR_TOKEN", "YOUR_TOKEN"},
+	}
+	for _, tt := range tests {
+		var got string
+		for _, problem := range validateShellCommand(tt.command) {
+			if m, ok := strings.CutPrefix(problem, "Contiene un marcador de ejemplo sin sustituir: "); ok {
+				got = m
+				break
+			}
+		}
+		if got != tt.placeholder {
+			t.Errorf("validateShellCommand(%q): marcador %q, se esperaba %q", tt.command, got, tt.placeholder)
+		}
+	}
+}